@@ -4,6 +4,10 @@ import (
 	"context"
 )
 
+// MaxRequestIDLength is the max number of bytes in a signup request ID chosen by a client.
+// IDs generated by the client package are 27 characters long.
+const MaxRequestIDLength = 64
+
 // SignupRequest is a user's intention to sign up.
 // It is not necessarily a signup request will be granted. For instance, if a username is already claimed,
 // a duplicate user won't be created.
@@ -16,6 +20,8 @@ type SignupRequest struct {
 	Partition int32 `json:"-"`
 	// SequenceID is ID assigned internally. For example, in Kafka it is an offset of the message.
 	SequenceID int64 `json:"-"`
+	// Retries is a number of times the request was postponed by a Retrier.
+	Retries int `json:"-"`
 }
 
 // SignupResponse represents a server answer to a SignupRequest.
@@ -26,12 +32,46 @@ type SignupResponse struct {
 	Username string `json:"username"`
 	// Success indicates whether a signup request was successful.
 	Success bool `json:"success"`
+	// Reason explains why a signup request failed, see FailureReason.
+	// It is omitted on success, so clients which only read success are not affected.
+	Reason Reason `json:"reason,omitempty"`
+	// Message is an optional human-readable explanation of the reason, e.g., username is too long.
+	Message string `json:"message,omitempty"`
 	// Partition is a number of a partition where the signup response was stored.
 	Partition int32 `json:"-"`
 	// SequenceID is ID assigned internally. For example, in Kafka it is an offset of the message.
 	SequenceID int64 `json:"-"`
 }
 
+// FailureReason returns the reason why the signup request failed or a blank reason on success.
+// Responses emitted before reasons were introduced have no reason, and they failed
+// only because the username was taken.
+func (r *SignupResponse) FailureReason() Reason {
+	if r.Success {
+		return ""
+	}
+	if r.Reason == "" {
+		return ReasonTaken
+	}
+	return r.Reason
+}
+
+// Reason explains why a signup request failed.
+type Reason string
+
+const (
+	// ReasonTaken indicates that the username is already claimed by another user.
+	ReasonTaken Reason = "taken"
+	// ReasonInvalid indicates that the username violates the username policy, see UsernamePolicy.
+	ReasonInvalid Reason = "invalid"
+	// ReasonReserved indicates that the username is reserved or blocked.
+	ReasonReserved Reason = "reserved"
+	// ReasonRateLimited indicates that the user sent too many signup requests.
+	ReasonRateLimited Reason = "rate_limited"
+	// ReasonInternal indicates that the signup request couldn't be processed because of a server error.
+	ReasonInternal Reason = "internal"
+)
+
 // User represents a signed up user.
 type User struct {
 	// ID of a user assigned internally by a service, e.g., UUID.
@@ -43,6 +83,9 @@ type User struct {
 type UserService interface {
 	CreateUser(ctx context.Context, u *User) error
 	ByUsername(ctx context.Context, username string) (*User, error)
+	// ClaimUsername atomically creates the user u unless its username is already claimed.
+	// When the username is taken, created is false and existing is the user who owns it.
+	ClaimUsername(ctx context.Context, u *User) (created bool, existing *User, err error)
 }
 
 // SignupService represents a service where a user can sign up by creating a request.
@@ -50,8 +93,58 @@ type UserService interface {
 type SignupService interface {
 	CreateRequest(ctx context.Context, req *SignupRequest) error
 	// Requests calls f to process signup requests as they arrive.
-	Requests(ctx context.Context, f func(req *SignupRequest)) error
+	// The context passed to f is done when the request should no longer be processed,
+	// e.g., its partition is reassigned to another server, even though ctx isn't.
+	Requests(ctx context.Context, f func(ctx context.Context, req *SignupRequest)) error
 	CreateResponse(ctx context.Context, resp *SignupResponse) error
 	// Responses calls f to process signup responses as they arrive.
 	Responses(ctx context.Context, f func(req *SignupResponse)) error
 }
+
+// DedupStore keeps responses emitted for signup requests, so a request ID always gets the same response,
+// e.g., when signup requests are replayed from Kafka.
+type DedupStore interface {
+	// Response returns a response recorded for the signup request ID or ErrResponseNotFound.
+	Response(ctx context.Context, requestID string) (*SignupResponse, error)
+	// SaveResponse records a response emitted for the signup request.
+	// It returns ErrDuplicateRequest if a response to the request ID has been already recorded.
+	SaveResponse(ctx context.Context, req *SignupRequest, resp *SignupResponse) error
+}
+
+// OffsetStore keeps the latest offset of a processed signup request per partition,
+// so a server can resume reading requests where it left off.
+type OffsetStore interface {
+	// Offset returns the latest offset of a signup request processed from the partition
+	// or ErrOffsetNotFound if no request has been recorded yet.
+	Offset(ctx context.Context, partition int32) (int64, error)
+}
+
+// OffsetSaver is an optional interface implemented by an OffsetStore which can record the offset
+// of a signup request which got no response, e.g., a malformed request forwarded to a dead-letter topic,
+// so the request is not read again when a server resumes reading the partition.
+type OffsetSaver interface {
+	// SaveOffset records the offset unless a later one has been recorded.
+	SaveOffset(ctx context.Context, partition int32, offset int64) error
+}
+
+// SignupClaimer is an optional interface implemented by a DedupStore which can claim a username
+// and record the response to the signup request atomically, e.g., in one database transaction.
+// That gives exactly-once effect per request ID: a user is never created without the response being recorded.
+type SignupClaimer interface {
+	// ClaimSignup claims a username the same way UserService.ClaimUsername does
+	// and records the outcome as a response to the signup request.
+	ClaimSignup(ctx context.Context, req *SignupRequest, u *User) (created bool, existing *User, err error)
+}
+
+// SignupMarker is an optional interface implemented by a DedupStore which records responses apart from UserService,
+// e.g., in a local file. The ID of a user is marked as pending before its username is claimed,
+// so if a server crashes after the user is created but before the response is recorded,
+// the replayed request recognizes the user as its own and succeeds instead of failing with the taken reason.
+type SignupMarker interface {
+	// PendingSignup returns the ID of the user which the signup request was about to create
+	// or a blank string if the request hasn't been marked.
+	PendingSignup(ctx context.Context, requestID string) (userID string, err error)
+	// MarkSignup records that the signup request is about to create the user with the given ID.
+	// The mark is removed when a response to the request is recorded.
+	MarkSignup(ctx context.Context, req *SignupRequest, userID string) error
+}
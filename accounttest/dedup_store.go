@@ -0,0 +1,212 @@
+package accounttest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/marselester/distributed-signup"
+)
+
+// OpenDedupStore returns an empty DedupStore and a function to release its resources.
+type OpenDedupStore func(t *testing.T) (account.DedupStore, func())
+
+// TestDedupStore runs the DedupStore conformance tests.
+// If a store implements account.SignupClaimer or account.SignupMarker, it is tested as well.
+// Every test opens a new DedupStore using open.
+func TestDedupStore(t *testing.T, open OpenDedupStore) {
+	tests := []struct {
+		name string
+		test func(*testing.T, account.DedupStore)
+	}{
+		{"SaveResponse", testSaveResponse},
+		{"SaveResponseDuplicate", testSaveResponseDuplicate},
+		{"SaveResponseReason", testSaveResponseReason},
+		{"ResponseNotFound", testResponseNotFound},
+		{"ClaimSignup", testClaimSignup},
+		{"ClaimSignupDuplicate", testClaimSignupDuplicate},
+		{"MarkSignup", testMarkSignup},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s, close := open(t)
+			defer close()
+			tc.test(t, s)
+		})
+	}
+}
+
+func testSaveResponse(t *testing.T, s account.DedupStore) {
+	ctx := context.Background()
+	req := account.SignupRequest{ID: "13rUw7cUfrGO9Go9xbZearzuuAu", Username: "bob", Partition: 2, SequenceID: 3}
+	want := account.SignupResponse{RequestID: req.ID, Username: req.Username, Success: true}
+	if err := s.SaveResponse(ctx, &req, &want); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.Response(ctx, req.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != want {
+		t.Errorf("Response(%s) = %+v, want %+v", req.ID, got, want)
+	}
+}
+
+func testSaveResponseDuplicate(t *testing.T, s account.DedupStore) {
+	ctx := context.Background()
+	req := account.SignupRequest{ID: "13rUw7cUfrGO9Go9xbZearzuuAu", Username: "bob"}
+	want := account.SignupResponse{RequestID: req.ID, Username: req.Username, Success: true}
+	if err := s.SaveResponse(ctx, &req, &want); err != nil {
+		t.Fatal(err)
+	}
+
+	other := account.SignupResponse{RequestID: req.ID, Username: req.Username, Success: false}
+	if err := s.SaveResponse(ctx, &req, &other); err != account.ErrDuplicateRequest {
+		t.Errorf("SaveResponse(%+v) = %v, must be ErrDuplicateRequest", other, err)
+	}
+
+	got, err := s.Response(ctx, req.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != want {
+		t.Errorf("Response(%s) = %+v, the first response %+v must be kept", req.ID, got, want)
+	}
+}
+
+func testSaveResponseReason(t *testing.T, s account.DedupStore) {
+	ctx := context.Background()
+	req := account.SignupRequest{ID: "13rUw7cUfrGO9Go9xbZearzuuAu", Username: "Ьоb"}
+	want := account.SignupResponse{
+		RequestID: req.ID,
+		Username:  req.Username,
+		Reason:    account.ReasonInvalid,
+		Message:   "username mixes scripts",
+	}
+	if err := s.SaveResponse(ctx, &req, &want); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.Response(ctx, req.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != want {
+		t.Errorf("Response(%s) = %+v, want %+v", req.ID, got, want)
+	}
+}
+
+func testResponseNotFound(t *testing.T, s account.DedupStore) {
+	ctx := context.Background()
+	resp, err := s.Response(ctx, "13rUw7cUfrGO9Go9xbZearzuuAu")
+	if err != account.ErrResponseNotFound {
+		t.Errorf("Response(13rUw7cUfrGO9Go9xbZearzuuAu) = %+v, must be ErrResponseNotFound", resp)
+	}
+}
+
+func testClaimSignup(t *testing.T, s account.DedupStore) {
+	c, ok := s.(account.SignupClaimer)
+	if !ok {
+		t.Skip("dedup store is not a SignupClaimer")
+	}
+
+	ctx := context.Background()
+	bob := account.User{ID: "0ujzPyRiIAffKhBux4PvQdDqMHY", Username: "bob"}
+	requests := []struct {
+		req  account.SignupRequest
+		u    account.User
+		want account.SignupResponse
+	}{
+		{
+			req:  account.SignupRequest{ID: "13rUw7cUfrGO9Go9xbZearzuuAu", Username: "bob"},
+			u:    bob,
+			want: account.SignupResponse{RequestID: "13rUw7cUfrGO9Go9xbZearzuuAu", Username: "bob", Success: true},
+		},
+		{
+			req:  account.SignupRequest{ID: "13rVCgpmD0UgKH6zNHdfcPG63Df", Username: "bob", SequenceID: 1},
+			u:    account.User{ID: "0ujtsYcgvSTl8PAuAdqWYSMnLOv", Username: "bob"},
+			want: account.SignupResponse{RequestID: "13rVCgpmD0UgKH6zNHdfcPG63Df", Username: "bob", Success: false, Reason: account.ReasonTaken},
+		},
+	}
+	for _, tc := range requests {
+		created, existing, err := c.ClaimSignup(ctx, &tc.req, &tc.u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if created != tc.want.Success {
+			t.Errorf("ClaimSignup(%+v) created = %t, want %t", tc.req, created, tc.want.Success)
+		}
+		if !created && (existing == nil || *existing != bob) {
+			t.Errorf("ClaimSignup(%+v) existing = %+v, want %+v", tc.req, existing, bob)
+		}
+
+		got, err := s.Response(ctx, tc.req.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *got != tc.want {
+			t.Errorf("ClaimSignup(%+v) recorded %+v, want %+v", tc.req, got, tc.want)
+		}
+	}
+}
+
+func testClaimSignupDuplicate(t *testing.T, s account.DedupStore) {
+	c, ok := s.(account.SignupClaimer)
+	if !ok {
+		t.Skip("dedup store is not a SignupClaimer")
+	}
+
+	ctx := context.Background()
+	req := account.SignupRequest{ID: "13rUw7cUfrGO9Go9xbZearzuuAu", Username: "bob"}
+	resp := account.SignupResponse{RequestID: req.ID, Username: req.Username, Success: false}
+	if err := s.SaveResponse(ctx, &req, &resp); err != nil {
+		t.Fatal(err)
+	}
+
+	u := account.User{ID: "0ujzPyRiIAffKhBux4PvQdDqMHY", Username: "bob"}
+	if _, _, err := c.ClaimSignup(ctx, &req, &u); err != account.ErrDuplicateRequest {
+		t.Errorf("ClaimSignup(%+v) = %v, must be ErrDuplicateRequest", req, err)
+	}
+	// The user must not be created, so bob can be claimed by another request.
+	req.ID = "13rVCgpmD0UgKH6zNHdfcPG63Df"
+	created, _, err := c.ClaimSignup(ctx, &req, &u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created {
+		t.Errorf("ClaimSignup(%+v) must create a user", req)
+	}
+}
+
+func testMarkSignup(t *testing.T, s account.DedupStore) {
+	m, ok := s.(account.SignupMarker)
+	if !ok {
+		t.Skip("dedup store is not a SignupMarker")
+	}
+
+	ctx := context.Background()
+	req := account.SignupRequest{ID: "13rUw7cUfrGO9Go9xbZearzuuAu", Username: "bob"}
+	if id, err := m.PendingSignup(ctx, req.ID); err != nil || id != "" {
+		t.Fatalf("PendingSignup(%s) = %q, %v, want no user", req.ID, id, err)
+	}
+
+	if err := m.MarkSignup(ctx, &req, "0ujzPyRiIAffKhBux4PvQdDqMHY"); err != nil {
+		t.Fatal(err)
+	}
+	id, err := m.PendingSignup(ctx, req.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "0ujzPyRiIAffKhBux4PvQdDqMHY" {
+		t.Errorf("PendingSignup(%s) = %q, want 0ujzPyRiIAffKhBux4PvQdDqMHY", req.ID, id)
+	}
+
+	// The mark is removed once the response is recorded.
+	resp := account.SignupResponse{RequestID: req.ID, Username: req.Username, Success: true}
+	if err = s.SaveResponse(ctx, &req, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if id, err = m.PendingSignup(ctx, req.ID); err != nil || id != "" {
+		t.Errorf("PendingSignup(%s) = %q, %v, the mark must be removed", req.ID, id, err)
+	}
+}
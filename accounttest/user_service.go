@@ -0,0 +1,230 @@
+// Package accounttest provides conformance tests for implementations of account services.
+// Every UserService backend must behave identically, e.g., pg.UserService and memory.UserService.
+package accounttest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/marselester/distributed-signup"
+)
+
+// OpenUserService returns an empty UserService and a function to release its resources.
+type OpenUserService func(t *testing.T) (account.UserService, func())
+
+// TestUserService runs the UserService conformance tests.
+// Every test opens a new UserService using open.
+func TestUserService(t *testing.T, open OpenUserService) {
+	tests := []struct {
+		name string
+		test func(*testing.T, account.UserService)
+	}{
+		{"CreateUser", testCreateUser},
+		{"CreateUserDuplicateUsername", testCreateUserDuplicateUsername},
+		{"CreateUserDuplicateID", testCreateUserDuplicateID},
+		{"ByUsername", testByUsername},
+		{"ByUsernameNotFound", testByUsernameNotFound},
+		{"ClaimUsername", testClaimUsername},
+		{"ClaimUsernameTaken", testClaimUsernameTaken},
+		{"ClaimUsernameDuplicateID", testClaimUsernameDuplicateID},
+		{"ClaimUsernameConcurrently", testClaimUsernameConcurrently},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s, close := open(t)
+			defer close()
+			tc.test(t, s)
+		})
+	}
+}
+
+func testCreateUser(t *testing.T, s account.UserService) {
+	ctx := context.Background()
+	want := account.User{
+		ID:       "0ujzPyRiIAffKhBux4PvQdDqMHY",
+		Username: "bob",
+	}
+	if err := s.CreateUser(ctx, &want); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.ByUsername(ctx, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want != *got {
+		t.Errorf("CreateUser(%+v) created %+v", want, got)
+	}
+}
+
+func testCreateUserDuplicateUsername(t *testing.T, s account.UserService) {
+	ctx := context.Background()
+	u := account.User{
+		ID:       "0ujzPyRiIAffKhBux4PvQdDqMHY",
+		Username: "bob",
+	}
+	if err := s.CreateUser(ctx, &u); err != nil {
+		t.Fatal(err)
+	}
+
+	u.ID = "123"
+	if err := s.CreateUser(ctx, &u); err != account.ErrUsernameTaken {
+		t.Errorf("CreateUser(%+v) = %v, must be ErrUsernameTaken", u, err)
+	}
+}
+
+func testCreateUserDuplicateID(t *testing.T, s account.UserService) {
+	ctx := context.Background()
+	u := account.User{
+		ID:       "0ujzPyRiIAffKhBux4PvQdDqMHY",
+		Username: "bob",
+	}
+	if err := s.CreateUser(ctx, &u); err != nil {
+		t.Fatal(err)
+	}
+
+	u.Username = "alice"
+	if err := s.CreateUser(ctx, &u); err != account.ErrDuplicateUserID {
+		t.Errorf("CreateUser(%+v) = %v, must be ErrDuplicateUserID", u, err)
+	}
+	if _, err := s.ByUsername(ctx, "alice"); err != account.ErrUserNotFound {
+		t.Errorf("CreateUser(%+v) must not create alice", u)
+	}
+}
+
+func testByUsername(t *testing.T, s account.UserService) {
+	ctx := context.Background()
+	want := account.User{
+		ID:       "0ujzPyRiIAffKhBux4PvQdDqMHY",
+		Username: "bob",
+	}
+	if err := s.CreateUser(ctx, &want); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.ByUsername(ctx, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want != *got {
+		t.Errorf("ByUsername(bob) = %+v, wanted %+v", got, want)
+	}
+}
+
+func testByUsernameNotFound(t *testing.T, s account.UserService) {
+	ctx := context.Background()
+	u, err := s.ByUsername(ctx, "bob")
+	if err != account.ErrUserNotFound {
+		t.Errorf("ByUsername(bob) = %+v, must be ErrUserNotFound", u)
+	}
+}
+
+func testClaimUsername(t *testing.T, s account.UserService) {
+	ctx := context.Background()
+	want := account.User{
+		ID:       "0ujzPyRiIAffKhBux4PvQdDqMHY",
+		Username: "bob",
+	}
+	created, existing, err := s.ClaimUsername(ctx, &want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created || existing != nil {
+		t.Errorf("ClaimUsername(%+v) = %t, %+v, want true, nil", want, created, existing)
+	}
+
+	got, err := s.ByUsername(ctx, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want != *got {
+		t.Errorf("ClaimUsername(%+v) created %+v", want, got)
+	}
+}
+
+func testClaimUsernameTaken(t *testing.T, s account.UserService) {
+	ctx := context.Background()
+	bob := account.User{
+		ID:       "0ujzPyRiIAffKhBux4PvQdDqMHY",
+		Username: "bob",
+	}
+	if err := s.CreateUser(ctx, &bob); err != nil {
+		t.Fatal(err)
+	}
+
+	u := account.User{
+		ID:       "0ujtsYcgvSTl8PAuAdqWYSMnLOv",
+		Username: "bob",
+	}
+	created, existing, err := s.ClaimUsername(ctx, &u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created || existing == nil || *existing != bob {
+		t.Errorf("ClaimUsername(%+v) = %t, %+v, want false, %+v", u, created, existing, bob)
+	}
+}
+
+func testClaimUsernameDuplicateID(t *testing.T, s account.UserService) {
+	ctx := context.Background()
+	u := account.User{
+		ID:       "0ujzPyRiIAffKhBux4PvQdDqMHY",
+		Username: "bob",
+	}
+	if err := s.CreateUser(ctx, &u); err != nil {
+		t.Fatal(err)
+	}
+
+	u.Username = "alice"
+	if _, _, err := s.ClaimUsername(ctx, &u); err != account.ErrDuplicateUserID {
+		t.Errorf("ClaimUsername(%+v) = %v, must be ErrDuplicateUserID", u, err)
+	}
+	if _, err := s.ByUsername(ctx, "alice"); err != account.ErrUserNotFound {
+		t.Errorf("ClaimUsername(%+v) must not create alice", u)
+	}
+}
+
+func testClaimUsernameConcurrently(t *testing.T, s account.UserService) {
+	const claims = 10
+	ctx := context.Background()
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		winners []account.User
+		owners  = make(map[account.User]int)
+	)
+	wg.Add(claims)
+	for i := 0; i < claims; i++ {
+		go func(i int) {
+			defer wg.Done()
+
+			u := account.User{
+				ID:       fmt.Sprintf("0ujzPyRiIAffKhBux4PvQdDqMH%d", i),
+				Username: "bob",
+			}
+			created, existing, err := s.ClaimUsername(ctx, &u)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if created {
+				winners = append(winners, u)
+			} else {
+				owners[*existing]++
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if len(winners) != 1 {
+		t.Fatalf("ClaimUsername() created %d users, want 1", len(winners))
+	}
+	if owners[winners[0]] != claims-1 {
+		t.Errorf("ClaimUsername() reported owners %v, want %+v", owners, winners[0])
+	}
+}
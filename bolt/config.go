@@ -0,0 +1,59 @@
+package bolt
+
+import (
+	"time"
+
+	"github.com/marselester/distributed-signup"
+)
+
+// Config configures a DedupStore. Config is set by the ConfigOption
+// values passed to NewDedupStore.
+type Config struct {
+	path   string
+	window time.Duration
+	ttl    time.Duration
+	noSync bool
+
+	logger account.Logger
+}
+
+// ConfigOption configures how we set up the DedupStore.
+type ConfigOption func(*Config)
+
+// WithPath sets a path of the database file. The file is created if it doesn't exist.
+func WithPath(path string) ConfigOption {
+	return func(c *Config) {
+		c.path = path
+	}
+}
+
+// WithWindow sets a time window of a bucket where responses are grouped by the time they were saved.
+// Smaller windows make expiry more precise at the cost of more buckets to look up a request ID.
+func WithWindow(window time.Duration) ConfigOption {
+	return func(c *Config) {
+		c.window = window
+	}
+}
+
+// WithTTL sets how long a response is remembered (0 is forever).
+// Responses are dropped by buckets, so a response might be kept up to TTL plus one window.
+func WithTTL(ttl time.Duration) ConfigOption {
+	return func(c *Config) {
+		c.ttl = ttl
+	}
+}
+
+// WithNoSync disables fsync after each saved response.
+// It speeds up writes, e.g., in benchmarks, but responses might be lost if the machine crashes.
+func WithNoSync() ConfigOption {
+	return func(c *Config) {
+		c.noSync = true
+	}
+}
+
+// WithLogger configures a logger to debug interactions with the store.
+func WithLogger(l account.Logger) ConfigOption {
+	return func(c *Config) {
+		c.logger = l
+	}
+}
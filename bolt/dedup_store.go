@@ -0,0 +1,302 @@
+// Package bolt implements account.DedupStore using bbolt, an embedded key-value database.
+// The store doesn't need a database server, so a signup-server can keep request IDs on a local disk
+// similar to how Segment deduplicates messages using RocksDB.
+package bolt
+
+import (
+	"context"
+	"encoding/binary"
+	"time"
+
+	bbolt "go.etcd.io/bbolt"
+
+	"github.com/marselester/distributed-signup"
+)
+
+var (
+	// responsesBucket contains nested buckets of responses keyed by request ID.
+	// Nested buckets are keyed by start of a time window (big-endian Unix seconds),
+	// so the buckets are sorted by time and the expired ones are cheap to drop.
+	responsesBucket = []byte("responses")
+	// offsetsBucket contains the latest offset of a processed signup request keyed by partition (big-endian).
+	offsetsBucket = []byte("offsets")
+	// pendingBucket contains IDs of the users which signup requests are about to create keyed by request ID.
+	// A request is removed from the bucket when its response is saved.
+	pendingBucket = []byte("pending")
+)
+
+// DedupStore keeps responses to signup requests in a bbolt database file.
+// Every response is synced to disk along with the offset of its signup request in one transaction,
+// so after a crash the store knows which requests have been processed.
+// A user is claimed in a separate database, so the ID of the user is marked as pending before the claim,
+// see account.SignupMarker.
+// It is safe for concurrent use by multiple goroutines.
+type DedupStore struct {
+	config Config
+	now    func() time.Time
+
+	db *bbolt.DB
+}
+
+// NewDedupStore returns a DedupStore which can be configured with config options.
+// By default responses are stored in "dedup.db" file in hourly buckets for 24 hours, logs are discarded.
+func NewDedupStore(options ...ConfigOption) *DedupStore {
+	s := DedupStore{
+		config: Config{
+			path:   "dedup.db",
+			window: time.Hour,
+			ttl:    24 * time.Hour,
+			logger: &account.NoopLogger{},
+		},
+		now: time.Now,
+	}
+
+	for _, opt := range options {
+		opt(&s.config)
+	}
+	return &s
+}
+
+// Open opens the database file and creates the buckets.
+func (s *DedupStore) Open() error {
+	var err error
+	if s.db, err = bbolt.Open(s.config.path, 0600, &bbolt.Options{Timeout: time.Second}); err != nil {
+		return err
+	}
+	s.db.NoSync = s.config.noSync
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{responsesBucket, offsetsBucket, pendingBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Close closes the database file.
+func (s *DedupStore) Close() error {
+	return s.db.Close()
+}
+
+// Response returns a response recorded for the signup request ID or account.ErrResponseNotFound
+// if there is no such response or it has expired.
+func (s *DedupStore) Response(ctx context.Context, requestID string) (*account.SignupResponse, error) {
+	var resp *account.SignupResponse
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		v := s.lookup(tx, []byte(requestID))
+		if v == nil {
+			return account.ErrResponseNotFound
+		}
+		resp = decodeResponse(requestID, v)
+		return nil
+	})
+	return resp, err
+}
+
+// SaveResponse records a response to the signup request and the request's offset in its partition.
+// It returns account.ErrDuplicateRequest if a response to the request ID has been already recorded.
+// The pending mark of the request and the expired buckets are dropped in the same transaction.
+func (s *DedupStore) SaveResponse(ctx context.Context, req *account.SignupRequest, resp *account.SignupResponse) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		if err := s.expire(tx); err != nil {
+			return err
+		}
+
+		id := []byte(req.ID)
+		if s.lookup(tx, id) != nil {
+			return account.ErrDuplicateRequest
+		}
+
+		window, err := tx.Bucket(responsesBucket).CreateBucketIfNotExists(s.windowKey(s.now()))
+		if err != nil {
+			return err
+		}
+		if err = window.Put(id, encodeResponse(resp)); err != nil {
+			return err
+		}
+		if err = tx.Bucket(pendingBucket).Delete(id); err != nil {
+			return err
+		}
+
+		return saveOffset(tx, req.Partition, req.SequenceID)
+	})
+}
+
+// PendingSignup returns the ID of the user which the signup request was about to create
+// or a blank string if the request hasn't been marked.
+func (s *DedupStore) PendingSignup(ctx context.Context, requestID string) (string, error) {
+	var userID string
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		userID = string(tx.Bucket(pendingBucket).Get([]byte(requestID)))
+		return nil
+	})
+	return userID, err
+}
+
+// MarkSignup records that the signup request is about to create the user with the given ID.
+// The mark is synced to disk before the username is claimed, so a request replayed after a crash
+// recognizes the user it has created.
+func (s *DedupStore) MarkSignup(ctx context.Context, req *account.SignupRequest, userID string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(pendingBucket).Put([]byte(req.ID), []byte(userID))
+	})
+}
+
+// Offset returns the latest offset of a signup request processed from the partition
+// or account.ErrOffsetNotFound if no request has been recorded yet.
+func (s *DedupStore) Offset(ctx context.Context, partition int32) (int64, error) {
+	var offset int64
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		v := tx.Bucket(offsetsBucket).Get(partitionKey(partition))
+		if v == nil {
+			return account.ErrOffsetNotFound
+		}
+		offset = int64(binary.BigEndian.Uint64(v))
+		return nil
+	})
+	return offset, err
+}
+
+// SaveOffset records the offset of a signup request which got no response, see account.OffsetSaver.
+// The offset doesn't go back if a later one has been recorded.
+func (s *DedupStore) SaveOffset(ctx context.Context, partition int32, offset int64) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return saveOffset(tx, partition, offset)
+	})
+}
+
+// lookup searches for a response by request ID starting from the newest bucket.
+// Expired buckets which haven't been dropped yet are skipped.
+func (s *DedupStore) lookup(tx *bbolt.Tx, requestID []byte) []byte {
+	oldest := s.windowKey(time.Time{})
+	if s.config.ttl > 0 {
+		oldest = s.windowKey(s.now().Add(-s.config.ttl))
+	}
+
+	c := tx.Bucket(responsesBucket).Cursor()
+	for k, _ := c.Last(); k != nil; k, _ = c.Prev() {
+		if string(k) < string(oldest) {
+			break
+		}
+		if v := c.Bucket().Bucket(k).Get(requestID); v != nil {
+			return v
+		}
+	}
+	return nil
+}
+
+// expire drops buckets whose responses are older than TTL.
+func (s *DedupStore) expire(tx *bbolt.Tx) error {
+	if s.config.ttl <= 0 {
+		return nil
+	}
+	oldest := s.windowKey(s.now().Add(-s.config.ttl))
+
+	b := tx.Bucket(responsesBucket)
+	var expired [][]byte
+	c := b.Cursor()
+	for k, _ := c.First(); k != nil && string(k) < string(oldest); k, _ = c.Next() {
+		expired = append(expired, k)
+	}
+	for _, k := range expired {
+		if err := b.DeleteBucket(k); err != nil {
+			return err
+		}
+		s.config.logger.Log("level", "debug", "msg", "dropped expired responses", "window", int64(binary.BigEndian.Uint64(k)))
+	}
+	return nil
+}
+
+// windowKey returns a key of a bucket where responses saved at time t are stored.
+func (s *DedupStore) windowKey(t time.Time) []byte {
+	var start int64
+	if !t.IsZero() {
+		start = t.Truncate(s.config.window).Unix()
+	}
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, uint64(start))
+	return k
+}
+
+// saveOffset records the offset of a signup request unless a later one has been already recorded.
+func saveOffset(tx *bbolt.Tx, partition int32, offset int64) error {
+	b := tx.Bucket(offsetsBucket)
+	k := partitionKey(partition)
+	if v := b.Get(k); v != nil && int64(binary.BigEndian.Uint64(v)) >= offset {
+		return nil
+	}
+
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint64(v, uint64(offset))
+	return b.Put(k, v)
+}
+
+// partitionKey returns a key of the partition in offsets bucket.
+func partitionKey(partition int32) []byte {
+	k := make([]byte, 4)
+	binary.BigEndian.PutUint32(k, uint32(partition))
+	return k
+}
+
+// Flags of an encoded response.
+const (
+	flagSuccess byte = 1 << iota
+	// flagReason indicates that the username is followed by the failure reason and the message.
+	flagReason
+)
+
+// encodeResponse encodes a response compactly: flags followed by the username.
+// If there is a failure reason, the username is prefixed with its length,
+// and it is followed by the length-prefixed reason and the message.
+// The request ID is not stored because it is a key.
+func encodeResponse(resp *account.SignupResponse) []byte {
+	v := make([]byte, 1, 1+len(resp.Username))
+	if resp.Success {
+		v[0] |= flagSuccess
+	}
+	if resp.Reason == "" && resp.Message == "" {
+		return append(v, resp.Username...)
+	}
+
+	v[0] |= flagReason
+	v = appendString(v, resp.Username)
+	v = appendString(v, string(resp.Reason))
+	return append(v, resp.Message...)
+}
+
+// appendString appends the string prefixed with its length.
+func appendString(v []byte, s string) []byte {
+	n := make([]byte, binary.MaxVarintLen64)
+	v = append(v, n[:binary.PutUvarint(n, uint64(len(s)))]...)
+	return append(v, s...)
+}
+
+// decodeResponse decodes a response to the signup request ID.
+// Note, v is only valid during a transaction, so the strings are copied.
+func decodeResponse(requestID string, v []byte) *account.SignupResponse {
+	resp := account.SignupResponse{
+		RequestID: requestID,
+		Success:   v[0]&flagSuccess != 0,
+	}
+	if v[0]&flagReason == 0 {
+		resp.Username = string(v[1:])
+		return &resp
+	}
+
+	v = v[1:]
+	resp.Username, v = readString(v)
+	var reason string
+	reason, v = readString(v)
+	resp.Reason = account.Reason(reason)
+	resp.Message = string(v)
+	return &resp
+}
+
+// readString reads a length-prefixed string and returns the rest of v.
+func readString(v []byte) (string, []byte) {
+	n, i := binary.Uvarint(v)
+	v = v[i:]
+	return string(v[:n]), v[n:]
+}
@@ -0,0 +1,331 @@
+package bolt
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	bbolt "go.etcd.io/bbolt"
+
+	"github.com/marselester/distributed-signup"
+	"github.com/marselester/distributed-signup/accounttest"
+	"github.com/marselester/distributed-signup/memory"
+)
+
+// Ensure bolt.DedupStore implements account.DedupStore, keeps offsets of processed requests,
+// and marks pending signups.
+var (
+	_ account.DedupStore   = &DedupStore{}
+	_ account.OffsetStore  = &DedupStore{}
+	_ account.OffsetSaver  = &DedupStore{}
+	_ account.SignupMarker = &DedupStore{}
+)
+
+var benchRequests = flag.Int("requests", 1000000, "Number of request IDs stored before replay benchmarks.")
+
+// mustOpenStore opens a DedupStore in a temporary directory.
+// The returned function closes the store and removes the directory.
+func mustOpenStore(tb testing.TB, options ...ConfigOption) (*DedupStore, func()) {
+	dir, err := ioutil.TempDir("", "signup-dedup")
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	options = append([]ConfigOption{WithPath(filepath.Join(dir, "dedup.db"))}, options...)
+	s := NewDedupStore(options...)
+	if err = s.Open(); err != nil {
+		os.RemoveAll(dir)
+		tb.Fatal(err)
+	}
+	return s, func() {
+		s.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestDedupStoreConformance(t *testing.T) {
+	accounttest.TestDedupStore(t, func(t *testing.T) (account.DedupStore, func()) {
+		return mustOpenStore(t)
+	})
+}
+
+func TestDecodeResponse(t *testing.T) {
+	tests := map[string]account.SignupResponse{
+		"success": {RequestID: "13rUw7cUfrGO9Go9xbZearzuuAu", Username: "bob", Success: true},
+		"taken":   {RequestID: "13rUw7cUfrGO9Go9xbZearzuuAu", Username: "bob", Reason: account.ReasonTaken},
+		"invalid": {RequestID: "13rUw7cUfrGO9Go9xbZearzuuAu", Username: "Ьоb", Reason: account.ReasonInvalid, Message: "username mixes scripts"},
+	}
+	for name, want := range tests {
+		got := decodeResponse(want.RequestID, encodeResponse(&want))
+		if *got != want {
+			t.Errorf("decodeResponse(%s) = %+v, want %+v", name, got, want)
+		}
+	}
+
+	// Responses recorded before failure reasons were introduced have no flags besides success.
+	got := decodeResponse("13rUw7cUfrGO9Go9xbZearzuuAu", []byte("\x00bob"))
+	if got.Username != "bob" || got.Success || got.FailureReason() != account.ReasonTaken {
+		t.Errorf("decodeResponse(bob) = %+v, want taken bob", got)
+	}
+}
+
+func TestDedupStoreTTL(t *testing.T) {
+	s, close := mustOpenStore(t, WithWindow(time.Minute), WithTTL(time.Hour))
+	defer close()
+	now := time.Date(2018, 6, 1, 12, 0, 30, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	req := account.SignupRequest{ID: "13rUw7cUfrGO9Go9xbZearzuuAu", Username: "bob"}
+	if err := s.SaveResponse(ctx, &req, &account.SignupResponse{RequestID: req.ID, Username: req.Username}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The response's bucket is kept up to TTL plus one window.
+	now = now.Add(time.Hour)
+	if _, err := s.Response(ctx, req.ID); err != nil {
+		t.Errorf("Response(%s) = %v, must not expire", req.ID, err)
+	}
+
+	now = now.Add(time.Minute)
+	if _, err := s.Response(ctx, req.ID); err != account.ErrResponseNotFound {
+		t.Errorf("Response(%s) = %v, must be expired", req.ID, err)
+	}
+
+	// The expired bucket is dropped when the next response is saved.
+	other := account.SignupRequest{ID: "13rVCgpmD0UgKH6zNHdfcPG63Df", Username: "sam"}
+	if err := s.SaveResponse(ctx, &other, &account.SignupResponse{RequestID: other.ID, Username: other.Username}); err != nil {
+		t.Fatal(err)
+	}
+	var windows int
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(responsesBucket).ForEach(func(k, v []byte) error {
+			windows++
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if windows != 1 {
+		t.Errorf("SaveResponse() kept %d buckets, want 1", windows)
+	}
+}
+
+func TestDedupStoreOffset(t *testing.T) {
+	s, close := mustOpenStore(t)
+	defer close()
+
+	ctx := context.Background()
+	if _, err := s.Offset(ctx, 2); err != account.ErrOffsetNotFound {
+		t.Errorf("Offset(2) = %v, must be ErrOffsetNotFound", err)
+	}
+
+	// The offset doesn't go back when an earlier request is saved, e.g., it was processed concurrently.
+	requests := []account.SignupRequest{
+		{ID: "13rUw7cUfrGO9Go9xbZearzuuAu", Username: "bob", Partition: 2, SequenceID: 3},
+		{ID: "13rVCgpmD0UgKH6zNHdfcPG63Df", Username: "sam", Partition: 2, SequenceID: 5},
+		{ID: "13rVFmwyaw2u5UXXNKIKMplycqb", Username: "bob", Partition: 2, SequenceID: 4},
+	}
+	for i := range requests {
+		resp := account.SignupResponse{RequestID: requests[i].ID, Username: requests[i].Username}
+		if err := s.SaveResponse(ctx, &requests[i], &resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	offset, err := s.Offset(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset != 5 {
+		t.Errorf("Offset(2) = %d, want 5", offset)
+	}
+
+	// The offset of a request without a response, e.g., dead-lettered, is recorded the same way.
+	if err = s.SaveOffset(ctx, 2, 4); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.SaveOffset(ctx, 2, 6); err != nil {
+		t.Fatal(err)
+	}
+	if offset, err = s.Offset(ctx, 2); err != nil || offset != 6 {
+		t.Errorf("Offset(2) = %d, %v, want 6", offset, err)
+	}
+}
+
+func TestDedupStoreReopen(t *testing.T) {
+	s, close := mustOpenStore(t)
+	defer close()
+
+	ctx := context.Background()
+	req := account.SignupRequest{ID: "13rUw7cUfrGO9Go9xbZearzuuAu", Username: "bob", Partition: 2, SequenceID: 3}
+	want := account.SignupResponse{RequestID: req.ID, Username: req.Username, Success: true}
+	if err := s.SaveResponse(ctx, &req, &want); err != nil {
+		t.Fatal(err)
+	}
+
+	// The server is restarted.
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Open(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.Response(ctx, req.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != want {
+		t.Errorf("Response(%s) = %+v, want %+v", req.ID, got, want)
+	}
+	if offset, err := s.Offset(ctx, 2); err != nil || offset != 3 {
+		t.Errorf("Offset(2) = %d, %v, want 3", offset, err)
+	}
+}
+
+// crashingStore fails to save responses as if the server crashed right after a username was claimed.
+type crashingStore struct {
+	*DedupStore
+}
+
+func (s crashingStore) SaveResponse(ctx context.Context, req *account.SignupRequest, resp *account.SignupResponse) error {
+	return errors.New("crash")
+}
+
+func TestProcessCrashBeforeResponse(t *testing.T) {
+	s, close := mustOpenStore(t)
+	defer close()
+	users := memory.NewUserService()
+
+	ctx := context.Background()
+	req := account.SignupRequest{ID: "13rUw7cUfrGO9Go9xbZearzuuAu", Username: "bob", Partition: 2, SequenceID: 3}
+	p := account.NewProcessor(users, memory.NewSignupService(), func() (string, error) { return "0ujzPyRiIAffKhBux4PvQdDqMHY", nil },
+		account.WithDedupStore(crashingStore{s}),
+	)
+	if _, err := p.Process(ctx, &req); err == nil {
+		t.Fatal("Process() must fail to save the response")
+	}
+
+	// The server is restarted, and the request is replayed.
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Open(); err != nil {
+		t.Fatal(err)
+	}
+	p = account.NewProcessor(users, memory.NewSignupService(), func() (string, error) { return "0ujtsYcgvSTl8PAuAdqWYSMnLOv", nil },
+		account.WithDedupStore(s),
+	)
+	got, err := p.Process(ctx, &req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := account.SignupResponse{RequestID: req.ID, Username: req.Username, Success: true}
+	if *got != want {
+		t.Errorf("Process(%+v) = %+v, want %+v", req, got, want)
+	}
+
+	u, err := users.ByUsername(ctx, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.ID != "0ujzPyRiIAffKhBux4PvQdDqMHY" {
+		t.Errorf("Process() created user %+v", u)
+	}
+	if offset, err := s.Offset(ctx, 2); err != nil || offset != 3 {
+		t.Errorf("Offset(2) = %d, %v, want 3", offset, err)
+	}
+	if id, err := s.PendingSignup(ctx, req.ID); err != nil || id != "" {
+		t.Errorf("PendingSignup(%s) = %q, %v, the mark must be removed", req.ID, id, err)
+	}
+}
+
+// BenchmarkResponseReplay measures lookup latency of responses when signup requests are replayed.
+// The store is populated with -requests IDs spread across 24 hourly buckets, and its size on disk is reported.
+//
+//	go test ./bolt -run=XXX -bench=Replay -requests=10000000
+func BenchmarkResponseReplay(b *testing.B) {
+	s, close := mustOpenStore(b, WithNoSync())
+	defer close()
+
+	ids := mustPopulate(b, s, *benchRequests, 24)
+	fi, err := os.Stat(s.config.path)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	ctx := context.Background()
+	b.Run("hit", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := s.Response(ctx, ids[i%len(ids)]); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(fi.Size()), "disk-bytes")
+		b.ReportMetric(float64(fi.Size())/float64(len(ids)), "disk-bytes/request")
+	})
+	b.Run("miss", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := s.Response(ctx, fmt.Sprintf("miss%023d", i)); err != account.ErrResponseNotFound {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkSaveResponse measures how long it takes to save a response synced to disk.
+func BenchmarkSaveResponse(b *testing.B) {
+	s, close := mustOpenStore(b)
+	defer close()
+
+	ctx := context.Background()
+	for i := 0; i < b.N; i++ {
+		req := account.SignupRequest{ID: requestID(i), Username: "bob", SequenceID: int64(i)}
+		resp := account.SignupResponse{RequestID: req.ID, Username: req.Username}
+		if err := s.SaveResponse(ctx, &req, &resp); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// mustPopulate stores n responses in the given number of window buckets, and returns their request IDs.
+// Responses are written in large transactions, otherwise populating millions of them would take too long.
+func mustPopulate(tb testing.TB, s *DedupStore, n, windows int) []string {
+	const batch = 100000
+	now := s.now()
+	ids := make([]string, n)
+	for i := 0; i < n; i += batch {
+		err := s.db.Update(func(tx *bbolt.Tx) error {
+			for j := i; j < i+batch && j < n; j++ {
+				ids[j] = requestID(j)
+				t := now.Add(-time.Duration(j%windows) * s.config.window)
+				w, err := tx.Bucket(responsesBucket).CreateBucketIfNotExists(s.windowKey(t))
+				if err != nil {
+					return err
+				}
+				resp := account.SignupResponse{Username: fmt.Sprintf("user%d", j), Success: j%2 == 0}
+				if err = w.Put([]byte(ids[j]), encodeResponse(&resp)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return ids
+}
+
+// requestID returns a 27 characters long ID which resembles KSUID.
+func requestID(i int) string {
+	return fmt.Sprintf("13rUw7cUfrGO9Go9x%010d", i)
+}
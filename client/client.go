@@ -0,0 +1,191 @@
+// Package client provides a synchronous signup API on top of account.SignupService.
+// A signup request is written to the requests topic, and the caller waits for the matching response.
+// Responses are read by one listener which passes them to the waiting callers by request ID.
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/segmentio/ksuid"
+
+	"github.com/marselester/distributed-signup"
+)
+
+// ErrTimeout error indicates that a response to a signup request hasn't arrived in time.
+// The request might still be processed later.
+const ErrTimeout = account.Error("signup response timeout")
+
+// Client signs up users and waits for responses to their signup requests.
+// It is safe for concurrent use by multiple goroutines.
+type Client struct {
+	signups account.SignupService
+	timeout time.Duration
+	newID   func() (string, error)
+	// onResponse is called for every response received by the listener.
+	onResponse func(resp *account.SignupResponse)
+
+	mu sync.Mutex
+	// waiters are channels of callers waiting for a response to a signup request ID.
+	waiters map[string][]chan *account.SignupResponse
+	// subscribers are functions called for every response received by the listener, see Subscribe.
+	subscribers    map[int]func(resp *account.SignupResponse)
+	lastSubscriber int
+}
+
+// Option configures how we set up the Client.
+type Option func(*Client)
+
+// WithTimeout sets how long a caller waits for a response to a signup request.
+// By default it is 10 seconds.
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = d
+	}
+}
+
+// WithRequestID sets a function to generate signup request IDs. By default KSUID is used.
+func WithRequestID(newID func() (string, error)) Option {
+	return func(c *Client) {
+		c.newID = newID
+	}
+}
+
+// WithResponseHook sets a function which is called for every response received by Listen,
+// including the responses nobody waits for. It must not block.
+func WithResponseHook(f func(resp *account.SignupResponse)) Option {
+	return func(c *Client) {
+		c.onResponse = f
+	}
+}
+
+// New returns a Client which sends signup requests and receives responses using signups.
+// Make sure Listen is running, otherwise responses are not received.
+func New(signups account.SignupService, options ...Option) *Client {
+	c := Client{
+		signups:     signups,
+		timeout:     10 * time.Second,
+		newID:       NewRequestID,
+		onResponse:  func(*account.SignupResponse) {},
+		waiters:     make(map[string][]chan *account.SignupResponse),
+		subscribers: make(map[int]func(resp *account.SignupResponse)),
+	}
+
+	for _, opt := range options {
+		opt(&c)
+	}
+	return &c
+}
+
+// Listen reads signup responses from all partitions and passes them to the waiting callers until
+// an error occurs or ctx is cancelled. Responses nobody waits for are discarded
+// unless there is a response hook or a subscriber.
+// There should be one listener per SignupService, e.g., Kafka consumer can't read a partition twice,
+// so the other readers of responses should subscribe to the client instead.
+func (c *Client) Listen(ctx context.Context) error {
+	return c.signups.Responses(ctx, func(resp *account.SignupResponse) {
+		c.onResponse(resp)
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		for _, f := range c.subscribers {
+			f(resp)
+		}
+		for _, ch := range c.waiters[resp.RequestID] {
+			ch <- resp
+		}
+		delete(c.waiters, resp.RequestID)
+	})
+}
+
+// Subscribe registers a function which is called for every response received by Listen
+// until the returned unsubscribe function is called. It must not block or call the client.
+func (c *Client) Subscribe(f func(resp *account.SignupResponse)) (unsubscribe func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lastSubscriber++
+	id := c.lastSubscriber
+	c.subscribers[id] = f
+	return func() {
+		c.mu.Lock()
+		delete(c.subscribers, id)
+		c.mu.Unlock()
+	}
+}
+
+// Signup sends a signup request for the username with a new request ID and waits for the response.
+// It returns ErrTimeout if the response hasn't arrived in time.
+func (c *Client) Signup(ctx context.Context, username string) (*account.SignupResponse, error) {
+	id, err := c.newID()
+	if err != nil {
+		return nil, err
+	}
+	return c.Send(ctx, &account.SignupRequest{ID: id, Username: username})
+}
+
+// Send sends the signup request and waits for the response, see Signup.
+// The request can be sent again with the same ID, e.g., after a timeout, to get the same response.
+func (c *Client) Send(ctx context.Context, req *account.SignupRequest) (*account.SignupResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	// The caller starts waiting before the request is sent, so a quick response isn't missed.
+	ch := c.wait(req.ID)
+	defer c.unwait(req.ID, ch)
+
+	if err := c.signups.CreateRequest(ctx, req); err != nil {
+		return nil, err
+	}
+
+	select {
+	case resp := <-ch:
+		return resp, nil
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, ErrTimeout
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// wait registers a channel where a response to the request ID will be sent.
+func (c *Client) wait(requestID string) chan *account.SignupResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// The channel is buffered, so the listener is never blocked by the caller.
+	ch := make(chan *account.SignupResponse, 1)
+	c.waiters[requestID] = append(c.waiters[requestID], ch)
+	return ch
+}
+
+// unwait removes the channel if the caller stopped waiting before the response arrived.
+func (c *Client) unwait(requestID string, ch chan *account.SignupResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	waiters := c.waiters[requestID]
+	for i := range waiters {
+		if waiters[i] == ch {
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(waiters) == 0 {
+		delete(c.waiters, requestID)
+		return
+	}
+	c.waiters[requestID] = waiters
+}
+
+// NewRequestID generates KSUID to identify a signup request. It is the default generator of request IDs.
+func NewRequestID() (string, error) {
+	id, err := ksuid.NewRandom()
+	if err != nil {
+		return "", err
+	}
+	return id.String(), nil
+}
@@ -0,0 +1,188 @@
+package client_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/marselester/distributed-signup"
+	"github.com/marselester/distributed-signup/client"
+	"github.com/marselester/distributed-signup/memory"
+)
+
+// runServer processes signup requests from all partitions of the broker until ctx is cancelled.
+func runServer(ctx context.Context, t *testing.T, b *memory.Broker) {
+	users := memory.NewUserService()
+	var (
+		mu  sync.Mutex
+		ids int
+	)
+	newID := func() (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		ids++
+		return fmt.Sprintf("0ujzPyRiIAffKhBux4PvQdD%04d", ids), nil
+	}
+
+	for i := int32(0); i < b.Partitions(); i++ {
+		server := memory.NewSignupService(
+			memory.WithBroker(b),
+			memory.WithRequestPartition(i),
+			memory.WithRequestOffset(memory.OffsetOldest),
+		)
+		p := account.NewProcessor(users, server, newID)
+		go func() {
+			if err := p.Run(ctx); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+}
+
+// mustListen starts a client's listener which reads responses from the oldest offset,
+// so no response is missed if the listener starts after a request was sent.
+func mustListen(ctx context.Context, t *testing.T, b *memory.Broker, options ...client.Option) *client.Client {
+	signups := memory.NewSignupService(
+		memory.WithBroker(b),
+		memory.WithResponseOffset(memory.OffsetOldest),
+	)
+	c := client.New(signups, options...)
+	go func() {
+		if err := c.Listen(ctx); err != nil {
+			t.Error(err)
+		}
+	}()
+	return c
+}
+
+func TestSignup(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	b := memory.NewBroker(3)
+	runServer(ctx, t, b)
+	c := mustListen(ctx, t, b)
+
+	tests := []struct {
+		username string
+		want     bool
+	}{
+		{"bob", true},
+		{"alice", true},
+		{"bob", false},
+	}
+	for _, tc := range tests {
+		resp, err := c.Signup(ctx, tc.username)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Username != tc.username || resp.Success != tc.want {
+			t.Errorf("Signup(%s) = %+v, want success %t", tc.username, resp, tc.want)
+		}
+	}
+}
+
+func TestSignupConcurrently(t *testing.T) {
+	const signups = 20
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	b := memory.NewBroker(3)
+	runServer(ctx, t, b)
+	c := mustListen(ctx, t, b)
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		success = make(map[string]int)
+	)
+	wg.Add(signups)
+	for i := 0; i < signups; i++ {
+		go func(username string) {
+			defer wg.Done()
+
+			resp, err := c.Signup(ctx, username)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if resp.Username != username {
+				t.Errorf("Signup(%s) = %+v", username, resp)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if resp.Success {
+				success[username]++
+			}
+		}([]string{"bob", "sam", "peter", "lloyd"}[i%4])
+	}
+	wg.Wait()
+
+	for _, username := range []string{"bob", "sam", "peter", "lloyd"} {
+		if success[username] != 1 {
+			t.Errorf("Signup(%s) succeeded %d times, want 1", username, success[username])
+		}
+	}
+}
+
+func TestSignupTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// There is no server to process requests.
+	c := mustListen(ctx, t, memory.NewBroker(3), client.WithTimeout(10*time.Millisecond))
+
+	resp, err := c.Signup(ctx, "bob")
+	if err != client.ErrTimeout {
+		t.Errorf("Signup(bob) = %+v, %v, must be ErrTimeout", resp, err)
+	}
+}
+
+func TestSend(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	b := memory.NewBroker(3)
+	runServer(ctx, t, b)
+	c := mustListen(ctx, t, b)
+
+	req := account.SignupRequest{ID: "13rUw7cUfrGO9Go9xbZearzuuAu", Username: "bob"}
+	resp, err := c.Send(ctx, &req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := account.SignupResponse{RequestID: req.ID, Username: req.Username, Success: true, Partition: 2}
+	if *resp != want {
+		t.Errorf("Send(%+v) = %+v, want %+v", req, resp, want)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	b := memory.NewBroker(3)
+	runServer(ctx, t, b)
+	c := mustListen(ctx, t, b)
+
+	var (
+		mu  sync.Mutex
+		got []string
+	)
+	unsubscribe := c.Subscribe(func(resp *account.SignupResponse) {
+		mu.Lock()
+		got = append(got, resp.Username)
+		mu.Unlock()
+	})
+	if _, err := c.Signup(ctx, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	unsubscribe()
+	if _, err := c.Signup(ctx, "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(got) != 1 || got[0] != "bob" {
+		t.Errorf("Subscribe() got %v, want [bob]", got)
+	}
+}
@@ -0,0 +1,174 @@
+// Command reshard moves user accounts between Postgres shards when partitions are added
+// to account.signup_request topic, so every username is stored in the shard which owns its new partition.
+//
+// The migration pauses signup-servers by marking the shard map as paused, copies accounts to their new shards,
+// verifies them, switches the servers to the new map, and deletes the moved accounts from the old shards.
+// With -dry-run it only reports how many accounts would move.
+//
+// The servers register themselves in the shards they use, so the migration refuses to run
+// if any server reads a single partition instead of following the shard map in consumer-group mode,
+// and it waits until every server acknowledges the paused map before accounts are moved.
+// The new map's epoch must be the next one after the current map's epoch.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/facebookgo/flagenv"
+	kitlog "github.com/go-kit/kit/log"
+
+	"github.com/marselester/distributed-signup"
+	"github.com/marselester/distributed-signup/pg"
+	"github.com/marselester/distributed-signup/shard"
+)
+
+func main() {
+	shardsPath := flag.String("shards", "shards.json", "Path of the shard map which signup-servers watch. It is replaced by the new map.")
+	targetPath := flag.String("target", "", "Path of the new shard map which assigns partitions of the resized topic to shards.")
+	pgUser := flag.String("pguser", "account", "PostgreSQL user.")
+	pgPassword := flag.String("pgpassword", "swordfish", "PostgreSQL password.")
+	partitionerName := flag.String("partitioner", "fnv1a", "How usernames are assigned partitions: fnv1a (Sarama), murmur2 (Java client), or jump.")
+	pauseWait := flag.Duration("pause-wait", time.Minute, "Max duration to wait for signup-servers to acknowledge the paused shard map.")
+	serverTTL := flag.Duration("server-ttl", 30*time.Second, "How recently a signup-server must have registered in a shard to be waited for.")
+	dryRun := flag.Bool("dry-run", false, "Report how many accounts move without changing the shards.")
+	debug := flag.Bool("debug", false, "Enable debug mode.")
+	// Parse env values.
+	flagenv.Parse()
+	// Override env values with command line flag values.
+	flag.Parse()
+
+	var logger account.Logger
+	if *debug {
+		w := kitlog.NewSyncWriter(os.Stderr)
+		logger = kitlog.NewLogfmtLogger(w)
+	} else {
+		logger = &account.NoopLogger{}
+	}
+
+	partitioner, err := account.PartitionerByName(*partitionerName)
+	if err != nil {
+		log.Fatalf("reshard: %v %q", err, *partitionerName)
+	}
+	from, err := shard.OpenMap(*shardsPath)
+	if err != nil {
+		log.Fatalf("reshard: failed to read shard map: %v", err)
+	}
+	to, err := shard.OpenMap(*targetPath)
+	if err != nil {
+		log.Fatalf("reshard: failed to read new shard map: %v", err)
+	}
+
+	// The shards of the new map take precedence when both maps have a shard with the same name.
+	stores := make(map[string]shard.Store)
+	var registries []account.ServerRegistry
+	for _, m := range []*shard.Map{to, from} {
+		for _, s := range m.Shards {
+			if stores[s.Name] != nil {
+				continue
+			}
+			user := pg.NewUserService(
+				pg.WithHost(s.Host),
+				pg.WithPort(s.Port),
+				pg.WithDatabase(s.Database),
+				pg.WithUser(*pgUser),
+				pg.WithPassword(*pgPassword),
+				pg.WithLogger(logger),
+			)
+			if err = user.Open(); err != nil {
+				log.Fatalf("reshard: could not connect to %s shard: %v", s.Name, err)
+			}
+			defer user.Close()
+			stores[s.Name] = user
+			registries = append(registries, user)
+		}
+	}
+	mg, err := shard.NewMigration(from, to, partitioner, stores)
+	if err != nil {
+		log.Fatalf("reshard: %v", err)
+	}
+
+	ctx := context.Background()
+	if *dryRun {
+		r, err := mg.Plan(ctx)
+		if err != nil {
+			log.Fatalf("reshard: failed to plan migration: %v", err)
+		}
+		printReport(os.Stdout, from, to, r)
+		return
+	}
+
+	if err = shard.CheckServers(ctx, registries, time.Now().Add(-*serverTTL)); err != nil {
+		log.Fatalf("reshard: %v", err)
+	}
+
+	// The servers stop processing signup requests once they notice the paused map.
+	// If the previous migration failed, the map is already paused.
+	if !from.Paused {
+		from.Paused = true
+		if err = shard.SaveMap(*shardsPath, from); err != nil {
+			log.Fatalf("reshard: failed to pause shard map: %v", err)
+		}
+	}
+	log.Printf("reshard: paused epoch %d, waiting up to %s for signup-servers", from.Epoch, *pauseWait)
+	waitCtx, cancel := context.WithTimeout(ctx, *pauseWait)
+	err = shard.WaitPaused(waitCtx, registries, from.Epoch, *serverTTL, time.Second)
+	cancel()
+	if err != nil {
+		log.Fatalf("reshard: shard map is left paused: %v", err)
+	}
+
+	r, err := mg.Plan(ctx)
+	if err != nil {
+		log.Fatalf("reshard: failed to plan migration: %v", err)
+	}
+	printReport(os.Stdout, from, to, r)
+
+	if err = mg.Copy(ctx, r); err != nil {
+		for _, mv := range r.Conflicts {
+			log.Printf("reshard: %s %s is claimed by another user in %s", mv.User.ID, mv.User.Username, mv.To)
+		}
+		log.Fatalf("reshard: failed to copy accounts, shard map is left paused: %v", err)
+	}
+	if err = mg.Verify(ctx, r); err != nil {
+		log.Fatalf("reshard: failed to verify accounts, shard map is left paused: %v", err)
+	}
+
+	if err = shard.SaveMap(*shardsPath, to); err != nil {
+		log.Fatalf("reshard: failed to switch shard map: %v", err)
+	}
+	log.Printf("reshard: switched to epoch %d", to.Epoch)
+
+	if err = mg.Cleanup(ctx, r); err != nil {
+		log.Fatalf("reshard: failed to delete moved accounts and responses from old shards: %v", err)
+	}
+}
+
+// printReport writes a number of accounts which move between each pair of shards
+// and a number of moved responses to signup requests.
+func printReport(w io.Writer, from, to *shard.Map, r *shard.Report) {
+	fmt.Fprintf(w, "epoch %d -> %d, partitions %d -> %d\n", from.Epoch, to.Epoch, from.Partitions(), to.Partitions())
+	fmt.Fprintf(w, "%d of %d accounts move\n", len(r.Moves), r.Accounts)
+	if len(r.Responses) > 0 {
+		fmt.Fprintf(w, "%d responses to signup requests move\n", len(r.Responses))
+	}
+
+	moves := make(map[string]int)
+	for _, mv := range r.Moves {
+		moves[mv.From+" -> "+mv.To]++
+	}
+	paths := make([]string, 0, len(moves))
+	for p := range moves {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		fmt.Fprintf(w, "%s: %d\n", p, moves[p])
+	}
+}
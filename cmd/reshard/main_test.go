@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/marselester/distributed-signup"
+	"github.com/marselester/distributed-signup/memory"
+	"github.com/marselester/distributed-signup/shard"
+)
+
+func TestPrintReport(t *testing.T) {
+	from, err := shard.ReadMap(strings.NewReader(`{"shards": [{"name": "account-0", "partitions": [0, 1, 2]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := shard.ReadMap(strings.NewReader(`{"epoch": 1, "shards": [
+		{"name": "account-0", "partitions": [0, 1, 2]},
+		{"name": "account-1", "partitions": [3]}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Peter's partition is 3 out of 4.
+	ctx := context.Background()
+	users := memory.NewUserService()
+	for _, u := range []account.User{
+		{ID: "0ujzPyRiIAffKhBux4PvQdDqMHY", Username: "bob"},
+		{ID: "0ujtsYcgvSTl8PAuAdqWYSMnLOv", Username: "peter"},
+	} {
+		if err = users.CreateUser(ctx, &u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stores := map[string]shard.Store{"account-0": users, "account-1": memory.NewUserService()}
+	mg, err := shard.NewMigration(from, to, account.FNV1aPartitioner{}, stores)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := mg.Plan(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	printReport(&b, from, to, r)
+	want := "epoch 0 -> 1, partitions 3 -> 4\n1 of 2 accounts move\naccount-0 -> account-1: 1\n"
+	if got := b.String(); got != want {
+		t.Errorf("printReport() = %q, want %q", got, want)
+	}
+}
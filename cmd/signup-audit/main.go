@@ -0,0 +1,122 @@
+// Command signup-audit checks that usernames are unique across Postgres shards.
+// It reads user accounts from every shard of the shard map and reports usernames stored in the wrong shard,
+// usernames stored in more than one shard, and user IDs duplicated across shards.
+// It exits with status 1 when violations are found, so it can be run by cron or CI,
+// e.g., after replaying signup requests from an earlier offset or resharding.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/facebookgo/flagenv"
+	kitlog "github.com/go-kit/kit/log"
+
+	"github.com/marselester/distributed-signup"
+	"github.com/marselester/distributed-signup/pg"
+	"github.com/marselester/distributed-signup/shard"
+)
+
+func main() {
+	shardsPath := flag.String("shards", "shards.json", "Path of a JSON file which maps partitions to PostgreSQL shards.")
+	pgUser := flag.String("pguser", "account", "PostgreSQL user.")
+	pgPassword := flag.String("pgpassword", "swordfish", "PostgreSQL password.")
+	partitionerName := flag.String("partitioner", "fnv1a", "How usernames are assigned partitions: fnv1a (Sarama), murmur2 (Java client), or jump.")
+	format := flag.String("format", "text", "Report format: text or json.")
+	debug := flag.Bool("debug", false, "Enable debug mode.")
+	// Parse env values.
+	flagenv.Parse()
+	// Override env values with command line flag values.
+	flag.Parse()
+
+	var logger account.Logger
+	if *debug {
+		w := kitlog.NewSyncWriter(os.Stderr)
+		logger = kitlog.NewLogfmtLogger(w)
+	} else {
+		logger = &account.NoopLogger{}
+	}
+
+	var printReport func(io.Writer, *shard.AuditReport) error
+	switch *format {
+	case "text":
+		printReport = printText
+	case "json":
+		printReport = printJSON
+	default:
+		log.Fatalf("signup-audit: unknown format %q", *format)
+	}
+
+	partitioner, err := account.PartitionerByName(*partitionerName)
+	if err != nil {
+		log.Fatalf("signup-audit: %v %q", err, *partitionerName)
+	}
+	shards, err := shard.OpenMap(*shardsPath)
+	if err != nil {
+		log.Fatalf("signup-audit: failed to read shard map: %v", err)
+	}
+
+	stores := make(map[string]shard.Store)
+	for _, s := range shards.Shards {
+		user := pg.NewUserService(
+			pg.WithHost(s.Host),
+			pg.WithPort(s.Port),
+			pg.WithDatabase(s.Database),
+			pg.WithUser(*pgUser),
+			pg.WithPassword(*pgPassword),
+			pg.WithLogger(logger),
+		)
+		if err = user.Open(); err != nil {
+			log.Fatalf("signup-audit: could not connect to %s shard: %v", s.Name, err)
+		}
+		defer user.Close()
+		stores[s.Name] = user
+	}
+
+	r, err := shard.Audit(context.Background(), shards, partitioner, stores)
+	if err != nil {
+		log.Fatalf("signup-audit: failed to audit shards: %v", err)
+	}
+	if err = printReport(os.Stdout, r); err != nil {
+		log.Fatalf("signup-audit: failed to print report: %v", err)
+	}
+	if !r.OK() {
+		os.Exit(1)
+	}
+}
+
+// printText writes the audit report in human-readable format, one violation per line.
+func printText(w io.Writer, r *shard.AuditReport) error {
+	fmt.Fprintf(w, "%d accounts audited\n", r.Accounts)
+	for _, m := range r.Misplaced {
+		fmt.Fprintf(w, "misplaced: %s %s in %s, owner %s\n", m.Username, m.ID, m.Shard, m.Owner)
+	}
+	for _, d := range r.DuplicateUsernames {
+		fmt.Fprintf(w, "duplicate username: %s:", d.Key)
+		for _, p := range d.Accounts {
+			fmt.Fprintf(w, " %s in %s", p.ID, p.Shard)
+		}
+		fmt.Fprintln(w)
+	}
+	for _, d := range r.DuplicateIDs {
+		fmt.Fprintf(w, "duplicate id: %s:", d.Key)
+		for _, p := range d.Accounts {
+			fmt.Fprintf(w, " %s in %s", p.Username, p.Shard)
+		}
+		fmt.Fprintln(w)
+	}
+	_, err := fmt.Fprintf(w, "%d violations\n", len(r.Misplaced)+len(r.DuplicateUsernames)+len(r.DuplicateIDs))
+	return err
+}
+
+// printJSON writes the audit report as JSON object.
+func printJSON(w io.Writer, r *shard.AuditReport) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
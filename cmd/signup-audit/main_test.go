@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/marselester/distributed-signup/shard"
+)
+
+// report has bob stored in two shards with one of them not owning bob's partition.
+var report = shard.AuditReport{
+	Accounts: 2,
+	Misplaced: []shard.Misplaced{
+		{
+			Placement: shard.Placement{Shard: "account-0", ID: "0ujsswThIGTUYm2K8FjOOfXtY1K", Username: "bob"},
+			Owner:     "account-2",
+		},
+	},
+	DuplicateUsernames: []shard.Duplicate{
+		{
+			Key: "bob",
+			Accounts: []shard.Placement{
+				{Shard: "account-0", ID: "0ujsswThIGTUYm2K8FjOOfXtY1K", Username: "bob"},
+				{Shard: "account-2", ID: "0ujzPyRiIAffKhBux4PvQdDqMHY", Username: "bob"},
+			},
+		},
+	},
+}
+
+func TestPrintText(t *testing.T) {
+	var b bytes.Buffer
+	if err := printText(&b, &report); err != nil {
+		t.Fatal(err)
+	}
+	want := "2 accounts audited\n" +
+		"misplaced: bob 0ujsswThIGTUYm2K8FjOOfXtY1K in account-0, owner account-2\n" +
+		"duplicate username: bob: 0ujsswThIGTUYm2K8FjOOfXtY1K in account-0 0ujzPyRiIAffKhBux4PvQdDqMHY in account-2\n" +
+		"2 violations\n"
+	if got := b.String(); got != want {
+		t.Errorf("printText() = %q, want %q", got, want)
+	}
+}
+
+func TestPrintJSON(t *testing.T) {
+	var b bytes.Buffer
+	if err := printJSON(&b, &report); err != nil {
+		t.Fatal(err)
+	}
+
+	var got struct {
+		Accounts  int `json:"accounts"`
+		Misplaced []struct {
+			Shard    string `json:"shard"`
+			Username string `json:"username"`
+			Owner    string `json:"owner"`
+		} `json:"misplaced"`
+		DuplicateUsernames []shard.Duplicate `json:"duplicate_usernames"`
+	}
+	if err := json.Unmarshal(b.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Accounts != 2 || len(got.Misplaced) != 1 || got.Misplaced[0].Owner != "account-2" || len(got.DuplicateUsernames) != 1 {
+		t.Errorf("printJSON() = %s", b.Bytes())
+	}
+}
@@ -16,6 +16,7 @@ import (
 	"os/signal"
 	"syscall"
 
+	"github.com/facebookgo/flagenv"
 	kitlog "github.com/go-kit/kit/log"
 	"github.com/segmentio/ksuid"
 
@@ -25,7 +26,12 @@ import (
 
 func main() {
 	broker := flag.String("broker", "127.0.0.1:9092", "Comma separated Kafka brokers to connect to.")
+	partitionerName := flag.String("partitioner", "fnv1a", "How usernames are assigned partitions: fnv1a (Sarama), murmur2 (Java client), or jump.")
+	kafkaFlags := kafka.RegisterFlags(flag.CommandLine, "signup-ctl")
 	debug := flag.Bool("debug", false, "Enable debug mode.")
+	// Parse env values.
+	flagenv.Parse()
+	// Override env values with command line flag values.
 	flag.Parse()
 
 	var logger account.Logger
@@ -36,10 +42,21 @@ func main() {
 		logger = &account.NoopLogger{}
 	}
 
-	signup := kafka.NewSignupService(
+	partitioner, err := account.PartitionerByName(*partitionerName)
+	if err != nil {
+		log.Fatalf("signup-ctl: %v %q", err, *partitionerName)
+	}
+	kafkaOptions := []kafka.ConfigOption{
 		kafka.WithBrokers(*broker),
+		kafka.WithPartitioner(partitioner),
 		kafka.WithLogger(logger),
-	)
+	}
+	options, err := kafkaFlags.Options()
+	if err != nil {
+		log.Fatalf("signup-ctl: %v", err)
+	}
+	kafkaOptions = append(kafkaOptions, options...)
+	signup := kafka.NewSignupService(kafkaOptions...)
 	if err := signup.Open(); err != nil {
 		log.Fatalf("signup-ctl: failed to connect to Kafka: %v", err)
 	}
@@ -55,7 +72,11 @@ func main() {
 		cancel()
 	}()
 
-	go printResponses(ctx, signup)
+	go func() {
+		if err := printResponses(ctx, os.Stdout, signup); err != nil {
+			log.Fatalf("signup-ctl: failed to print signup responses: %v", err)
+		}
+	}()
 
 	in := userInput(os.Stdin)
 	for {
@@ -87,20 +108,21 @@ func main() {
 	}
 }
 
-// printResponses prints signup responses until ctx is cancelled.
-func printResponses(ctx context.Context, signup account.SignupService) {
-	err := signup.Responses(ctx, func(resp *account.SignupResponse) {
+// printResponses prints signup responses into w until ctx is cancelled.
+// A failed response is followed by its reason and the message if there is one.
+func printResponses(ctx context.Context, w io.Writer, signup account.SignupService) error {
+	return signup.Responses(ctx, func(resp *account.SignupResponse) {
 		var c string
-		if resp.Success {
+		switch {
+		case resp.Success:
 			c = `✅`
-		} else {
-			c = `❌`
+		case resp.Message != "":
+			c = fmt.Sprintf("❌ %s: %s", resp.FailureReason(), resp.Message)
+		default:
+			c = fmt.Sprintf("❌ %s", resp.FailureReason())
 		}
-		fmt.Printf("%d:%d %s %s %s\n", resp.Partition, resp.SequenceID, resp.RequestID, resp.Username, c)
+		fmt.Fprintf(w, "%d:%d %s %s %s\n", resp.Partition, resp.SequenceID, resp.RequestID, resp.Username, c)
 	})
-	if err != nil {
-		log.Fatalf("signup-ctl: failed to print signup responses: %v", err)
-	}
 }
 
 // userInput reads input from r as a set of lines and writes them into the channel.
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/marselester/distributed-signup"
+	"github.com/marselester/distributed-signup/memory"
+)
+
+func TestPrintResponses(t *testing.T) {
+	signup := memory.NewSignupService(memory.WithResponseOffset(memory.OffsetOldest))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	responses := []account.SignupResponse{
+		{RequestID: "13rUw7cUfrGO9Go9xbZearzuuAu", Username: "bob", Success: true},
+		{RequestID: "13rVCgpmD0UgKH6zNHdfcPG63Df", Username: "bob", Success: false},
+		{RequestID: "13rVFmwyaw2u5UXXNKIKMplycqb", Username: "Bob", Reason: account.ReasonInvalid, Message: "username mixes scripts"},
+	}
+	for i := range responses {
+		if err := signup.CreateResponse(ctx, &responses[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// All the responses are stored in bob's partition which is 2.
+	// The response without a reason was emitted before reasons were introduced.
+	want := "2:0 13rUw7cUfrGO9Go9xbZearzuuAu bob ✅\n" +
+		"2:1 13rVCgpmD0UgKH6zNHdfcPG63Df bob ❌ taken\n" +
+		"2:2 13rVFmwyaw2u5UXXNKIKMplycqb Bob ❌ invalid: username mixes scripts\n"
+	w := lineWriter{lines: len(responses), cancel: cancel}
+	if err := printResponses(ctx, &w, signup); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.String(); got != want {
+		t.Errorf("printResponses() = %q, want %q", got, want)
+	}
+}
+
+// lineWriter is a buffer which calls cancel once it received the expected number of lines.
+type lineWriter struct {
+	bytes.Buffer
+	lines  int
+	cancel context.CancelFunc
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	n, err := w.Buffer.Write(p)
+	if bytes.Count(w.Bytes(), []byte("\n")) == w.lines {
+		w.cancel()
+	}
+	return n, err
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/marselester/distributed-signup"
+	"github.com/marselester/distributed-signup/client"
+	"github.com/marselester/distributed-signup/memory"
+)
+
+// gateway is an HTTP handler which accepts signup requests and serves their responses.
+//
+//	POST /signups?wait=5 {"username": "bob", "request_id": "13rUw7cUfrGO9Go9xbZearzuuAu"}
+//	GET /signups/13rUw7cUfrGO9Go9xbZearzuuAu
+//
+// The request ID is optional, it is generated if omitted. A client can wait up to N seconds for a response,
+// otherwise 202 Accepted is returned right away, and the client should poll the response using the Location header.
+// 502 Bad Gateway is returned if the request couldn't be sent, so the client should send it again.
+type gateway struct {
+	// ctx is a context of the signup requests sent by the gateway, they are stopped when ctx is cancelled.
+	ctx     context.Context
+	client  *client.Client
+	maxWait time.Duration
+	ttl     time.Duration
+	newID   func() (string, error)
+	logger  account.Logger
+
+	// responses keeps responses to the requests sent by the gateway, so they can be polled.
+	responses *memory.DedupStore
+
+	mu sync.Mutex
+	// pending contains the requests sent by the gateway which haven't got responses yet.
+	pending map[string]*pendingRequest
+	// nextPrune is when the expired pending requests should be forgotten.
+	nextPrune time.Time
+}
+
+// pendingRequest is a signup request waiting for its response.
+// It stays pending after the client stops waiting, so a late response is still recorded, until it expires.
+type pendingRequest struct {
+	// done is closed when the response arrives or the request fails.
+	done chan struct{}
+	// err is why the request wasn't sent, it is set before done is closed.
+	err     error
+	expires time.Time
+}
+
+// newGateway returns a gateway which sends signup requests using signups and waits for their responses up to timeout.
+// Clients can wait for responses up to maxWait, and they can poll responses during ttl (0 is forever).
+// Make sure the gateway's client is listening, otherwise responses are not received.
+func newGateway(ctx context.Context, signups account.SignupService, logger account.Logger, timeout, maxWait, ttl time.Duration) *gateway {
+	g := gateway{
+		ctx:       ctx,
+		maxWait:   maxWait,
+		ttl:       ttl,
+		newID:     client.NewRequestID,
+		logger:    logger,
+		responses: memory.NewDedupStore(0, ttl),
+		pending:   make(map[string]*pendingRequest),
+	}
+	g.client = client.New(signups,
+		client.WithTimeout(timeout),
+		client.WithResponseHook(g.record),
+	)
+	return &g
+}
+
+// signupRequest is a body of POST /signups.
+type signupRequest struct {
+	Username  string `json:"username"`
+	RequestID string `json:"request_id"`
+}
+
+// maxBodySize is the max size of POST /signups body in bytes.
+const maxBodySize = 4 << 10
+
+// errorResponse is a body of an unsuccessful HTTP response.
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func (g *gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/signups" && r.Method == http.MethodPost:
+		g.createSignup(w, r)
+	case strings.HasPrefix(r.URL.Path, "/signups/") && r.Method == http.MethodGet:
+		g.signup(w, r, strings.TrimPrefix(r.URL.Path, "/signups/"))
+	case r.URL.Path == "/signups" || strings.HasPrefix(r.URL.Path, "/signups/"):
+		writeJSON(w, http.StatusMethodNotAllowed, errorResponse{"method not allowed"})
+	default:
+		writeJSON(w, http.StatusNotFound, errorResponse{"not found"})
+	}
+}
+
+// createSignup sends a signup request and waits for the response if wait query param is given.
+func (g *gateway) createSignup(w http.ResponseWriter, r *http.Request) {
+	var in signupRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).Decode(&in); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{"malformed json"})
+		return
+	}
+	if in.Username == "" {
+		writeJSON(w, http.StatusBadRequest, errorResponse{"username is required"})
+		return
+	}
+	if len(in.RequestID) > account.MaxRequestIDLength {
+		writeJSON(w, http.StatusBadRequest, errorResponse{fmt.Sprintf("request_id must not be longer than %d bytes", account.MaxRequestIDLength)})
+		return
+	}
+
+	var wait time.Duration
+	if s := r.URL.Query().Get("wait"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			writeJSON(w, http.StatusBadRequest, errorResponse{"wait must be a number of seconds"})
+			return
+		}
+		wait = time.Duration(n) * time.Second
+		if wait > g.maxWait {
+			wait = g.maxWait
+		}
+	}
+
+	// A signup request might be sent again with the same ID, e.g., after a client's timeout.
+	if resp, err := g.responses.Response(r.Context(), in.RequestID); err == nil {
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+
+	req := account.SignupRequest{ID: in.RequestID, Username: in.Username}
+	if req.ID == "" {
+		var err error
+		if req.ID, err = g.newID(); err != nil {
+			writeJSON(w, http.StatusInternalServerError, errorResponse{"request id not generated"})
+			return
+		}
+	}
+	p := g.send(&req)
+
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-p.done:
+			if p.err != nil {
+				writeJSON(w, http.StatusBadGateway, errorResponse{"signup request not sent"})
+				return
+			}
+			if resp, err := g.responses.Response(r.Context(), req.ID); err == nil {
+				writeJSON(w, http.StatusOK, resp)
+				return
+			}
+		case <-timer.C:
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	w.Header().Set("Location", "/signups/"+req.ID)
+	writeJSON(w, http.StatusAccepted, account.SignupResponse{RequestID: req.ID, Username: req.Username})
+}
+
+// signup serves a response to the signup request ID. If the response hasn't arrived yet, 202 Accepted is returned,
+// and if the request couldn't be sent, 502 Bad Gateway is returned.
+func (g *gateway) signup(w http.ResponseWriter, r *http.Request, requestID string) {
+	if resp, err := g.responses.Response(r.Context(), requestID); err == nil {
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+
+	g.mu.Lock()
+	p, ok := g.pendingLocked(requestID)
+	var err error
+	if ok {
+		err = p.err
+	}
+	g.mu.Unlock()
+	switch {
+	case ok && err != nil:
+		writeJSON(w, http.StatusBadGateway, errorResponse{"signup request not sent"})
+	case ok:
+		writeJSON(w, http.StatusAccepted, account.SignupResponse{RequestID: requestID})
+	default:
+		writeJSON(w, http.StatusNotFound, errorResponse{"signup request not found"})
+	}
+}
+
+// send sends the signup request in background.
+// The returned request's done channel is closed when the response is recorded or the request fails.
+// If the request with the same ID is pending, no new request is sent.
+// The failed request is kept until it expires, so the client polling its response finds out that it wasn't sent.
+func (g *gateway) send(req *account.SignupRequest) *pendingRequest {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if p, ok := g.pendingLocked(req.ID); ok && p.err == nil {
+		return p
+	}
+	p := pendingRequest{done: make(chan struct{})}
+	if g.ttl > 0 {
+		now := time.Now()
+		p.expires = now.Add(g.ttl)
+		// The expired requests are forgotten once in a while, so the gateway doesn't keep
+		// the requests which never got responses.
+		if now.After(g.nextPrune) {
+			g.pruneLocked(now)
+			g.nextPrune = p.expires
+		}
+	}
+	g.pending[req.ID] = &p
+
+	go func() {
+		// A response is recorded by the listener hook, even if it arrives after the client's timeout.
+		_, err := g.client.Send(g.ctx, req)
+		if err == nil || err == client.ErrTimeout {
+			return
+		}
+
+		// The request wasn't sent, so it can be sent again with the same ID.
+		g.logger.Log("level", "error", "msg", "signup-gateway: signup request not sent", "request_id", req.ID, "err", err)
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		if g.pending[req.ID] == &p {
+			p.err = err
+			close(p.done)
+		}
+	}()
+	return &p
+}
+
+// record is a listener hook which records the response to the pending request.
+// Responses to the requests sent by others are ignored.
+func (g *gateway) record(resp *account.SignupResponse) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	p, ok := g.pendingLocked(resp.RequestID)
+	if !ok {
+		return
+	}
+	req := account.SignupRequest{ID: resp.RequestID, Username: resp.Username}
+	g.responses.SaveResponse(g.ctx, &req, resp)
+	g.forgetLocked(resp.RequestID, p)
+}
+
+// pendingLocked returns the pending request unless it has expired. The caller must hold the lock.
+func (g *gateway) pendingLocked(requestID string) (*pendingRequest, bool) {
+	p, ok := g.pending[requestID]
+	if ok && p.expired(time.Now()) {
+		g.forgetLocked(requestID, p)
+		return nil, false
+	}
+	return p, ok
+}
+
+// pruneLocked forgets the expired pending requests. The caller must hold the lock.
+func (g *gateway) pruneLocked(now time.Time) {
+	for id, p := range g.pending {
+		if p.expired(now) {
+			g.forgetLocked(id, p)
+		}
+	}
+}
+
+// forgetLocked removes the pending request and closes its done channel,
+// unless the request failed and the channel is already closed. The caller must hold the lock.
+func (g *gateway) forgetLocked(requestID string, p *pendingRequest) {
+	delete(g.pending, requestID)
+	if p.err == nil {
+		close(p.done)
+	}
+}
+
+// expired reports whether the request stopped waiting for its response.
+func (p *pendingRequest) expired(now time.Time) bool {
+	return !p.expires.IsZero() && now.After(p.expires)
+}
+
+// writeJSON writes v as JSON with the given HTTP status code.
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marselester/distributed-signup"
+	"github.com/marselester/distributed-signup/memory"
+)
+
+// newTestGateway returns a gateway backed by the memory broker.
+// If serve is true, signup requests of bob's partition are processed.
+func newTestGateway(ctx context.Context, serve bool) *gateway {
+	b := memory.NewBroker(3)
+	if serve {
+		// Bob's requests are stored in the partition 2.
+		server := memory.NewSignupService(
+			memory.WithBroker(b),
+			memory.WithRequestPartition(2),
+			memory.WithRequestOffset(memory.OffsetOldest),
+		)
+		newID := func() (string, error) { return "0ujzPyRiIAffKhBux4PvQdDqMHY", nil }
+		p := account.NewProcessor(memory.NewUserService(), server, newID)
+		go p.Run(ctx)
+	}
+
+	g := newGateway(ctx, memory.NewSignupService(
+		memory.WithBroker(b),
+		memory.WithResponseOffset(memory.OffsetOldest),
+	), &account.NoopLogger{}, time.Second, time.Second, time.Hour)
+	go g.client.Listen(ctx)
+	return g
+}
+
+// do serves the HTTP request and decodes the JSON response into v.
+func do(t *testing.T, h http.Handler, method, target, body string, v interface{}) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+	if err := json.NewDecoder(w.Body).Decode(v); err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func TestCreateSignupWait(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	g := newTestGateway(ctx, true)
+
+	var got account.SignupResponse
+	w := do(t, g, "POST", "/signups?wait=1", `{"username": "bob", "request_id": "13rUw7cUfrGO9Go9xbZearzuuAu"}`, &got)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /signups?wait=1 status = %d, want 200", w.Code)
+	}
+	want := account.SignupResponse{RequestID: "13rUw7cUfrGO9Go9xbZearzuuAu", Username: "bob", Success: true}
+	if got != want {
+		t.Errorf("POST /signups?wait=1 = %+v, want %+v", got, want)
+	}
+
+	// The response can be polled afterwards.
+	got = account.SignupResponse{}
+	if w = do(t, g, "GET", "/signups/13rUw7cUfrGO9Go9xbZearzuuAu", "", &got); w.Code != http.StatusOK || got != want {
+		t.Errorf("GET /signups/13rUw7cUfrGO9Go9xbZearzuuAu = %d %+v, want 200 %+v", w.Code, got, want)
+	}
+}
+
+func TestCreateSignupAsync(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	g := newTestGateway(ctx, true)
+
+	var got account.SignupResponse
+	w := do(t, g, "POST", "/signups", `{"username": "bob"}`, &got)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("POST /signups status = %d, want 202", w.Code)
+	}
+	location := w.Header().Get("Location")
+	if got.RequestID == "" || location != "/signups/"+got.RequestID {
+		t.Fatalf("POST /signups = %+v, Location %q", got, location)
+	}
+
+	// The client polls the response until it arrives.
+	for i := 0; i < 100; i++ {
+		if w = do(t, g, "GET", location, "", &got); w.Code != http.StatusAccepted {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if w.Code != http.StatusOK || !got.Success || got.Username != "bob" {
+		t.Errorf("GET %s = %d %+v, want 200 success", location, w.Code, got)
+	}
+}
+
+func TestCreateSignupTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// No server processes the requests, so the gateway stops waiting after max wait duration.
+	g := newTestGateway(ctx, false)
+	g.maxWait = 10 * time.Millisecond
+
+	var got account.SignupResponse
+	w := do(t, g, "POST", "/signups?wait=5", `{"username": "bob", "request_id": "13rUw7cUfrGO9Go9xbZearzuuAu"}`, &got)
+	if w.Code != http.StatusAccepted {
+		t.Errorf("POST /signups?wait=5 status = %d, want 202", w.Code)
+	}
+
+	if w = do(t, g, "GET", "/signups/13rUw7cUfrGO9Go9xbZearzuuAu", "", &got); w.Code != http.StatusAccepted {
+		t.Errorf("GET /signups/13rUw7cUfrGO9Go9xbZearzuuAu status = %d, want 202", w.Code)
+	}
+}
+
+func TestGatewayErrors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	g := newTestGateway(ctx, false)
+
+	tests := []struct {
+		method, target, body string
+		want                 int
+	}{
+		{"POST", "/signups", `{"username": ""}`, http.StatusBadRequest},
+		{"POST", "/signups", `{"username": `, http.StatusBadRequest},
+		{"POST", "/signups?wait=soon", `{"username": "bob"}`, http.StatusBadRequest},
+		{"POST", "/signups", `{"username": "bob", "request_id": "` + strings.Repeat("x", 65) + `"}`, http.StatusBadRequest},
+		{"POST", "/signups", `{"username": "` + strings.Repeat("x", maxBodySize) + `"}`, http.StatusBadRequest},
+		{"GET", "/signups/13rUw7cUfrGO9Go9xbZearzuuAu", "", http.StatusNotFound},
+		{"DELETE", "/signups/13rUw7cUfrGO9Go9xbZearzuuAu", "", http.StatusMethodNotAllowed},
+		{"GET", "/users", "", http.StatusNotFound},
+	}
+	for _, tc := range tests {
+		var got errorResponse
+		if w := do(t, g, tc.method, tc.target, tc.body, &got); w.Code != tc.want || got.Error == "" {
+			t.Errorf("%s %s = %d %+v, want %d", tc.method, tc.target, w.Code, got, tc.want)
+		}
+	}
+}
+
+// brokenSignups fails to send signup requests.
+type brokenSignups struct {
+	account.SignupService
+}
+
+func (s *brokenSignups) CreateRequest(ctx context.Context, req *account.SignupRequest) error {
+	return fmt.Errorf("kafka: broker not available")
+}
+
+func TestCreateSignupNotSent(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	g := newGateway(ctx, &brokenSignups{memory.NewSignupService()}, &account.NoopLogger{}, time.Second, time.Second, time.Hour)
+	go g.client.Listen(ctx)
+
+	var got errorResponse
+	w := do(t, g, "POST", "/signups?wait=1", `{"username": "bob", "request_id": "13rUw7cUfrGO9Go9xbZearzuuAu"}`, &got)
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("POST /signups?wait=1 status = %d, want 502", w.Code)
+	}
+
+	// The client which doesn't wait finds out that the request wasn't sent when it polls the response.
+	var resp account.SignupResponse
+	w = do(t, g, "POST", "/signups", `{"username": "alice", "request_id": "0ujzPyRiIAffKhBux4PvQdDqMHY"}`, &resp)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("POST /signups status = %d, want 202", w.Code)
+	}
+	for i := 0; i < 100; i++ {
+		got = errorResponse{}
+		if w = do(t, g, "GET", "/signups/0ujzPyRiIAffKhBux4PvQdDqMHY", "", &got); w.Code != http.StatusAccepted {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("GET /signups/0ujzPyRiIAffKhBux4PvQdDqMHY status = %d, want 502", w.Code)
+	}
+}
+
+func TestCreateSignupLateResponse(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	b := memory.NewBroker(3)
+	signups := memory.NewSignupService(
+		memory.WithBroker(b),
+		memory.WithResponseOffset(memory.OffsetOldest),
+	)
+	// The gateway stops waiting for the response before it arrives.
+	g := newGateway(ctx, signups, &account.NoopLogger{}, 10*time.Millisecond, time.Second, time.Hour)
+	go g.client.Listen(ctx)
+
+	var got account.SignupResponse
+	w := do(t, g, "POST", "/signups?wait=1", `{"username": "bob", "request_id": "13rUw7cUfrGO9Go9xbZearzuuAu"}`, &got)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("POST /signups?wait=1 status = %d, want 202", w.Code)
+	}
+	if w = do(t, g, "GET", "/signups/13rUw7cUfrGO9Go9xbZearzuuAu", "", &got); w.Code != http.StatusAccepted {
+		t.Fatalf("GET /signups/13rUw7cUfrGO9Go9xbZearzuuAu status = %d, want 202", w.Code)
+	}
+
+	want := account.SignupResponse{RequestID: "13rUw7cUfrGO9Go9xbZearzuuAu", Username: "bob", Success: true}
+	if err := signups.CreateResponse(ctx, &want); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		got = account.SignupResponse{}
+		if w = do(t, g, "GET", "/signups/13rUw7cUfrGO9Go9xbZearzuuAu", "", &got); w.Code != http.StatusAccepted {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if w.Code != http.StatusOK || got.RequestID != want.RequestID || !got.Success {
+		t.Errorf("GET /signups/13rUw7cUfrGO9Go9xbZearzuuAu = %d %+v, want 200 %+v", w.Code, got, want)
+	}
+}
+
+func TestPendingExpires(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	g := newGateway(ctx, memory.NewSignupService(), &account.NoopLogger{}, 10*time.Millisecond, time.Second, 20*time.Millisecond)
+	go g.client.Listen(ctx)
+
+	var got account.SignupResponse
+	do(t, g, "POST", "/signups", `{"username": "bob", "request_id": "13rUw7cUfrGO9Go9xbZearzuuAu"}`, &got)
+	if w := do(t, g, "GET", "/signups/13rUw7cUfrGO9Go9xbZearzuuAu", "", &got); w.Code != http.StatusAccepted {
+		t.Errorf("GET /signups/13rUw7cUfrGO9Go9xbZearzuuAu status = %d, want 202", w.Code)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	var gotErr errorResponse
+	if w := do(t, g, "GET", "/signups/13rUw7cUfrGO9Go9xbZearzuuAu", "", &gotErr); w.Code != http.StatusNotFound {
+		t.Errorf("GET /signups/13rUw7cUfrGO9Go9xbZearzuuAu status = %d, want 404 after ttl", w.Code)
+	}
+}
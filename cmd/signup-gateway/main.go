@@ -0,0 +1,125 @@
+/*
+Command signup-gateway exposes an HTTP API to sign up users for clients which can't speak Kafka, e.g., a web frontend.
+
+	POST /signups?wait=5 {"username": "bob", "request_id": "13rUw7cUfrGO9Go9xbZearzuuAu"}
+	GET /signups/13rUw7cUfrGO9Go9xbZearzuuAu
+
+A signup request is written to "account.signup_request" topic, and the gateway waits for the response
+in "account.signup_response" topic. A client can block up to wait seconds to get the response (200 OK),
+otherwise 202 Accepted is returned, and the response should be polled by the request ID.
+
+The same API is available via gRPC (see -grpc flag and rpc/signup.proto) for internal services,
+including a stream of all signup responses.
+*/
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/facebookgo/flagenv"
+	kitlog "github.com/go-kit/kit/log"
+	"google.golang.org/grpc"
+
+	"github.com/marselester/distributed-signup"
+	"github.com/marselester/distributed-signup/kafka"
+	"github.com/marselester/distributed-signup/rpc"
+)
+
+func main() {
+	addr := flag.String("http", ":8080", "HTTP address to listen to.")
+	grpcAddr := flag.String("grpc", "", "gRPC address to listen to, e.g., :9090. gRPC is disabled by default.")
+	broker := flag.String("broker", "127.0.0.1:9092", "Broker address to connect to.")
+	maxWait := flag.Duration("max-wait", 10*time.Second, "Max duration a client can wait for a signup response.")
+	timeout := flag.Duration("timeout", time.Minute, "How long the gateway waits for a signup response.")
+	ttl := flag.Duration("ttl", time.Hour, "How long signup responses can be polled.")
+	kafkaFlags := kafka.RegisterFlags(flag.CommandLine, "signup-gateway")
+	partitionerName := flag.String("partitioner", "fnv1a", "How usernames are assigned partitions: fnv1a (Sarama), murmur2 (Java client), or jump.")
+	debug := flag.Bool("debug", false, "Enable debug mode.")
+	// Parse env values.
+	flagenv.Parse()
+	// Override env values with command line flag values.
+	flag.Parse()
+
+	var logger account.Logger
+	if *debug {
+		w := kitlog.NewSyncWriter(os.Stderr)
+		logger = kitlog.NewLogfmtLogger(w)
+	} else {
+		logger = &account.NoopLogger{}
+	}
+
+	partitioner, err := account.PartitionerByName(*partitionerName)
+	if err != nil {
+		log.Fatalf("signup-gateway: %v %q", err, *partitionerName)
+	}
+	kafkaOptions := []kafka.ConfigOption{
+		kafka.WithBrokers(*broker),
+		kafka.WithPartitioner(partitioner),
+		kafka.WithLogger(logger),
+	}
+	options, err := kafkaFlags.Options()
+	if err != nil {
+		log.Fatalf("signup-gateway: %v", err)
+	}
+	kafkaOptions = append(kafkaOptions, options...)
+	signup := kafka.NewSignupService(kafkaOptions...)
+	if err := signup.Open(); err != nil {
+		log.Fatalf("signup-gateway: failed to connect to Kafka: %v", err)
+	}
+	defer signup.Close()
+
+	// Listen to Ctrl+C and kill/killall to gracefully stop the gateway.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigchan := make(chan os.Signal, 1)
+	signal.Notify(sigchan, syscall.SIGINT, syscall.SIGTERM)
+
+	g := newGateway(ctx, signup, logger, *timeout, *maxWait, *ttl)
+	go func() {
+		if err := g.client.Listen(ctx); err != nil {
+			log.Fatalf("signup-gateway: failed to read signup responses: %v", err)
+		}
+	}()
+
+	if *grpcAddr != "" {
+		lis, err := net.Listen("tcp", *grpcAddr)
+		if err != nil {
+			log.Fatalf("signup-gateway: %v", err)
+		}
+		// The gRPC server shares the gateway's listener, because Kafka partitions can't be consumed twice.
+		rpcServer := rpc.NewServer(g.client, *ttl)
+
+		s := grpc.NewServer()
+		rpc.RegisterSignupServiceServer(s, rpcServer)
+		go func() {
+			<-ctx.Done()
+			s.GracefulStop()
+		}()
+		go func() {
+			if err := s.Serve(lis); err != nil {
+				log.Fatalf("signup-gateway: %v", err)
+			}
+		}()
+	}
+
+	srv := http.Server{
+		Addr:    *addr,
+		Handler: g,
+	}
+	go func() {
+		<-sigchan
+		srv.Shutdown(ctx)
+		cancel()
+	}()
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatalf("signup-gateway: %v", err)
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/marselester/distributed-signup"
+	"github.com/marselester/distributed-signup/shard"
+)
+
+// shardProcessors processes signup requests from the partitions assigned to a consumer group member.
+// Requests of a partition are processed by the Postgres shard which owns the partition.
+// Shards are connected when their partitions are assigned and disconnected when revoked.
+//
+// When the shard map is paused by a migration, requests are not processed until the map is switched to the next epoch.
+// Requests which had been written before partitions were added to the topic are processed by the shard
+// which owns the username's partition in the new map, so a username is always claimed in the same shard.
+type shardProcessors struct {
+	// id identifies the server in the shards' registries, see account.ServerRegistry.
+	id string
+	// open connects to the shard and returns a processor which stores user accounts there,
+	// and a function to disconnect.
+	open func(s *shard.Shard) (p *account.Processor, close func(), err error)
+	// partitioner assigns partitions to usernames the same way the producers of signup requests do.
+	partitioner account.Partitioner
+
+	mu     sync.RWMutex
+	shards *shard.Map
+	// paused is closed when the paused shard map is switched.
+	paused      chan struct{}
+	byPartition map[int32]*account.Processor
+	byShard     map[string]*account.Processor
+	closers     []func()
+	// pausedEpoch is the epoch of the paused map plus one, or 0 when the map isn't paused.
+	// It is accessed atomically, because heartbeats can't wait for mu while it is held to disconnect the shards.
+	pausedEpoch int64
+}
+
+// update switches to the shard map m. If the map's epoch is changed, the shards are reconnected
+// according to the new map once the requests in progress are processed.
+func (sp *shardProcessors) update(m *shard.Map) error {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	if sp.shards != nil && m.Epoch < sp.shards.Epoch {
+		return fmt.Errorf("shard map epoch %d is older than %d", m.Epoch, sp.shards.Epoch)
+	}
+
+	var err error
+	if sp.shards != nil && m.Epoch != sp.shards.Epoch && sp.byPartition != nil {
+		partitions := make([]int32, 0, len(sp.byPartition))
+		for p := range sp.byPartition {
+			partitions = append(partitions, p)
+		}
+		sp.closeAll()
+		sp.shards = m
+		err = sp.connectAll(partitions)
+	}
+	sp.shards = m
+
+	switch {
+	case m.Paused && sp.paused == nil:
+		sp.paused = make(chan struct{})
+	case !m.Paused && sp.paused != nil:
+		close(sp.paused)
+		sp.paused = nil
+	}
+	if m.Paused {
+		atomic.StoreInt64(&sp.pausedEpoch, int64(m.Epoch)+1)
+	} else {
+		atomic.StoreInt64(&sp.pausedEpoch, 0)
+	}
+	return err
+}
+
+// server returns the state of the server which is registered in the connected shards.
+// Once the server has switched to a paused map, no requests are in progress, because update waits for them,
+// so the paused epoch acknowledges that the shards can be migrated.
+func (sp *shardProcessors) server() account.Server {
+	s := account.Server{ID: sp.id, Group: true, PausedEpoch: account.NotPaused}
+	if n := atomic.LoadInt64(&sp.pausedEpoch); n > 0 {
+		s.PausedEpoch = int(n - 1)
+	}
+	return s
+}
+
+// assign connects to the shards which own the partitions.
+func (sp *shardProcessors) assign(partitions []int32) error {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return sp.connectAll(partitions)
+}
+
+// connectAll connects to the shards which own the partitions. The caller must hold the lock.
+func (sp *shardProcessors) connectAll(partitions []int32) error {
+	sp.byPartition = make(map[int32]*account.Processor)
+	for _, partition := range partitions {
+		s, err := sp.shards.Shard(partition)
+		if err != nil {
+			sp.closeAll()
+			return fmt.Errorf("partition %d: %v", partition, err)
+		}
+
+		p, err := sp.connect(s)
+		if err != nil {
+			sp.closeAll()
+			return err
+		}
+		sp.byPartition[partition] = p
+	}
+	return nil
+}
+
+// connect returns the processor of the shard connecting to it if necessary. The caller must hold the lock.
+func (sp *shardProcessors) connect(s *shard.Shard) (*account.Processor, error) {
+	if p, ok := sp.byShard[s.Name]; ok {
+		return p, nil
+	}
+
+	p, close, err := sp.open(s)
+	if err != nil {
+		return nil, fmt.Errorf("shard %s: %v", s.Name, err)
+	}
+	if sp.byShard == nil {
+		sp.byShard = make(map[string]*account.Processor)
+	}
+	sp.byShard[s.Name] = p
+	sp.closers = append(sp.closers, close)
+	return p, nil
+}
+
+// revoke disconnects from all the shards. Requests of the revoked partitions are already processed
+// because the consumer group waits for them before the rebalance.
+func (sp *shardProcessors) revoke(partitions []int32) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	sp.closeAll()
+}
+
+// closeAll disconnects from all the shards. The caller must hold the lock.
+func (sp *shardProcessors) closeAll() {
+	for _, close := range sp.closers {
+		close()
+	}
+	sp.closers = nil
+	sp.byPartition = nil
+	sp.byShard = nil
+}
+
+// handle processes a signup request by the processor of the shard which owns the request's username.
+// It waits while the shard map is paused or until ctx is done, e.g., the consumer group is rebalanced.
+// The shard can't be disconnected while the request is processed.
+func (sp *shardProcessors) handle(ctx context.Context, req *account.SignupRequest) error {
+	for {
+		sp.mu.RLock()
+		if paused := sp.paused; paused != nil {
+			sp.mu.RUnlock()
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-paused:
+			}
+			continue
+		}
+
+		p, s, err := sp.processor(req)
+		if s == nil {
+			if err == nil {
+				err = p.Handle(ctx, req)
+			}
+			sp.mu.RUnlock()
+			return err
+		}
+		sp.mu.RUnlock()
+
+		// The shard of the moved username is connected, and the request is handled on the next iteration.
+		sp.mu.Lock()
+		_, err = sp.connect(s)
+		sp.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// processor returns the processor of the shard which owns the request's username, usually it is the shard
+// of the request's partition. If the username belongs to another partition because the topic has been resized,
+// the processor of that partition's shard is returned, or the shard is returned if it's not connected yet.
+// The caller must hold the lock.
+func (sp *shardProcessors) processor(req *account.SignupRequest) (*account.Processor, *shard.Shard, error) {
+	partition := sp.partitioner.Partition(account.NormalizeUsername(req.Username), sp.shards.Partitions())
+	if partition == req.Partition {
+		p, ok := sp.byPartition[req.Partition]
+		if !ok {
+			return nil, nil, fmt.Errorf("partition %d is not assigned", req.Partition)
+		}
+		return p, nil, nil
+	}
+
+	s, err := sp.shards.Shard(partition)
+	if err != nil {
+		return nil, nil, fmt.Errorf("partition %d: %v", partition, err)
+	}
+	if p, ok := sp.byShard[s.Name]; ok {
+		return p, nil, nil
+	}
+	return nil, s, nil
+}
+
+// watchMap reads the shard map from the file every time it is modified and passes it to update
+// until ctx is cancelled. The file is checked every interval.
+func watchMap(ctx context.Context, path string, interval time.Duration, update func(*shard.Map) error) {
+	var modTime time.Time
+	if fi, err := os.Stat(path); err == nil {
+		modTime = fi.ModTime()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		fi, err := os.Stat(path)
+		if err != nil {
+			log.Printf("signup: failed to check shard map: %v", err)
+			continue
+		}
+		if fi.ModTime().Equal(modTime) {
+			continue
+		}
+		m, err := shard.OpenMap(path)
+		if err != nil {
+			log.Printf("signup: failed to read shard map: %v", err)
+			continue
+		}
+		modTime = fi.ModTime()
+
+		if err = update(m); err != nil {
+			log.Printf("signup: failed to switch shard map: %v", err)
+			continue
+		}
+		log.Printf("signup: shard map epoch %d paused=%t", m.Epoch, m.Paused)
+	}
+}
+
+// run processes signup requests of the assigned partitions as they arrive until
+// an error occurs or ctx is cancelled, see account.Processor.Run.
+func (sp *shardProcessors) run(ctx context.Context, signups account.SignupService) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu  sync.Mutex
+		err error
+	)
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return err != nil
+	}
+	rerr := signups.Requests(ctx, func(reqCtx context.Context, req *account.SignupRequest) {
+		// The requests which arrived after a failure are not processed,
+		// so their offsets are not committed.
+		if failed() {
+			return
+		}
+		herr := sp.handle(reqCtx, req)
+		// The request which was interrupted by a rebalance, e.g., while the shard map is paused,
+		// is processed by the partition's new owner.
+		if herr != nil && reqCtx.Err() != nil && ctx.Err() == nil {
+			return
+		}
+		if herr != nil {
+			mu.Lock()
+			if err == nil {
+				err = herr
+			}
+			mu.Unlock()
+			cancel()
+		}
+	})
+	if failed() {
+		return err
+	}
+	return rerr
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marselester/distributed-signup"
+	"github.com/marselester/distributed-signup/memory"
+	"github.com/marselester/distributed-signup/shard"
+)
+
+func TestShardProcessors(t *testing.T) {
+	shards, err := shard.ReadMap(strings.NewReader(`{"shards": [
+		{"name": "account-0", "partitions": [0]},
+		{"name": "account-1", "partitions": [1, 2]}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	users := make(map[string]*memory.UserService)
+	var closed []string
+	sp := shardProcessors{
+		shards:      shards,
+		partitioner: account.FNV1aPartitioner{},
+		open: func(s *shard.Shard) (*account.Processor, func(), error) {
+			users[s.Name] = memory.NewUserService()
+			newID := func() (string, error) { return "0ujzPyRiIAffKhBux4PvQdDqMHY", nil }
+			p := account.NewProcessor(users[s.Name], memory.NewSignupService(), newID)
+			return p, func() { closed = append(closed, s.Name) }, nil
+		},
+	}
+
+	if err = sp.assign([]int32{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users["account-1"] == nil {
+		t.Fatalf("assign(1, 2) connected to %v, want account-1", users)
+	}
+
+	// Bob's requests are stored in the partition 2.
+	ctx := context.Background()
+	req := account.SignupRequest{ID: "13rUw7cUfrGO9Go9xbZearzuuAu", Username: "bob", Partition: 2}
+	if err = sp.handle(ctx, &req); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = users["account-1"].ByUsername(ctx, "bob"); err != nil {
+		t.Errorf("handle(%+v) must create bob in account-1: %v", req, err)
+	}
+
+	req = account.SignupRequest{ID: "13rVCgpmD0UgKH6zNHdfcPG63Df", Username: "lloyd", Partition: 0}
+	if err = sp.handle(ctx, &req); err == nil {
+		t.Errorf("handle(%+v) must fail because partition 0 is not assigned", req)
+	}
+
+	sp.revoke([]int32{1, 2})
+	if len(closed) != 1 || closed[0] != "account-1" {
+		t.Errorf("revoke(1, 2) disconnected from %v, want account-1", closed)
+	}
+}
+
+func TestShardProcessorsUnknownPartition(t *testing.T) {
+	shards, err := shard.ReadMap(strings.NewReader(`{"shards": [{"name": "account-0", "partitions": [0]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var opened, closed int
+	sp := shardProcessors{
+		shards:      shards,
+		partitioner: account.FNV1aPartitioner{},
+		open: func(s *shard.Shard) (*account.Processor, func(), error) {
+			opened++
+			return &account.Processor{}, func() { closed++ }, nil
+		},
+	}
+	if err = sp.assign([]int32{0, 1}); err == nil {
+		t.Errorf("assign(0, 1) must fail because no shard owns partition 1")
+	}
+	if opened != closed {
+		t.Errorf("assign(0, 1) left %d shards connected", opened-closed)
+	}
+}
+
+// mustOpenShards returns shard processors which store users of every shard in memory.
+func mustOpenShards(t *testing.T, mapping string) (*shardProcessors, map[string]*memory.UserService) {
+	shards, err := shard.ReadMap(strings.NewReader(mapping))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	users := make(map[string]*memory.UserService)
+	sp := shardProcessors{
+		partitioner: account.FNV1aPartitioner{},
+		open: func(s *shard.Shard) (*account.Processor, func(), error) {
+			if users[s.Name] == nil {
+				users[s.Name] = memory.NewUserService()
+			}
+			newID := func() (string, error) { return "0ujzPyRiIAffKhBux4PvQdDqMHY", nil }
+			p := account.NewProcessor(users[s.Name], memory.NewSignupService(), newID)
+			return p, func() {}, nil
+		},
+	}
+	if err = sp.update(shards); err != nil {
+		t.Fatal(err)
+	}
+	return &sp, users
+}
+
+func TestShardProcessorsPaused(t *testing.T) {
+	sp, users := mustOpenShards(t, `{"paused": true, "shards": [{"name": "account-0", "partitions": [0, 1, 2]}]}`)
+	if err := sp.assign([]int32{2}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	req := account.SignupRequest{ID: "13rUw7cUfrGO9Go9xbZearzuuAu", Username: "bob", Partition: 2}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- sp.handle(ctx, &req)
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	if _, err := users["account-0"].ByUsername(ctx, "bob"); err != account.ErrUserNotFound {
+		t.Fatalf("handle(%+v) must wait while the shard map is paused", req)
+	}
+
+	resumed, err := shard.ReadMap(strings.NewReader(`{"epoch": 1, "shards": [{"name": "account-0", "partitions": [0, 1, 2]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sp.update(resumed); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if _, err = users["account-0"].ByUsername(ctx, "bob"); err != nil {
+		t.Errorf("handle(%+v) must create bob once the shard map is switched: %v", req, err)
+	}
+}
+
+func TestShardProcessorsPausedCancel(t *testing.T) {
+	sp, _ := mustOpenShards(t, `{"paused": true, "shards": [{"name": "account-0", "partitions": [0, 1, 2]}]}`)
+	if err := sp.assign([]int32{2}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := account.SignupRequest{ID: "13rUw7cUfrGO9Go9xbZearzuuAu", Username: "bob", Partition: 2}
+	if err := sp.handle(ctx, &req); err != context.Canceled {
+		t.Errorf("handle(%+v) = %v, want context canceled", req, err)
+	}
+}
+
+// rebalancedSignups passes a signup request to f with the context of a consumer group session which has ended.
+type rebalancedSignups struct {
+	*memory.SignupService
+	req account.SignupRequest
+}
+
+func (s *rebalancedSignups) Requests(ctx context.Context, f func(context.Context, *account.SignupRequest)) error {
+	sessionCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	f(sessionCtx, &s.req)
+	return nil
+}
+
+func TestShardProcessorsPausedRebalance(t *testing.T) {
+	sp, _ := mustOpenShards(t, `{"paused": true, "shards": [{"name": "account-0", "partitions": [0, 1, 2]}]}`)
+	if err := sp.assign([]int32{2}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The paused request stops waiting on rebalance, and the partition's new owner processes it.
+	signups := rebalancedSignups{
+		SignupService: memory.NewSignupService(),
+		req:           account.SignupRequest{ID: "13rUw7cUfrGO9Go9xbZearzuuAu", Username: "bob", Partition: 2},
+	}
+	if err := sp.run(context.Background(), &signups); err != nil {
+		t.Errorf("run() = %v, rebalance must not stop the server", err)
+	}
+}
+
+func TestShardProcessorsResized(t *testing.T) {
+	// Bob's partition is 0 out of 4, though his request was written to the partition 2 when there were 3 partitions.
+	sp, users := mustOpenShards(t, `{"epoch": 1, "shards": [
+		{"name": "account-0", "partitions": [0]},
+		{"name": "account-1", "partitions": [1]},
+		{"name": "account-2", "partitions": [2]},
+		{"name": "account-3", "partitions": [3]}
+	]}`)
+	if err := sp.assign([]int32{2}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	req := account.SignupRequest{ID: "13rUw7cUfrGO9Go9xbZearzuuAu", Username: "bob", Partition: 2}
+	if err := sp.handle(ctx, &req); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users["account-0"].ByUsername(ctx, "bob"); err != nil {
+		t.Errorf("handle(%+v) must create bob in account-0: %v", req, err)
+	}
+	if _, err := users["account-2"].ByUsername(ctx, "bob"); err != account.ErrUserNotFound {
+		t.Errorf("handle(%+v) must not create bob in account-2", req)
+	}
+}
+
+func TestShardProcessorsServer(t *testing.T) {
+	sp, _ := mustOpenShards(t, `{"epoch": 2, "shards": [{"name": "account-0", "partitions": [0]}]}`)
+	if s := sp.server(); !s.Group || s.PausedEpoch != account.NotPaused {
+		t.Errorf("server() = %+v, want group server which is not paused", s)
+	}
+
+	// The server acknowledges the paused epoch once it switched to the paused map.
+	paused, err := shard.ReadMap(strings.NewReader(`{"epoch": 2, "paused": true, "shards": [{"name": "account-0", "partitions": [0]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sp.update(paused); err != nil {
+		t.Fatal(err)
+	}
+	if s := sp.server(); s.PausedEpoch != 2 {
+		t.Errorf("server() = %+v, want paused epoch 2", s)
+	}
+}
+
+func TestShardProcessorsStaleEpoch(t *testing.T) {
+	sp, _ := mustOpenShards(t, `{"epoch": 2, "shards": [{"name": "account-0", "partitions": [0]}]}`)
+	stale, err := shard.ReadMap(strings.NewReader(`{"epoch": 1, "shards": [{"name": "account-0", "partitions": [0]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sp.update(stale); err == nil {
+		t.Errorf("update() must reject the shard map of epoch 1")
+	}
+}
+
+func TestWatchMapInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shards")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "shards.json")
+	write := func(mapping string) {
+		if err := ioutil.WriteFile(path, []byte(mapping), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`{"shards": [{"name": "account-0", "partitions": [0, 1]}]}`)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates := make(chan *shard.Map, 1)
+	go watchMap(ctx, path, time.Millisecond, func(m *shard.Map) error {
+		updates <- m
+		return nil
+	})
+	time.Sleep(10 * time.Millisecond)
+
+	// Nobody owns the partition 1, so the current map is kept.
+	write(`{"epoch": 1, "shards": [{"name": "account-0", "partitions": [0]}, {"name": "account-1", "partitions": [2]}]}`)
+	select {
+	case m := <-updates:
+		t.Fatalf("watchMap() switched to the invalid map of epoch %d", m.Epoch)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	write(`{"epoch": 1, "shards": [{"name": "account-0", "partitions": [0]}, {"name": "account-1", "partitions": [1]}]}`)
+	select {
+	case m := <-updates:
+		if m.Epoch != 1 {
+			t.Errorf("watchMap() switched to epoch %d, want 1", m.Epoch)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("watchMap() must switch to the valid map")
+	}
+}
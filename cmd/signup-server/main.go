@@ -1,29 +1,60 @@
 /*
 The signup-server checks if a requested username is taken and emits corresponding response message.
 
-Kafka consumer is based on Sarama which does not support automatic consumer-group rebalancing and offset tracking.
-The server reads signup request messages from a single partition of "account.signup_request" topic.
+By default the server reads signup request messages from a single partition of "account.signup_request" topic.
 Then a username is looked up in a Postgres and resulting message is published in "account.signup_response" topic.
 
+When Postgres is briefly unavailable, a request is retried with exponential backoff (see -retry-attempts flag),
+so the following requests of the partition wait. In consumer-group mode the request which keeps failing
+is postponed to retry topics (see -retry-topics flag), so the partition's other requests are processed meanwhile.
+Requests of the same username are still processed in order.
+
 When unexpected Postgres or Kafka error occurs, the server should be restarted from the latest processed offset.
+The offset is recorded along with a response (see -dedup flag), so the server resumes where it left off
+unless -offset is set explicitly.
+Responses are recorded by request ID along with user accounts,
+so if some requests are processed again, they get the same responses.
+
+In consumer-group mode (see -group flag) servers join a Kafka consumer group instead,
+and the partitions are assigned to them by Kafka. Requests of a partition are stored
+in the Postgres shard which owns the partition according to the mapping file (see -shards flag).
+Offsets of processed requests are committed to Kafka after every rebalance and periodically.
+
+Requests for reserved usernames such as admin are declined (see -reserved flag).
+The file of reserved names is reloaded on SIGHUP without interrupting the processing of requests, e.g.,
+
+	$ killall -HUP signup-server
 */
 package main
 
 import (
 	"context"
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/facebookgo/flagenv"
 	kitlog "github.com/go-kit/kit/log"
 	"github.com/segmentio/ksuid"
 
 	"github.com/marselester/distributed-signup"
+	"github.com/marselester/distributed-signup/bolt"
 	"github.com/marselester/distributed-signup/kafka"
+	"github.com/marselester/distributed-signup/memory"
 	"github.com/marselester/distributed-signup/pg"
+	"github.com/marselester/distributed-signup/shard"
+)
+
+const (
+	// pruneInterval is how often old responses to signup requests are deleted from PostgreSQL.
+	pruneInterval = time.Hour
+	// heartbeatInterval is how often the server registers itself in PostgreSQL, so reshard can see its state.
+	heartbeatInterval = 5 * time.Second
 )
 
 func main() {
@@ -35,7 +66,26 @@ func main() {
 
 	broker := flag.String("broker", "127.0.0.1:9092", "Broker address to connect to.")
 	partition := flag.Int("partition", 0, "Partition number of account.signup_request topic.")
-	offset := flag.Int64("offset", -1, "Offset index of a partition (-1 to start from the newest, -2 from the oldest).")
+	offset := flag.Int64("offset", -1, "Offset index of a partition (-1 to start from the newest, -2 from the oldest). By default the server resumes after the latest processed offset.")
+	group := flag.String("group", "", "Kafka consumer group to join instead of reading a single partition.")
+	shardsPath := flag.String("shards", "shards.json", "Path of a JSON file which maps partitions to PostgreSQL shards in consumer-group mode.")
+	shardsPoll := flag.Duration("shards-poll", 5*time.Second, "How often the shard map file is checked for changes made by reshard.")
+	dedup := flag.String("dedup", "pg", "Where responses are remembered to deduplicate signup requests: pg, bolt, or memory.")
+	dedupSize := flag.Int("dedup-size", 100000, "Max number of responses remembered in memory (0 is unlimited).")
+	dedupTTL := flag.Duration("dedup-ttl", 24*time.Hour, "How long a response is remembered in memory or bolt file (0 is forever).")
+	dedupPath := flag.String("dedup-path", "dedup.db", "Path of a bolt file where responses are remembered.")
+	dedupRetention := flag.Duration("dedup-retention", 7*24*time.Hour, "How long a response is kept in PostgreSQL before it is pruned.")
+	retryAttempts := flag.Int("retry-attempts", 10, "How many times a request is retried when Postgres is unavailable (0 to stop on the first error). By default a request is retried for at least 13s.")
+	retryBackoff := flag.Duration("retry-backoff", 100*time.Millisecond, "Backoff before the first retry, it is doubled every retry.")
+	retryMaxBackoff := flag.Duration("retry-max-backoff", 5*time.Second, "Max backoff between retries.")
+	retryTopics := flag.String("retry-topics", "", "Comma-separated delays of retry topics in consumer-group mode, e.g., 5s,30s,2m.")
+	transactionalID := flag.String("transactional-id", "", "Prefix of Kafka transactional IDs, it enables transactions which commit a response along with its request's offset in consumer-group mode (Kafka 0.11+).")
+	kafkaFlags := kafka.RegisterFlags(flag.CommandLine, "signup-server")
+	deadLetterTopic := flag.String("dead-letter-topic", "account.signup_dead_letter", "Topic where malformed signup requests are forwarded (blank to stop on them).")
+	partitionerName := flag.String("partitioner", "fnv1a", "How usernames are assigned partitions: fnv1a (Sarama), murmur2 (Java client), or jump.")
+	usernameMin := flag.Int("username-min", 3, "Min number of characters in a username.")
+	usernameMax := flag.Int("username-max", pg.MaxUsernameLength, "Max number of characters in a username (up to 40).")
+	reservedPath := flag.String("reserved", "", "Path of a file with reserved usernames which are reloaded on SIGHUP.")
 	debug := flag.Bool("debug", false, "Enable debug mode.")
 	// Parse env values.
 	flagenv.Parse()
@@ -50,6 +100,200 @@ func main() {
 		logger = &account.NoopLogger{}
 	}
 
+	// Listen to Ctrl+C and kill/killall to gracefully stop processing signup requests.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigchan := make(chan os.Signal, 1)
+	signal.Notify(sigchan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigchan
+		cancel()
+	}()
+
+	// The server registers itself in Postgres under a random ID, see heartbeat.
+	serverID, err := newUserID()
+	if err != nil {
+		log.Fatalf("signup: failed to generate server ID: %v", err)
+	}
+
+	// Responses are recorded in the same transaction as user accounts by default,
+	// so there is no separate dedup store for pg.
+	var dedupStore account.DedupStore
+	switch *dedup {
+	case "pg":
+	case "bolt":
+		store := bolt.NewDedupStore(
+			bolt.WithPath(*dedupPath),
+			bolt.WithTTL(*dedupTTL),
+			bolt.WithLogger(logger),
+		)
+		if err := store.Open(); err != nil {
+			log.Fatalf("signup: failed to open dedup store: %v", err)
+		}
+		defer store.Close()
+		dedupStore = store
+	case "memory":
+		dedupStore = memory.NewDedupStore(*dedupSize, *dedupTTL)
+	default:
+		log.Fatalf("signup: unknown dedup store %q", *dedup)
+	}
+	// Reserved names are shared by all the processors, so they see the reloaded rules.
+	var reserved account.ReservedNames
+	if *reservedPath != "" {
+		if err := reserved.Reload(*reservedPath); err != nil {
+			log.Fatalf("signup: failed to read reserved names: %v", err)
+		}
+		go reloadReserved(ctx, &reserved, *reservedPath)
+	}
+	retryDelays, err := parseDelays(*retryTopics)
+	if err != nil {
+		log.Fatalf("signup: invalid retry topics: %v", err)
+	}
+	retry := account.NewRetryPolicy(
+		account.WithRetryAttempts(*retryAttempts),
+		account.WithRetryBackoff(*retryBackoff, *retryMaxBackoff),
+	)
+	if *usernameMax > pg.MaxUsernameLength {
+		log.Fatalf("signup: username max %d is longer than %d characters the accounts can store", *usernameMax, pg.MaxUsernameLength)
+	}
+	policy := account.NewUsernamePolicy(account.WithUsernameLength(*usernameMin, *usernameMax))
+	// newProcessor returns a processor which stores user accounts using the user service.
+	newProcessor := func(ctx context.Context, user *pg.UserService, signup account.SignupService) *account.Processor {
+		store := dedupStore
+		if store == nil {
+			store = user
+			go pruneResponses(ctx, user, *dedupRetention)
+		}
+		options := []account.ProcessorOption{
+			account.WithDedupStore(store),
+			account.WithUsernamePolicy(policy),
+			account.WithReservedNames(&reserved),
+			account.WithSuccessHook(func(req *account.SignupRequest, u *account.User) {
+				log.Printf("%d:%d %s %s", req.Partition, req.SequenceID, req.ID, req.Username)
+				log.Printf("%q signed up with ID: %s\n", u.Username, u.ID)
+			}),
+			account.WithFailureHook(func(req *account.SignupRequest, u *account.User) {
+				log.Printf("%d:%d %s %s", req.Partition, req.SequenceID, req.ID, req.Username)
+				log.Printf("%q already claimed: %s\n", u.Username, u.ID)
+			}),
+			account.WithDuplicateHook(func(req *account.SignupRequest, resp *account.SignupResponse) {
+				log.Printf("%d:%d %s %s", req.Partition, req.SequenceID, req.ID, req.Username)
+				log.Printf("%q request %s already processed\n", resp.Username, resp.RequestID)
+			}),
+			account.WithRejectHook(func(req *account.SignupRequest, err error) {
+				log.Printf("%d:%d %s %s", req.Partition, req.SequenceID, req.ID, req.Username)
+				log.Printf("%q rejected: %v\n", req.Username, err)
+			}),
+			account.WithDeclineOnError(func(req *account.SignupRequest, err error) {
+				log.Printf("%d:%d %s %s", req.Partition, req.SequenceID, req.ID, req.Username)
+				log.Printf("%q declined because of error: %v\n", req.Username, err)
+			}),
+			account.WithRetryPolicy(retry),
+			account.WithRetryHook(func(req *account.SignupRequest, err error) {
+				log.Printf("%d:%d %s %s", req.Partition, req.SequenceID, req.ID, req.Username)
+				log.Printf("%q will be retried after error: %v\n", req.Username, err)
+			}),
+		}
+		if r, ok := signup.(account.Retrier); ok && len(retryDelays) > 0 {
+			options = append(options,
+				account.WithRetrier(r),
+				account.WithPostponeHook(func(req *account.SignupRequest, err error) {
+					log.Printf("%d:%d %s %s", req.Partition, req.SequenceID, req.ID, req.Username)
+					log.Printf("%q postponed after %d retries: %v\n", req.Username, req.Retries, err)
+				}),
+			)
+		}
+		return account.NewProcessor(user, signup, newUserID, options...)
+	}
+
+	partitioner, err := account.PartitionerByName(*partitionerName)
+	if err != nil {
+		log.Fatalf("signup: %v %q", err, *partitionerName)
+	}
+	kafkaOptions := []kafka.ConfigOption{
+		kafka.WithBrokers(*broker),
+		kafka.WithRequestPartition(int32(*partition)),
+		kafka.WithPartitioner(partitioner),
+		kafka.WithDeadLetterTopic(*deadLetterTopic),
+		kafka.WithLogger(logger),
+	}
+	options, err := kafkaFlags.Options()
+	if err != nil {
+		log.Fatalf("signup: %v", err)
+	}
+	kafkaOptions = append(kafkaOptions, options...)
+	if *group != "" {
+		kafkaOptions = append(kafkaOptions, kafka.WithRetryTopics(retryDelays...))
+	} else if len(retryDelays) > 0 {
+		log.Fatalf("signup: retry topics require consumer-group mode")
+	}
+	if *transactionalID != "" {
+		if *group == "" {
+			log.Fatalf("signup: transactions require consumer-group mode")
+		}
+		kafkaOptions = append(kafkaOptions, kafka.WithTransactionalID(*transactionalID))
+	}
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "offset" {
+			kafkaOptions = append(kafkaOptions, kafka.WithRequestOffset(*offset))
+		}
+	})
+
+	if *group != "" {
+		shards, err := shard.OpenMap(*shardsPath)
+		if err != nil {
+			log.Fatalf("signup: failed to read shard map: %v", err)
+		}
+
+		sp := shardProcessors{id: serverID, partitioner: partitioner}
+		if err = sp.update(shards); err != nil {
+			log.Fatalf("signup: failed to read shard map: %v", err)
+		}
+		go watchMap(ctx, *shardsPath, *shardsPoll, sp.update)
+		signup := kafka.NewSignupService(append(kafkaOptions,
+			kafka.WithConsumerGroup(*group),
+			kafka.WithRebalanceHooks(sp.assign, sp.revoke),
+		)...)
+		sp.open = func(s *shard.Shard) (*account.Processor, func(), error) {
+			user := pg.NewUserService(
+				pg.WithHost(s.Host),
+				pg.WithPort(s.Port),
+				pg.WithDatabase(s.Database),
+				pg.WithUser(*pgUser),
+				pg.WithPassword(*pgPassword),
+				pg.WithLogger(logger),
+			)
+			if err := user.Open(); err != nil {
+				return nil, nil, err
+			}
+			log.Printf("signup: connected to %s shard", s.Name)
+
+			shardCtx, shardCancel := context.WithCancel(ctx)
+			registered := make(chan struct{})
+			go func() {
+				heartbeat(shardCtx, user, sp.server)
+				close(registered)
+			}()
+			close := func() {
+				shardCancel()
+				<-registered
+				user.Close()
+				log.Printf("signup: disconnected from %s shard", s.Name)
+			}
+			return newProcessor(shardCtx, user, signup), close, nil
+		}
+
+		if err = signup.Open(); err != nil {
+			log.Fatalf("signup: failed to connect to Kafka: %v", err)
+		}
+		defer signup.Close()
+
+		if err = sp.run(ctx, signup); err != nil {
+			log.Fatalf("signup: failed to process signup requests: %v", err)
+		}
+		return
+	}
+
 	user := pg.NewUserService(
 		pg.WithHost(*pgHost),
 		pg.WithPort(uint16(*pgPort)),
@@ -62,68 +306,119 @@ func main() {
 		log.Fatalf("signup: could not establish a connection with PostgreSQL: %v", err)
 	}
 	defer user.Close()
+	// The server reads a single partition regardless of the shard map, so reshard refuses to run while it is seen.
+	go heartbeat(ctx, user, func() account.Server {
+		return account.Server{ID: serverID, PausedEpoch: account.NotPaused}
+	})
 
-	signup := kafka.NewSignupService(
-		kafka.WithBrokers(*broker),
-		kafka.WithRequestPartition(int32(*partition)),
-		kafka.WithRequestOffset(*offset),
-		kafka.WithLogger(logger),
-	)
+	// The requests are read after the latest processed offset unless the offset is set explicitly.
+	offsets, ok := dedupStore.(account.OffsetStore)
+	if dedupStore == nil {
+		offsets, ok = user, true
+	}
+	if ok {
+		kafkaOptions = append(kafkaOptions, kafka.WithOffsetStore(offsets))
+	}
+	signup := kafka.NewSignupService(kafkaOptions...)
 	if err := signup.Open(); err != nil {
 		log.Fatalf("signup: failed to connect to Kafka: %v", err)
 	}
 	defer signup.Close()
 
-	// Listen to Ctrl+C and kill/killall to gracefully stop processing signup requests.
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	sigchan := make(chan os.Signal, 1)
-	signal.Notify(sigchan, syscall.SIGINT, syscall.SIGTERM)
-	go func() {
-		<-sigchan
-		cancel()
-	}()
+	p := newProcessor(ctx, user, signup)
+	if err := p.Run(ctx); err != nil {
+		log.Fatalf("signup: failed to process signup requests: %v", err)
+	}
+}
 
-	err := signup.Requests(ctx, func(req *account.SignupRequest) {
-		log.Printf("%d:%d %s %s", req.Partition, req.SequenceID, req.ID, req.Username)
-		resp := account.SignupResponse{
-			RequestID: req.ID,
-			Username:  req.Username,
+// newUserID generates KSUID to identify a new user.
+func newUserID() (string, error) {
+	id, err := ksuid.NewRandom()
+	if err != nil {
+		return "", err
+	}
+	return id.String(), nil
+}
+
+// heartbeat registers the server in the shard every heartbeatInterval until ctx is cancelled,
+// so reshard can check the server's mode and whether it has paused, see account.ServerRegistry.
+func heartbeat(ctx context.Context, r account.ServerRegistry, server func() account.Server) {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		s := server()
+		if err := r.RegisterServer(ctx, &s); err != nil && ctx.Err() == nil {
+			log.Printf("signup: failed to register server: %v", err)
 		}
 
-		u, err := user.ByUsername(ctx, req.Username)
-		switch err {
-		case account.ErrUserNotFound:
-			userID, err := ksuid.NewRandom()
-			if err != nil {
-				log.Fatalf("signup: user id not created: %v", err)
-			}
-			u := account.User{
-				ID:       userID.String(),
-				Username: req.Username,
-			}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// reloadReserved reloads the reserved names from the file every time the server receives SIGHUP.
+// If the file is broken, the previous names are kept. It stops when ctx is cancelled.
+func reloadReserved(ctx context.Context, reserved *account.ReservedNames, path string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
 
-			if err = user.CreateUser(ctx, &u); err != nil {
-				// It shouldn't happen, because events are processed sequentially.
-				log.Fatalf("signup: failed to create user: %v", err)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			if err := reserved.Reload(path); err != nil {
+				log.Printf("signup: failed to reload reserved names: %v", err)
+				break
 			}
-			resp.Success = true
-			log.Printf("%q signed up with ID: %s\n", u.Username, u.ID)
+			log.Printf("signup: reloaded reserved names from %s", path)
+		}
+	}
+}
 
-		case nil:
-			resp.Success = false
-			log.Printf("%q already claimed: %s\n", u.Username, u.ID)
+// parseDelays parses comma-separated durations, e.g., 5s,30s,2m.
+// The delays must be whole seconds because retry topics are named after them.
+func parseDelays(s string) ([]time.Duration, error) {
+	if s == "" {
+		return nil, nil
+	}
 
-		default:
-			log.Fatalf("signup: failed to look up user: %v", err)
+	var delays []time.Duration
+	for _, v := range strings.Split(s, ",") {
+		d, err := time.ParseDuration(strings.TrimSpace(v))
+		if err != nil {
+			return nil, err
 		}
-
-		if err = signup.CreateResponse(ctx, &resp); err != nil {
-			log.Fatalf("signup: failed to write a response: %v", err)
+		if d < time.Second || d%time.Second != 0 {
+			return nil, fmt.Errorf("delay %s must be whole seconds", d)
 		}
-	})
+		delays = append(delays, d)
+	}
+	return delays, nil
+}
 
-	if err != nil {
-		log.Fatalf("signup: failed to read signup requests: %v", err)
+// pruneResponses periodically deletes responses to signup requests which were processed longer than retention ago.
+// It stops when ctx is cancelled.
+func pruneResponses(ctx context.Context, user *pg.UserService, retention time.Duration) {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := user.DeleteResponses(ctx, time.Now().Add(-retention))
+			if err != nil {
+				log.Printf("signup: failed to prune responses: %v", err)
+				break
+			}
+			log.Printf("signup: pruned %d responses", n)
+		}
 	}
 }
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseDelays(t *testing.T) {
+	got, err := parseDelays("5s, 30s,2m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []time.Duration{5 * time.Second, 30 * time.Second, 2 * time.Minute}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseDelays() = %v, want %v", got, want)
+	}
+
+	for _, s := range []string{"5", "500ms", "1.5s"} {
+		if _, err = parseDelays(s); err == nil {
+			t.Errorf("parseDelays(%q) must fail", s)
+		}
+	}
+}
@@ -10,4 +10,40 @@ func (e Error) Error() string {
 const (
 	// ErrUserNotFound error indicates that a user is not found in a UserService.
 	ErrUserNotFound = Error("user not found")
+	// ErrUsernameTaken error indicates that a username is already claimed by another user.
+	ErrUsernameTaken = Error("username taken")
+	// ErrDuplicateUserID error indicates that a user with the same ID already exists.
+	ErrDuplicateUserID = Error("duplicate user id")
+	// ErrResponseNotFound error indicates that a response to a signup request is not found in a DedupStore.
+	ErrResponseNotFound = Error("response not found")
+	// ErrDuplicateRequest error indicates that a response to a signup request has been already recorded.
+	ErrDuplicateRequest = Error("duplicate request")
+	// ErrOffsetNotFound error indicates that no signup request has been processed from a partition yet.
+	ErrOffsetNotFound = Error("offset not found")
+	// ErrUnknownPartitioner error indicates that there is no partitioner with the given name.
+	ErrUnknownPartitioner = Error("unknown partitioner")
 )
+
+// TransientError wraps an error which is caused by a temporary condition, e.g., a lost db connection,
+// so the failed operation might succeed if retried later.
+type TransientError struct {
+	Err error
+}
+
+func (e *TransientError) Error() string {
+	return e.Err.Error()
+}
+
+// Transient indicates that the error is temporary.
+func (e *TransientError) Transient() bool {
+	return true
+}
+
+// IsTransient reports whether err is transient, i.e., it has Transient method which returns true.
+// All other errors are permanent, for instance, ErrUsernameTaken won't go away if a request is retried.
+func IsTransient(err error) bool {
+	e, ok := err.(interface {
+		Transient() bool
+	})
+	return ok && e.Transient()
+}
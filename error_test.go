@@ -0,0 +1,21 @@
+package account_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/marselester/distributed-signup"
+)
+
+func TestIsTransient(t *testing.T) {
+	tests := map[error]bool{
+		account.ErrUsernameTaken:                                 false,
+		errors.New("syntax error"):                               false,
+		&account.TransientError{Err: errors.New("conn is dead")}: true,
+	}
+	for err, want := range tests {
+		if got := account.IsTransient(err); got != want {
+			t.Errorf("IsTransient(%v) = %t, want %t", err, got, want)
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package kafka
 
 import (
+	"time"
+
 	"github.com/Shopify/sarama"
 
 	"github.com/marselester/distributed-signup"
@@ -24,7 +26,31 @@ type Config struct {
 	requestTopic     string
 	requestPartition int32
 	requestOffset    int64
+	// requestOffsetSet indicates that the request offset was set explicitly,
+	// so it takes precedence over the offset store.
+	requestOffsetSet bool
+	offsets          account.OffsetStore
 	responseTopic    string
+	group            string
+	onAssign         func(partitions []int32) error
+	onRevoke         func(partitions []int32)
+	partitioner      account.Partitioner
+	deadLetterTopic  string
+	retryDelays      []time.Duration
+	idempotent       bool
+	// transactionalID is a prefix of the transactional IDs of the request topic's partitions.
+	transactionalID string
+	readCommitted   bool
+	requiredAcks    sarama.RequiredAcks
+	// requiredAcksSet indicates that the required acks were set explicitly,
+	// so they are not overridden by the idempotent producer.
+	requiredAcksSet bool
+	tls             *tlsFiles
+	sasl            *saslAuth
+	// base is Sarama config set by WithSaramaConfig which the producer and consumers start with.
+	base *sarama.Config
+	// sarama are the changes of Sarama config made by the typed options, e.g., WithCompression.
+	sarama []func(*sarama.Config)
 
 	logger account.Logger
 }
@@ -57,6 +83,38 @@ func WithRequestPartition(partition int32) ConfigOption {
 func WithRequestOffset(offset int64) ConfigOption {
 	return func(c *Config) {
 		c.requestOffset = offset
+		c.requestOffsetSet = true
+	}
+}
+
+// WithOffsetStore sets a store of processed request offsets. Unless the request offset is set explicitly,
+// the requests are read starting after the latest processed offset of the partition.
+// If the partition has no processed requests yet, the default request offset is used.
+func WithOffsetStore(s account.OffsetStore) ConfigOption {
+	return func(c *Config) {
+		c.offsets = s
+	}
+}
+
+// WithConsumerGroup enables consumer-group mode where signup requests are read
+// from partitions assigned to the group's member, see SignupService.Requests.
+// The request partition is ignored in this mode, and offsets are committed to Kafka.
+// Unless the request offset is set explicitly to the oldest, the group starts from the newest requests.
+func WithConsumerGroup(group string) ConfigOption {
+	return func(c *Config) {
+		c.group = group
+	}
+}
+
+// WithRebalanceHooks sets functions which are called when the consumer group rebalances.
+// The assign function is called with the partitions claimed by the member before their requests are read.
+// If it fails, reading is stopped with the error.
+// The revoke function is called with the same partitions once their requests in progress are processed
+// and before the offsets are committed.
+func WithRebalanceHooks(assign func(partitions []int32) error, revoke func(partitions []int32)) ConfigOption {
+	return func(c *Config) {
+		c.onAssign = assign
+		c.onRevoke = revoke
 	}
 }
 
@@ -67,6 +125,175 @@ func WithResponseTopic(topic string) ConfigOption {
 	}
 }
 
+// WithPartitioner sets a partitioner which assigns partitions to requests and responses keyed by username.
+// It must be the same partitioner that shard routers use, see shard.NewUserService.
+func WithPartitioner(p account.Partitioner) ConfigOption {
+	return func(c *Config) {
+		c.partitioner = p
+	}
+}
+
+// WithDeadLetterTopic sets a topic where signup requests which can't be decoded are forwarded,
+// so reading continues past them instead of being stopped by a malformed message.
+// Malformed responses are skipped regardless, see SignupService.Responses.
+// A dead-lettered message keeps the original key and value, and its headers record the source topic,
+// partition, offset, the decoding error, and when the message was dead-lettered, see HeaderSourceTopic.
+// Record headers require Kafka 0.11 or newer. By default a malformed message stops reading.
+func WithDeadLetterTopic(topic string) ConfigOption {
+	return func(c *Config) {
+		c.deadLetterTopic = topic
+	}
+}
+
+// WithRetryTopics sets delays of the retry topics where requests are postponed when they keep failing
+// because of transient errors, see account.Retrier. A retry topic is named after the request topic and its delay
+// in seconds, e.g., account.signup_request.retry.5s. A request which fails again is postponed to the next topic,
+// and the error is returned once the last topic is tried.
+// The retry topics must have the same number of partitions as the request topic.
+// They are supported only in consumer-group mode, where their offsets are committed to Kafka.
+func WithRetryTopics(delays ...time.Duration) ConfigOption {
+	return func(c *Config) {
+		c.retryDelays = delays
+	}
+}
+
+// WithIdempotentProducer enables Kafka idempotent producer, so a request or a response is not duplicated
+// in a partition when the producer retries to send it, e.g., after a lost acknowledgement.
+// It requires Kafka 0.11 or newer.
+func WithIdempotentProducer() ConfigOption {
+	return func(c *Config) {
+		c.idempotent = true
+	}
+}
+
+// WithTransactionalID enables Kafka transactions in consumer-group mode: a response is written
+// in one transaction with the committed offset of its request, so the response is visible to read_committed
+// consumers (see WithReadCommitted) only if the request is not going to be read again, and vice versa.
+// Every partition of the request topic has its own transactional ID named after the prefix, e.g., signup-server-2,
+// so the transactions of the partition's previous owner are fenced off when the partition is assigned.
+// Requests postponed to retry topics and dead-lettered requests are written outside of transactions,
+// they are deduplicated by request ID, see account.DedupStore.
+// It enables the idempotent producer and requires Kafka 0.11 or newer.
+func WithTransactionalID(prefix string) ConfigOption {
+	return func(c *Config) {
+		c.transactionalID = prefix
+		c.idempotent = true
+	}
+}
+
+// WithReadCommitted sets read_committed isolation level, so the messages of aborted transactions
+// written by other producers are not read by Requests and Responses. It requires Kafka 0.11 or newer.
+// By default all the messages are read (read_uncommitted).
+func WithReadCommitted() ConfigOption {
+	return func(c *Config) {
+		c.readCommitted = true
+	}
+}
+
+// WithSaramaConfig sets Sarama config which the producer and consumers are configured with,
+// e.g., to tune settings which have no typed options. The typed options such as WithVersion
+// are applied on top of it, and so are the settings the SignupService relies on, for example,
+// the partitioner and returned successes of the producer. The config is copied, so it can be reused.
+func WithSaramaConfig(sc *sarama.Config) ConfigOption {
+	return func(c *Config) {
+		c.base = sc
+	}
+}
+
+// WithClientID sets a client ID which Kafka uses in logs and quotas, e.g., signup-server.
+func WithClientID(id string) ConfigOption {
+	return withSarama(func(sc *sarama.Config) {
+		sc.ClientID = id
+	})
+}
+
+// WithVersion sets Kafka protocol version the brokers support, see sarama.ParseKafkaVersion.
+// Some features require a newer version, e.g., record headers of WithDeadLetterTopic require Kafka 0.11,
+// so the version is raised if it is older than the one required.
+func WithVersion(v sarama.KafkaVersion) ConfigOption {
+	return withSarama(func(sc *sarama.Config) {
+		sc.Version = v
+	})
+}
+
+// WithRequiredAcks sets how many replica acknowledgements the producer waits for, see ParseRequiredAcks.
+// By default only the leader's acknowledgement is awaited, or all in-sync replicas' with WithIdempotentProducer.
+// The idempotent producer can't be opened with acks other than sarama.WaitForAll.
+func WithRequiredAcks(acks sarama.RequiredAcks) ConfigOption {
+	return func(c *Config) {
+		c.requiredAcks = acks
+		c.requiredAcksSet = true
+	}
+}
+
+// WithCompression sets a codec to compress produced messages, see ParseCompression.
+// By default messages are not compressed.
+func WithCompression(codec sarama.CompressionCodec) ConfigOption {
+	return withSarama(func(sc *sarama.Config) {
+		sc.Producer.Compression = codec
+	})
+}
+
+// WithMaxMessageBytes sets max size of a produced message, it should not exceed the brokers' message.max.bytes.
+func WithMaxMessageBytes(n int) ConfigOption {
+	return withSarama(func(sc *sarama.Config) {
+		sc.Producer.MaxMessageBytes = n
+	})
+}
+
+// WithFetchSize sets min, default, and max number of bytes the consumers fetch from a partition in one request.
+// The max of 0 means no limit.
+func WithFetchSize(min, def, max int32) ConfigOption {
+	return withSarama(func(sc *sarama.Config) {
+		sc.Consumer.Fetch.Min = min
+		sc.Consumer.Fetch.Default = def
+		sc.Consumer.Fetch.Max = max
+	})
+}
+
+// WithMetadataRefresh sets how often the cluster metadata is refreshed in the background, 0 disables it.
+func WithMetadataRefresh(frequency time.Duration) ConfigOption {
+	return withSarama(func(sc *sarama.Config) {
+		sc.Metadata.RefreshFrequency = frequency
+	})
+}
+
+// WithTLS enables TLS connections to brokers. The client certificate and its key are needed
+// if brokers authenticate clients by certificates (ssl.client.auth=required), otherwise they can be blank.
+// Brokers' certificates are verified with the CA certificates from the PEM file, or the system's ones if it is blank.
+// The verification can be skipped for testing, though it makes the connections vulnerable.
+// The files are read by Open.
+func WithTLS(certFile, keyFile, caFile string, insecureSkipVerify bool) ConfigOption {
+	return func(c *Config) {
+		c.tls = &tlsFiles{
+			certFile:           certFile,
+			keyFile:            keyFile,
+			caFile:             caFile,
+			insecureSkipVerify: insecureSkipVerify,
+		}
+	}
+}
+
+// WithSASL enables SASL authentication with brokers by the user and the password.
+// The mechanism is PLAIN, SCRAM-SHA-256, or SCRAM-SHA-512. SCRAM requires Kafka 1.0 or newer.
+// PLAIN sends the password as is, so it should be used along with TLS.
+func WithSASL(mechanism, user, password string) ConfigOption {
+	return func(c *Config) {
+		c.sasl = &saslAuth{
+			mechanism: mechanism,
+			user:      user,
+			password:  password,
+		}
+	}
+}
+
+// withSarama returns an option which changes Sarama config of the producer and consumers.
+func withSarama(f func(*sarama.Config)) ConfigOption {
+	return func(c *Config) {
+		c.sarama = append(c.sarama, f)
+	}
+}
+
 // WithLogger configures a logger to debug interactions with Kafka.
 func WithLogger(l account.Logger) ConfigOption {
 	return func(c *Config) {
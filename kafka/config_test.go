@@ -0,0 +1,153 @@
+package kafka
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestIdempotentProducer(t *testing.T) {
+	s := NewSignupService(WithIdempotentProducer())
+	c := s.producerConfig()
+	if err := c.Validate(); err != nil {
+		t.Fatalf("producerConfig() is invalid: %v", err)
+	}
+	if !c.Producer.Idempotent || c.Producer.RequiredAcks != sarama.WaitForAll {
+		t.Errorf("producerConfig() = %+v, want idempotent producer waiting for all replicas", c.Producer)
+	}
+}
+
+func TestIdempotentProducerAcks(t *testing.T) {
+	s := NewSignupService(WithIdempotentProducer(), WithRequiredAcks(sarama.WaitForLocal))
+	err := s.Open()
+	want := "kafka: idempotent producer requires acks=all (-1), got acks=1"
+	if err == nil || err.Error() != want {
+		t.Errorf("Open() = %v, want %s", err, want)
+	}
+
+	s = NewSignupService(WithIdempotentProducer(), WithRequiredAcks(sarama.WaitForAll))
+	if err = s.validateConfig(); err != nil {
+		t.Errorf("validateConfig() = %v, acks=all must be allowed", err)
+	}
+}
+
+func TestReadCommitted(t *testing.T) {
+	s := NewSignupService(WithReadCommitted(), WithConsumerGroup("signup-server"))
+	for name, c := range map[string]*sarama.Config{
+		"consumerConfig": s.consumerConfig(),
+		"groupConfig":    s.groupConfig(),
+	} {
+		if err := c.Validate(); err != nil {
+			t.Fatalf("%s() is invalid: %v", name, err)
+		}
+		if c.Consumer.IsolationLevel != sarama.ReadCommitted {
+			t.Errorf("%s() isolation level = %v, want read_committed", name, c.Consumer.IsolationLevel)
+		}
+	}
+
+	if c := NewSignupService().consumerConfig(); c.Consumer.IsolationLevel != sarama.ReadUncommitted {
+		t.Errorf("consumerConfig() isolation level = %v, want read_uncommitted by default", c.Consumer.IsolationLevel)
+	}
+}
+
+func TestSaramaConfig(t *testing.T) {
+	base := sarama.NewConfig()
+	base.ClientID = "base"
+	base.Net.DialTimeout = time.Second
+	s := NewSignupService(
+		WithSaramaConfig(base),
+		WithClientID("signup-server"),
+		WithVersion(sarama.V2_1_0_0),
+		WithRequiredAcks(sarama.WaitForAll),
+		WithCompression(sarama.CompressionLZ4),
+		WithMaxMessageBytes(1<<20),
+		WithFetchSize(1, 1<<16, 1<<20),
+		WithMetadataRefresh(time.Minute),
+		WithDeadLetterTopic("account.signup_dead_letter"),
+	)
+
+	c := s.producerConfig()
+	if c.ClientID != "signup-server" || c.Net.DialTimeout != time.Second || c.Version != sarama.V2_1_0_0 {
+		t.Errorf("producerConfig() = %+v, want base config with typed options", c)
+	}
+	if c.Producer.RequiredAcks != sarama.WaitForAll || c.Producer.Compression != sarama.CompressionLZ4 || c.Producer.MaxMessageBytes != 1<<20 {
+		t.Errorf("producerConfig() = %+v, want acks=all, lz4, 1MB messages", c.Producer)
+	}
+	if !c.Producer.Return.Successes {
+		t.Error("producerConfig() must return successes")
+	}
+	if c.Metadata.RefreshFrequency != time.Minute {
+		t.Errorf("producerConfig() metadata refresh = %s, want 1m", c.Metadata.RefreshFrequency)
+	}
+	if c = s.consumerConfig(); c.Consumer.Fetch.Min != 1 || c.Consumer.Fetch.Default != 1<<16 || c.Consumer.Fetch.Max != 1<<20 {
+		t.Errorf("consumerConfig() fetch = %+v", c.Consumer.Fetch)
+	}
+	if base.ClientID != "base" || base.Producer.Return.Successes {
+		t.Errorf("base config must not be changed: %+v", base)
+	}
+
+	// Record headers require Kafka 0.11.
+	s = NewSignupService(WithVersion(sarama.V0_10_0_0), WithDeadLetterTopic("account.signup_dead_letter"))
+	if c = s.producerConfig(); c.Version != sarama.V0_11_0_0 {
+		t.Errorf("producerConfig() version = %s, want 0.11.0.0", c.Version)
+	}
+}
+
+func TestOpenInvalidConfig(t *testing.T) {
+	s := NewSignupService(WithMaxMessageBytes(0))
+	err := s.Open()
+	want := "kafka: invalid producer config: kafka: invalid configuration (Producer.MaxMessageBytes must be > 0)"
+	if err == nil || err.Error() != want {
+		t.Errorf("Open() = %v, want %s", err, want)
+	}
+}
+
+func TestOpenZSTD(t *testing.T) {
+	s := NewSignupService(WithCompression(sarama.CompressionZSTD))
+	err := s.Open()
+	want := "kafka: zstd compression requires Kafka 2.1.0 or newer, got 0.8.2.0"
+	if err == nil || err.Error() != want {
+		t.Errorf("Open() = %v, want %s", err, want)
+	}
+
+	s = NewSignupService(WithCompression(sarama.CompressionZSTD), WithVersion(sarama.V2_1_0_0))
+	if err = s.validateConfig(); err != nil {
+		t.Errorf("validateConfig() = %v, zstd must be allowed with Kafka 2.1.0", err)
+	}
+}
+
+func TestParseRequiredAcks(t *testing.T) {
+	tests := map[string]sarama.RequiredAcks{
+		"none":   sarama.NoResponse,
+		"leader": sarama.WaitForLocal,
+		"all":    sarama.WaitForAll,
+		"-1":     sarama.WaitForAll,
+	}
+	for s, want := range tests {
+		if got, err := ParseRequiredAcks(s); err != nil || got != want {
+			t.Errorf("ParseRequiredAcks(%q) = %d, %v, want %d", s, got, err, want)
+		}
+	}
+	if _, err := ParseRequiredAcks("most"); err == nil {
+		t.Error("ParseRequiredAcks(most) must fail")
+	}
+}
+
+func TestParseCompression(t *testing.T) {
+	tests := map[string]sarama.CompressionCodec{
+		"none":   sarama.CompressionNone,
+		"gzip":   sarama.CompressionGZIP,
+		"snappy": sarama.CompressionSnappy,
+		"LZ4":    sarama.CompressionLZ4,
+		"zstd":   sarama.CompressionZSTD,
+	}
+	for s, want := range tests {
+		if got, err := ParseCompression(s); err != nil || got != want {
+			t.Errorf("ParseCompression(%q) = %s, %v, want %s", s, got, err, want)
+		}
+	}
+	if _, err := ParseCompression("brotli"); err == nil {
+		t.Error("ParseCompression(brotli) must fail")
+	}
+}
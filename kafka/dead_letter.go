@@ -0,0 +1,63 @@
+package kafka
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// Headers of a dead-lettered message which describe where the original message was read from and why it was rejected.
+const (
+	HeaderSourceTopic     = "source_topic"
+	HeaderSourcePartition = "source_partition"
+	HeaderSourceOffset    = "source_offset"
+	HeaderError           = "error"
+	// HeaderTimestamp is the time when the message was dead-lettered in RFC 3339 format.
+	HeaderTimestamp = "timestamp"
+)
+
+// deadLetter forwards the message m which couldn't be decoded because of cause to the dead-letter topic,
+// so reading can continue past it. The original key and value are kept intact.
+// If no dead-letter topic is configured, the cause is returned to stop reading.
+func (s *SignupService) deadLetter(m *sarama.ConsumerMessage, cause error) error {
+	if s.config.deadLetterTopic == "" {
+		return cause
+	}
+
+	dm := sarama.ProducerMessage{
+		Topic: s.config.deadLetterTopic,
+		Value: sarama.ByteEncoder(m.Value),
+		Headers: []sarama.RecordHeader{
+			{Key: []byte(HeaderSourceTopic), Value: []byte(m.Topic)},
+			{Key: []byte(HeaderSourcePartition), Value: []byte(strconv.FormatInt(int64(m.Partition), 10))},
+			{Key: []byte(HeaderSourceOffset), Value: []byte(strconv.FormatInt(m.Offset, 10))},
+			{Key: []byte(HeaderError), Value: []byte(cause.Error())},
+			{Key: []byte(HeaderTimestamp), Value: []byte(time.Now().UTC().Format(time.RFC3339Nano))},
+		},
+	}
+	if m.Key != nil {
+		dm.Key = sarama.ByteEncoder(m.Key)
+	}
+	partition, offset, err := s.producer.SendMessage(&dm)
+	if err != nil {
+		s.config.logger.Log("level", "debug", "msg", "message not dead-lettered", "topic", m.Topic, "partition", m.Partition, "offset", m.Offset, "err", err)
+		return err
+	}
+
+	n := atomic.AddInt64(&s.deadLetters, 1)
+	s.config.logger.Log("level", "debug", "msg", "message dead-lettered", "topic", m.Topic, "partition", m.Partition, "offset", m.Offset, "cause", cause, "dead_letter_partition", partition, "dead_letter_offset", offset, "dead_letters", n)
+	return nil
+}
+
+// DeadLetters returns the number of messages forwarded to the dead-letter topic since the service was created,
+// see WithDeadLetterTopic.
+func (s *SignupService) DeadLetters() int64 {
+	return atomic.LoadInt64(&s.deadLetters)
+}
+
+// SkippedResponses returns the number of responses which couldn't be decoded since the service was created.
+func (s *SignupService) SkippedResponses() int64 {
+	return atomic.LoadInt64(&s.skippedResponses)
+}
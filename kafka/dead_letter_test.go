@@ -0,0 +1,222 @@
+package kafka
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/marselester/distributed-signup"
+)
+
+// fakeProducer is a sarama.SyncProducer which keeps the sent messages.
+type fakeProducer struct {
+	sarama.SyncProducer
+	messages []*sarama.ProducerMessage
+}
+
+func (p *fakeProducer) SendMessage(m *sarama.ProducerMessage) (int32, int64, error) {
+	p.messages = append(p.messages, m)
+	return 0, int64(len(p.messages) - 1), nil
+}
+
+// fakeConsumer is a sarama.Consumer which passes the given messages from every partition.
+type fakeConsumer struct {
+	sarama.Consumer
+	messages []*sarama.ConsumerMessage
+}
+
+func (c *fakeConsumer) ConsumePartition(topic string, partition int32, offset int64) (sarama.PartitionConsumer, error) {
+	pc := fakePartitionConsumer{
+		messages: make(chan *sarama.ConsumerMessage, len(c.messages)),
+	}
+	for _, m := range c.messages {
+		pc.messages <- m
+	}
+	return &pc, nil
+}
+
+func (c *fakeConsumer) Partitions(topic string) ([]int32, error) {
+	return []int32{0}, nil
+}
+
+// fakePartitionConsumer is a sarama.PartitionConsumer which closes the messages channel on Close.
+type fakePartitionConsumer struct {
+	sarama.PartitionConsumer
+	messages  chan *sarama.ConsumerMessage
+	closeOnce sync.Once
+}
+
+func (pc *fakePartitionConsumer) Messages() <-chan *sarama.ConsumerMessage {
+	return pc.messages
+}
+
+func (pc *fakePartitionConsumer) Close() error {
+	pc.closeOnce.Do(func() {
+		close(pc.messages)
+	})
+	return nil
+}
+
+// headers returns headers of the message keyed by name.
+func headers(m *sarama.ProducerMessage) map[string]string {
+	h := make(map[string]string)
+	for _, rh := range m.Headers {
+		h[string(rh.Key)] = string(rh.Value)
+	}
+	return h
+}
+
+func TestRequestsDeadLetter(t *testing.T) {
+	producer := fakeProducer{}
+	s := NewSignupService(WithDeadLetterTopic("account.signup_dead_letter"))
+	s.producer = &producer
+	s.consumer = &fakeConsumer{
+		messages: []*sarama.ConsumerMessage{
+			{Topic: defaultRequestTopic, Partition: 2, Offset: 7, Key: []byte("bob"), Value: []byte(`{"request_id":`)},
+			{Topic: defaultRequestTopic, Partition: 2, Offset: 8, Key: []byte("bob"), Value: []byte(`{"request_id": "13rUw7cUfrGO9Go9xbZearzuuAu", "username": "bob"}`)},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var got []account.SignupRequest
+	err := s.Requests(ctx, func(_ context.Context, req *account.SignupRequest) {
+		got = append(got, *req)
+		cancel()
+	})
+	if err != nil {
+		t.Fatalf("Requests() = %v, must continue past malformed message", err)
+	}
+	want := account.SignupRequest{ID: "13rUw7cUfrGO9Go9xbZearzuuAu", Username: "bob", Partition: 2, SequenceID: 8}
+	if len(got) != 1 || got[0] != want {
+		t.Errorf("Requests() passed %+v, want %+v", got, want)
+	}
+
+	if len(producer.messages) != 1 {
+		t.Fatalf("Requests() dead-lettered %d messages, want 1", len(producer.messages))
+	}
+	m := producer.messages[0]
+	if m.Topic != "account.signup_dead_letter" {
+		t.Errorf("dead letter topic = %q, want account.signup_dead_letter", m.Topic)
+	}
+	if v, _ := m.Value.Encode(); string(v) != `{"request_id":` {
+		t.Errorf("dead letter value = %s, want original value", v)
+	}
+	if k, _ := m.Key.Encode(); string(k) != "bob" {
+		t.Errorf("dead letter key = %s, want bob", k)
+	}
+	h := headers(m)
+	if h[HeaderSourceTopic] != defaultRequestTopic || h[HeaderSourcePartition] != "2" || h[HeaderSourceOffset] != "7" ||
+		h[HeaderError] != "unexpected end of JSON input" || h[HeaderTimestamp] == "" {
+		t.Errorf("dead letter headers = %v", h)
+	}
+	if n := s.DeadLetters(); n != 1 {
+		t.Errorf("DeadLetters() = %d, want 1", n)
+	}
+}
+
+func TestRequestsMalformed(t *testing.T) {
+	s := NewSignupService()
+	s.producer = &fakeProducer{}
+	s.consumer = &fakeConsumer{
+		messages: []*sarama.ConsumerMessage{
+			{Topic: defaultRequestTopic, Value: []byte(`{"request_id":`)},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	err := s.Requests(ctx, func(_ context.Context, req *account.SignupRequest) {
+		t.Errorf("Requests() passed %+v, want none", req)
+	})
+	if err == nil {
+		t.Error("Requests() must stop on malformed message without dead-letter topic")
+	}
+	if n := s.DeadLetters(); n != 0 {
+		t.Errorf("DeadLetters() = %d, want 0", n)
+	}
+}
+
+// fakeOffsetStore is an account.OffsetSaver which keeps the saved offsets.
+type fakeOffsetStore struct {
+	offsets map[int32]int64
+}
+
+func (s *fakeOffsetStore) Offset(ctx context.Context, partition int32) (int64, error) {
+	offset, ok := s.offsets[partition]
+	if !ok {
+		return 0, account.ErrOffsetNotFound
+	}
+	return offset, nil
+}
+
+func (s *fakeOffsetStore) SaveOffset(ctx context.Context, partition int32, offset int64) error {
+	s.offsets[partition] = offset
+	return nil
+}
+
+func TestRequestsDeadLetterOffset(t *testing.T) {
+	offsets := fakeOffsetStore{offsets: make(map[int32]int64)}
+	s := NewSignupService(
+		WithDeadLetterTopic("account.signup_dead_letter"),
+		WithOffsetStore(&offsets),
+	)
+	s.producer = &fakeProducer{}
+	s.consumer = &fakeConsumer{
+		messages: []*sarama.ConsumerMessage{
+			{Topic: defaultRequestTopic, Partition: 0, Offset: 7, Value: []byte(`{"request_id":`)},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		for s.DeadLetters() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		cancel()
+	}()
+	if err := s.Requests(ctx, func(_ context.Context, req *account.SignupRequest) {}); err != nil {
+		t.Fatal(err)
+	}
+	// The server resumes reading after the dead-lettered request.
+	if got, err := offsets.Offset(ctx, 0); err != nil || got != 7 {
+		t.Errorf("Offset(0) = %d, %v, want 7", got, err)
+	}
+}
+
+func TestResponsesMalformed(t *testing.T) {
+	producer := fakeProducer{}
+	s := NewSignupService(WithDeadLetterTopic("account.signup_dead_letter"))
+	s.producer = &producer
+	s.consumer = &fakeConsumer{
+		messages: []*sarama.ConsumerMessage{
+			{Topic: defaultResponseTopic, Offset: 3, Value: []byte(`{"request_id":`)},
+			{Topic: defaultResponseTopic, Offset: 4, Value: []byte(`{"request_id": "13rUw7cUfrGO9Go9xbZearzuuAu", "username": "bob", "success": true}`)},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var got []account.SignupResponse
+	err := s.Responses(ctx, func(resp *account.SignupResponse) {
+		got = append(got, *resp)
+		cancel()
+	})
+	if err != nil {
+		t.Fatalf("Responses() = %v, must continue past malformed message", err)
+	}
+	if len(got) != 1 || got[0].RequestID != "13rUw7cUfrGO9Go9xbZearzuuAu" {
+		t.Errorf("Responses() passed %+v, want bob's response", got)
+	}
+	if n := s.SkippedResponses(); n != 1 {
+		t.Errorf("SkippedResponses() = %d, want 1", n)
+	}
+	// Every reader of the responses would forward the same message, so none does.
+	if len(producer.messages) != 0 || s.DeadLetters() != 0 {
+		t.Errorf("Responses() dead-lettered %d messages, want none", len(producer.messages))
+	}
+}
@@ -0,0 +1,87 @@
+package kafka
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/Shopify/sarama"
+)
+
+// Flags are the command-line flags which configure Kafka connections of the signup commands, e.g., TLS, SASL,
+// and the protocol version. They are defined by RegisterFlags and turned into config options by Options.
+type Flags struct {
+	TLS           bool
+	TLSCert       string
+	TLSKey        string
+	TLSCA         string
+	TLSInsecure   bool
+	SASLMechanism string
+	SASLUser      string
+	SASLPassword  string
+	ClientID      string
+	Version       string
+	Acks          string
+	Compression   string
+	Idempotent    bool
+	ReadCommitted bool
+}
+
+// RegisterFlags defines the Kafka flags in the flag set, clientID is the default of -client-id flag.
+func RegisterFlags(fs *flag.FlagSet, clientID string) *Flags {
+	f := Flags{}
+	fs.BoolVar(&f.TLS, "tls", false, "Connect to Kafka brokers over TLS.")
+	fs.StringVar(&f.TLSCert, "tls-cert", "", "Client certificate PEM file if brokers authenticate clients by certificates.")
+	fs.StringVar(&f.TLSKey, "tls-key", "", "Private key PEM file of the client certificate.")
+	fs.StringVar(&f.TLSCA, "tls-ca", "", "CA certificates PEM file to verify brokers (system CAs if blank).")
+	fs.BoolVar(&f.TLSInsecure, "tls-insecure", false, "Skip verification of brokers' certificates (for testing only).")
+	fs.StringVar(&f.SASLMechanism, "sasl-mechanism", "", "SASL mechanism to authenticate with Kafka brokers: PLAIN, SCRAM-SHA-256, or SCRAM-SHA-512.")
+	fs.StringVar(&f.SASLUser, "sasl-user", "", "SASL user.")
+	fs.StringVar(&f.SASLPassword, "sasl-password", "", "SASL password, it is safer to pass it as SASL_PASSWORD env variable.")
+	fs.StringVar(&f.ClientID, "client-id", clientID, "Client ID which Kafka uses in logs and quotas.")
+	fs.StringVar(&f.Version, "kafka-version", "", "Kafka protocol version the brokers support, e.g., 2.1.0 (Sarama's default if blank).")
+	fs.StringVar(&f.Acks, "acks", "", "Acknowledgements the producer waits for: none, leader, or all in-sync replicas. By default leader, or all if -idempotent is set.")
+	fs.StringVar(&f.Compression, "compression", "none", "Compression of produced messages: none, gzip, snappy, lz4, or zstd (-kafka-version=2.1.0+).")
+	fs.BoolVar(&f.Idempotent, "idempotent", false, "Enable Kafka idempotent producer, so retried messages are not duplicated (Kafka 0.11+).")
+	fs.BoolVar(&f.ReadCommitted, "read-committed", false, "Read only committed messages of transactional producers (Kafka 0.11+).")
+	return &f
+}
+
+// Options returns the config options set by the flags.
+// It fails if acks, compression, or the Kafka version can't be parsed.
+func (f *Flags) Options() ([]ConfigOption, error) {
+	codec, err := ParseCompression(f.Compression)
+	if err != nil {
+		return nil, err
+	}
+	options := []ConfigOption{
+		WithClientID(f.ClientID),
+		WithCompression(codec),
+	}
+	if f.Acks != "" {
+		acks, err := ParseRequiredAcks(f.Acks)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, WithRequiredAcks(acks))
+	}
+	if f.Version != "" {
+		v, err := sarama.ParseKafkaVersion(f.Version)
+		if err != nil {
+			return nil, fmt.Errorf("kafka: %v", err)
+		}
+		options = append(options, WithVersion(v))
+	}
+	if f.TLS {
+		options = append(options, WithTLS(f.TLSCert, f.TLSKey, f.TLSCA, f.TLSInsecure))
+	}
+	if f.SASLMechanism != "" {
+		options = append(options, WithSASL(f.SASLMechanism, f.SASLUser, f.SASLPassword))
+	}
+	if f.Idempotent {
+		options = append(options, WithIdempotentProducer())
+	}
+	if f.ReadCommitted {
+		options = append(options, WithReadCommitted())
+	}
+	return options, nil
+}
@@ -0,0 +1,63 @@
+package kafka
+
+import (
+	"flag"
+	"io/ioutil"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestFlags(t *testing.T) {
+	fs := flag.NewFlagSet("signup-server", flag.ContinueOnError)
+	f := RegisterFlags(fs, "signup-server")
+	err := fs.Parse([]string{
+		"-kafka-version=2.1.0",
+		"-acks=all",
+		"-compression=zstd",
+		"-idempotent",
+		"-read-committed",
+		"-sasl-mechanism=PLAIN",
+		"-sasl-user=signup",
+		"-sasl-password=swordfish",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	options, err := f.Options()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewSignupService(options...)
+	if err = s.validateConfig(); err != nil {
+		t.Fatalf("validateConfig() = %v", err)
+	}
+	c := s.producerConfig()
+	if c.ClientID != "signup-server" || c.Version != sarama.V2_1_0_0 {
+		t.Errorf("producerConfig() client ID = %q, version = %s, want signup-server 2.1.0", c.ClientID, c.Version)
+	}
+	if !c.Producer.Idempotent || c.Producer.RequiredAcks != sarama.WaitForAll || c.Producer.Compression != sarama.CompressionZSTD {
+		t.Errorf("producerConfig() = %+v, want idempotent producer with acks=all and zstd", c.Producer)
+	}
+	if !c.Net.SASL.Enable || c.Net.SASL.User != "signup" {
+		t.Errorf("producerConfig() SASL = %+v, want PLAIN user signup", c.Net.SASL)
+	}
+	if c = s.consumerConfig(); c.Consumer.IsolationLevel != sarama.ReadCommitted {
+		t.Errorf("consumerConfig() isolation level = %v, want read_committed", c.Consumer.IsolationLevel)
+	}
+}
+
+func TestFlagsInvalid(t *testing.T) {
+	for _, arg := range []string{"-kafka-version=2.1", "-acks=most", "-compression=brotli"} {
+		fs := flag.NewFlagSet("signup-server", flag.ContinueOnError)
+		fs.SetOutput(ioutil.Discard)
+		f := RegisterFlags(fs, "signup-server")
+		if err := fs.Parse([]string{arg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Options(); err == nil {
+			t.Errorf("Options() must fail with %s", arg)
+		}
+	}
+}
@@ -0,0 +1,325 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/marselester/distributed-signup"
+)
+
+// groupConfig returns Sarama config of the consumer group.
+// Consumer groups require Kafka 0.10.2 or newer, retry topics require 0.11 to read record headers,
+// and so do transactions.
+func (s *SignupService) groupConfig() *sarama.Config {
+	c := s.saramaConfig()
+	requireVersion(c, sarama.V0_10_2_0)
+	if len(s.config.retryDelays) > 0 || s.config.readCommitted || s.config.transactionalID != "" {
+		requireVersion(c, sarama.V0_11_0_0)
+	}
+	if s.config.readCommitted {
+		c.Consumer.IsolationLevel = sarama.ReadCommitted
+	}
+	if s.config.requestOffsetSet && s.config.requestOffset == sarama.OffsetOldest {
+		c.Consumer.Offsets.Initial = sarama.OffsetOldest
+	}
+	return c
+}
+
+// committedOffsets returns the group's committed offsets of the topics' partitions.
+// The offset is -1 if none was committed.
+func (s *SignupService) committedOffsets(topics []string, partitions []int32) (map[string]map[int32]int64, error) {
+	coordinator, err := s.client.Coordinator(s.config.group)
+	if err != nil {
+		return nil, err
+	}
+	req := sarama.OffsetFetchRequest{ConsumerGroup: s.config.group, Version: 1}
+	for _, topic := range topics {
+		for _, partition := range partitions {
+			req.AddPartition(topic, partition)
+		}
+	}
+	resp, err := coordinator.FetchOffset(&req)
+	if err != nil {
+		return nil, err
+	}
+
+	offsets := make(map[string]map[int32]int64, len(topics))
+	for _, topic := range topics {
+		offsets[topic] = make(map[int32]int64, len(partitions))
+		for _, partition := range partitions {
+			b := resp.GetBlock(topic, partition)
+			if b == nil {
+				return nil, fmt.Errorf("kafka: no committed offset of %s/%d", topic, partition)
+			}
+			if b.Err != sarama.ErrNoError {
+				return nil, fmt.Errorf("kafka: committed offset of %s/%d: %v", topic, partition, b.Err)
+			}
+			offsets[topic][partition] = b.Offset
+		}
+	}
+	return offsets, nil
+}
+
+// groupRequests reads signup requests from the partitions assigned to the consumer group member
+// and passes them to f until an error occurs (undecodable request or rebalance hook) or ctx is cancelled.
+// The group is rejoined after every rebalance.
+//
+// The group also reads the retry topics if they are configured, see WithRetryTopics.
+// Their partitions are assigned along with the same partitions of the request topic,
+// so all the requests of a username are processed by the same member.
+func (s *SignupService) groupRequests(ctx context.Context, f func(context.Context, *account.SignupRequest)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	topics := append([]string{s.config.requestTopic}, s.config.retryTopics()...)
+	h := groupHandler{
+		config:     &s.config,
+		deadLetter: s.deadLetter,
+		retry:      s.Retry,
+		retries:    s.retries,
+		pending:    s.pendingRetries,
+		offsets:    s.committedOffsets,
+		tiers:      make(map[string]int),
+		f:          f,
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+	for i, topic := range topics {
+		h.tiers[topic] = i
+	}
+	if s.config.transactionalID != "" {
+		h.txn = s.txn
+	}
+	for {
+		s.config.logger.Log("level", "debug", "msg", "requests group joined", "topics", topics, "group", s.config.group)
+		if err := s.group.Consume(ctx, topics, &h); err != nil {
+			s.config.logger.Log("level", "debug", "msg", "requests group failed", "err", err)
+			return err
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	s.config.logger.Log("level", "debug", "msg", "requests reading stopped")
+	return h.Err()
+}
+
+// groupHandler implements sarama.ConsumerGroupHandler to pass signup requests to f.
+// Partitions are consumed in separate goroutines, so f is called concurrently.
+type groupHandler struct {
+	config *Config
+	f      func(context.Context, *account.SignupRequest)
+	// deadLetter forwards an undecodable message to the dead-letter topic or returns an error to stop consuming.
+	deadLetter func(m *sarama.ConsumerMessage, cause error) error
+	// retry postpones a request to the retry topic of the next tier.
+	retry   func(ctx context.Context, req *account.SignupRequest, cause error) error
+	retries *retryTracker
+	// pending counts the requests waiting in the retry topics of the partitions, see pendingRetries.
+	pending func(ctx context.Context, partitions []int32) (map[string][]int, error)
+	// tiers is a number of retries of the requests read from a topic, i.e., 0 for the request topic.
+	tiers map[string]int
+	// txn returns the transactional producer of the partition. It is nil unless transactions are enabled.
+	txn func(partition int32) *txnProducer
+	// offsets returns the group's committed offsets of the topics' partitions.
+	offsets func(topics []string, partitions []int32) (map[string]map[int32]int64, error)
+	// committed are the offsets the previous owners of the partitions committed before their transactions
+	// were fenced off, see fence. It is set by Setup before the partitions are consumed.
+	committed map[string]map[int32]int64
+	// ctx is cancelled when reading is stopped, unlike a session's context which is also cancelled on rebalance.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu  sync.Mutex
+	err error
+}
+
+// Err returns the first error which stopped consuming.
+func (h *groupHandler) Err() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.err
+}
+
+// fail records the first error and stops consuming.
+func (h *groupHandler) fail(err error) {
+	h.mu.Lock()
+	if h.err == nil {
+		h.err = err
+	}
+	h.mu.Unlock()
+	h.cancel()
+}
+
+// Setup passes the claimed partitions to the assign hook before their requests are read.
+// The requests waiting in the retry topics of the partitions are counted first,
+// because the partitions might have been owned by another member.
+func (h *groupHandler) Setup(session sarama.ConsumerGroupSession) error {
+	partitions := session.Claims()[h.config.requestTopic]
+	h.config.logger.Log("level", "debug", "msg", "requests partitions assigned", "partitions", partitions, "generation", session.GenerationID())
+	if err := h.fence(partitions); err != nil {
+		h.fail(err)
+		return err
+	}
+	pending, err := h.pending(session.Context(), partitions)
+	if err != nil {
+		h.fail(err)
+		return err
+	}
+	h.retries.restore(pending)
+	if err := h.config.onAssign(partitions); err != nil {
+		h.fail(err)
+		return err
+	}
+	return nil
+}
+
+// fence initializes the transactional producers of the partitions, so the transactions of their previous owners
+// are fenced off, and then it reads the offsets the previous owners committed.
+// Sarama fetches the offsets to consume from before Setup, so a later offset might have been committed in between,
+// and the requests before it must not be processed again.
+func (h *groupHandler) fence(partitions []int32) error {
+	h.committed = nil
+	if h.txn == nil {
+		return nil
+	}
+	for _, p := range partitions {
+		if err := h.txn(p).init(); err != nil {
+			return err
+		}
+	}
+	topics := append([]string{h.config.requestTopic}, h.config.retryTopics()...)
+	committed, err := h.offsets(topics, partitions)
+	if err != nil {
+		return err
+	}
+	h.committed = committed
+	return nil
+}
+
+// Cleanup passes the claimed partitions to the revoke hook.
+// It is called once all ConsumeClaim goroutines have exited, i.e., requests in progress are processed.
+// The offsets are committed after Cleanup.
+func (h *groupHandler) Cleanup(session sarama.ConsumerGroupSession) error {
+	partitions := session.Claims()[h.config.requestTopic]
+	h.config.onRevoke(partitions)
+	h.config.logger.Log("level", "debug", "msg", "requests partitions revoked", "partitions", partitions, "generation", session.GenerationID())
+	return nil
+}
+
+// ConsumeClaim passes signup requests of a partition to f until the session ends.
+// The session's context is passed to f, so f stops waiting, e.g., for a paused shard map, when the group is rebalanced.
+// A request is marked as consumed only if neither reading nor the session is stopped when f returns,
+// because f cancels the context passed to Requests when it failed to process the request,
+// so the request is read again once the server is restarted or by the partition's new owner.
+// Requests of a retry topic are passed to f once their delay passes.
+//
+// If transactions are enabled, the context passed to f carries the transactional producer of the partition,
+// so the response is committed along with the request's offset, or the transaction is aborted
+// if the request isn't marked as consumed.
+func (h *groupHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	retries := h.tiers[claim.Topic()]
+	committed, fenced := h.committed[claim.Topic()][claim.Partition()]
+	for {
+		select {
+		case <-session.Context().Done():
+			return nil
+		case m, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			// The request was processed by the partition's previous owner, see fence.
+			if fenced && m.Offset < committed {
+				continue
+			}
+			h.config.logger.Log("level", "debug", "msg", "request received", "partition", m.Partition, "offset", m.Offset, "body", m.Value)
+			r := account.SignupRequest{}
+			if err := json.Unmarshal(m.Value, &r); err != nil {
+				if err = h.deadLetter(m, err); err != nil {
+					h.fail(err)
+					return err
+				}
+				if err = h.mark(session, m); err != nil {
+					h.fail(err)
+					return err
+				}
+				continue
+			}
+			r.Partition = m.Partition
+			r.SequenceID = m.Offset
+			r.Retries = retries
+			if retries > 0 && !waitRetry(session.Context(), m, &r) {
+				return nil
+			}
+			if err := h.process(session, m, &r); err != nil {
+				h.fail(err)
+				return err
+			}
+			if h.ctx.Err() != nil || session.Context().Err() != nil {
+				return nil
+			}
+		}
+	}
+}
+
+// process passes the request read from the message m to f unless the earlier requests of its username
+// wait in retry topics, in which case the request is postponed behind them to preserve the order.
+// Then the message is marked as consumed unless reading or the session is stopped.
+// Requests of the same partition are processed one at a time across the request and retry topics.
+func (h *groupHandler) process(session sarama.ConsumerGroupSession, m *sarama.ConsumerMessage, r *account.SignupRequest) error {
+	unlock := h.retries.lock(r.Partition)
+	defer unlock()
+
+	ctx := session.Context()
+	var txn *txnProducer
+	if h.txn != nil {
+		txn = h.txn(r.Partition)
+		ctx = withTxn(ctx, txn)
+	}
+	if h.retries.consume(account.NormalizeUsername(r.Username), r.Retries) {
+		if err := h.retry(h.ctx, r, errPostponed); err != nil {
+			return err
+		}
+	} else {
+		h.f(ctx, r)
+	}
+
+	if h.ctx.Err() != nil || session.Context().Err() != nil {
+		if txn == nil {
+			return nil
+		}
+		// The coordinator aborts the transaction anyway when the partition's new owner fences it off.
+		if err := txn.abort(); err != nil {
+			h.config.logger.Log("level", "debug", "msg", "transaction not aborted", "partition", r.Partition, "err", err)
+		}
+		return nil
+	}
+	return h.markLocked(session, m)
+}
+
+// mark marks the message as consumed, see markLocked.
+func (h *groupHandler) mark(session sarama.ConsumerGroupSession, m *sarama.ConsumerMessage) error {
+	unlock := h.retries.lock(m.Partition)
+	defer unlock()
+	return h.markLocked(session, m)
+}
+
+// markLocked marks the message as consumed in the session, or it commits the message's offset
+// in the transaction of its partition if transactions are enabled. The caller must hold the partition's lock.
+func (h *groupHandler) markLocked(session sarama.ConsumerGroupSession, m *sarama.ConsumerMessage) error {
+	if h.txn == nil {
+		session.MarkMessage(m, "")
+		return nil
+	}
+	err := h.txn(m.Partition).commit(m.Topic, m.Partition, m.Offset+1)
+	// The partition's new owner has fenced off the transaction, so the message is read again by the new owner.
+	if err != nil && session.Context().Err() != nil {
+		h.config.logger.Log("level", "debug", "msg", "transaction not committed after rebalance", "topic", m.Topic, "partition", m.Partition, "offset", m.Offset, "err", err)
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,58 @@
+package kafka
+
+import (
+	"github.com/Shopify/sarama"
+
+	"github.com/marselester/distributed-signup"
+)
+
+// partitioner adapts account.Partitioner to sarama.Partitioner,
+// so messages keyed by username are assigned the same partitions as shard routers expect.
+type partitioner struct {
+	p account.Partitioner
+}
+
+// Partition returns a partition of the message's key, messages without a key go to the partition 0.
+func (p *partitioner) Partition(m *sarama.ProducerMessage, numPartitions int32) (int32, error) {
+	if m.Key == nil {
+		return 0, nil
+	}
+	key, err := m.Key.Encode()
+	if err != nil {
+		return -1, err
+	}
+	return p.p.Partition(string(key), numPartitions), nil
+}
+
+// RequiresConsistency indicates that a key is always assigned the same partition,
+// so Sarama doesn't send a message to another partition when its leader is not available.
+func (p *partitioner) RequiresConsistency() bool {
+	return true
+}
+
+// producerConfig returns Sarama config where the producer assigns partitions using the configured partitioner.
+// When the dead-letter or retry topics are configured, Kafka 0.11 protocol is used to send record headers.
+// The idempotent producer requires acknowledgements from all in-sync replicas and one request in flight.
+func (s *SignupService) producerConfig() *sarama.Config {
+	c := s.saramaConfig()
+	// SyncProducer requires successes to be returned.
+	c.Producer.Return.Successes = true
+	if s.config.deadLetterTopic != "" || len(s.config.retryDelays) > 0 {
+		requireVersion(c, sarama.V0_11_0_0)
+	}
+	if s.config.requiredAcksSet {
+		c.Producer.RequiredAcks = s.config.requiredAcks
+	}
+	if s.config.idempotent {
+		requireVersion(c, sarama.V0_11_0_0)
+		c.Producer.Idempotent = true
+		if !s.config.requiredAcksSet {
+			c.Producer.RequiredAcks = sarama.WaitForAll
+		}
+		c.Net.MaxOpenRequests = 1
+	}
+	c.Producer.Partitioner = func(topic string) sarama.Partitioner {
+		return &partitioner{p: s.config.partitioner}
+	}
+	return c
+}
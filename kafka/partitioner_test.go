@@ -0,0 +1,49 @@
+package kafka
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/marselester/distributed-signup"
+)
+
+func TestPartitioner(t *testing.T) {
+	s := NewSignupService(WithPartitioner(account.Murmur2Partitioner{}))
+	p := s.producerConfig().Producer.Partitioner(defaultRequestTopic)
+	if !p.RequiresConsistency() {
+		t.Errorf("RequiresConsistency() = false, want true")
+	}
+
+	for _, username := range []string{"bob", "alice", "john", "lloyd", "aaron", "peter", "sam"} {
+		m := sarama.ProducerMessage{Key: sarama.StringEncoder(username)}
+		got, err := p.Partition(&m, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := (account.Murmur2Partitioner{}).Partition(username, 3); got != want {
+			t.Errorf("Partition(%s) = %d, want %d", username, got, want)
+		}
+	}
+}
+
+func TestPartitionerDefault(t *testing.T) {
+	s := NewSignupService()
+	p := s.producerConfig().Producer.Partitioner(defaultRequestTopic)
+	hash := sarama.NewHashPartitioner(defaultRequestTopic)
+
+	for _, username := range []string{"bob", "alice", "john", "lloyd", "aaron", "peter", "sam"} {
+		m := sarama.ProducerMessage{Key: sarama.StringEncoder(username)}
+		got, err := p.Partition(&m, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := hash.Partition(&m, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("Partition(%s) = %d, Sarama's hash partitioner = %d", username, got, want)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/marselester/distributed-signup"
+)
+
+// HeaderRetryAt is the time when a postponed request should be retried in RFC 3339 format.
+const HeaderRetryAt = "retry_at"
+
+// errPostponed is the cause of postponing a request which has to wait for the earlier requests of its username.
+var errPostponed = errors.New("earlier requests of the username are waiting in retry topics")
+
+// retryTopics returns names of the retry topics, e.g., account.signup_request.retry.5s.
+func (c *Config) retryTopics() []string {
+	topics := make([]string, len(c.retryDelays))
+	for i, d := range c.retryDelays {
+		topics[i] = fmt.Sprintf("%s.retry.%ds", c.requestTopic, int64(d/time.Second))
+	}
+	return topics
+}
+
+// Retry postpones the signup request by writing it into the retry topic of the next tier,
+// so it is read again by Requests once the tier's delay passes.
+// The request keeps its partition, because retry topics are keyed by username like the request topic.
+// If the request has been already postponed to the last tier, the cause is returned.
+func (s *SignupService) Retry(ctx context.Context, req *account.SignupRequest, cause error) error {
+	tier := req.Retries
+	if tier >= len(s.config.retryDelays) {
+		return cause
+	}
+
+	b, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	key := account.NormalizeUsername(req.Username)
+	m := sarama.ProducerMessage{
+		Topic: s.config.retryTopics()[tier],
+		Key:   sarama.StringEncoder(key),
+		Value: sarama.ByteEncoder(b),
+		Headers: []sarama.RecordHeader{
+			{Key: []byte(HeaderSourceOffset), Value: []byte(strconv.FormatInt(req.SequenceID, 10))},
+			{Key: []byte(HeaderError), Value: []byte(cause.Error())},
+			{Key: []byte(HeaderRetryAt), Value: []byte(time.Now().Add(s.config.retryDelays[tier]).UTC().Format(time.RFC3339Nano))},
+		},
+	}
+
+	// The request is tracked before it is written, so it can't be read from the retry topic untracked.
+	s.retries.add(key, tier)
+	partition, offset, err := s.producer.SendMessage(&m)
+	if err != nil {
+		s.retries.remove(key, tier)
+		s.config.logger.Log("level", "debug", "msg", "request not postponed", "topic", m.Topic, "body", b, "err", err)
+		return err
+	}
+
+	s.config.logger.Log("level", "debug", "msg", "request postponed", "topic", m.Topic, "partition", partition, "offset", offset, "cause", cause, "body", b)
+	return nil
+}
+
+// retryTracker keeps track of the requests waiting in retry topics,
+// so the requests of a username are processed in the order they were written to the request topic.
+//
+// Requests of a username occupy the retry tiers in order: the earliest request is in the latest tier.
+// A request is processed only if no requests of its username wait in the later tiers,
+// otherwise it is postponed to the next tier behind them.
+// The requests are tracked in memory, and they are counted again in the retry topics
+// when partitions are assigned after a restart or rebalance, see pendingRetries.
+type retryTracker struct {
+	mu sync.Mutex
+	// pending is a number of requests of a username (normalized) waiting in every retry topic.
+	pending map[string][]int
+	// partitions serialize processing of the same partition of the request and retry topics.
+	partitions map[int32]*sync.Mutex
+	tiers      int
+}
+
+// newRetryTracker returns a tracker of requests in the given number of retry topics.
+func newRetryTracker(tiers int) *retryTracker {
+	return &retryTracker{
+		pending:    make(map[string][]int),
+		partitions: make(map[int32]*sync.Mutex),
+		tiers:      tiers,
+	}
+}
+
+// lock locks the partition and returns a function to unlock it.
+func (t *retryTracker) lock(partition int32) func() {
+	t.mu.Lock()
+	pm, ok := t.partitions[partition]
+	if !ok {
+		pm = &sync.Mutex{}
+		t.partitions[partition] = pm
+	}
+	t.mu.Unlock()
+
+	pm.Lock()
+	return pm.Unlock
+}
+
+// add tracks the request of a username written into the retry topic of the given tier.
+func (t *retryTracker) add(key string, tier int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.pending[key] == nil {
+		t.pending[key] = make([]int, t.tiers)
+	}
+	t.pending[key][tier]++
+}
+
+// remove stops tracking the request of a username in the retry topic of the given tier.
+func (t *retryTracker) remove(key string, tier int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.removeLocked(key, tier)
+}
+
+// removeLocked stops tracking the request. The caller must hold the lock.
+func (t *retryTracker) removeLocked(key string, tier int) {
+	counts := t.pending[key]
+	if counts == nil {
+		return
+	}
+	if counts[tier] > 0 {
+		counts[tier]--
+	}
+	for _, n := range counts {
+		if n > 0 {
+			return
+		}
+	}
+	delete(t.pending, key)
+}
+
+// consume stops tracking the request of a username which was read after the given number of retries
+// (0 if it was read from the request topic). It reports whether the earlier requests of the username
+// wait in the later tiers, so the request must be postponed behind them.
+func (t *retryTracker) consume(key string, retries int) (postpone bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if retries > 0 {
+		t.removeLocked(key, retries-1)
+	}
+	counts := t.pending[key]
+	for tier := retries; tier < len(counts); tier++ {
+		if counts[tier] > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// restore replaces the tracked requests with the ones waiting in the retry topics
+// of the assigned partitions, e.g., when partitions are reassigned.
+func (t *retryTracker) restore(pending map[string][]int) {
+	t.mu.Lock()
+	t.pending = pending
+	t.mu.Unlock()
+}
+
+// pendingRetries counts the requests of every username (normalized) waiting in the retry topics' partitions,
+// i.e., the messages from the group's committed offsets to the end of the partitions.
+// Partitions are assigned once their previous owners have processed the requests in progress
+// and committed the offsets, so the counts match what the member is going to read.
+func (s *SignupService) pendingRetries(ctx context.Context, partitions []int32) (map[string][]int, error) {
+	topics := s.config.retryTopics()
+	pending := make(map[string][]int)
+	if len(topics) == 0 || len(partitions) == 0 {
+		return pending, nil
+	}
+
+	committed, err := s.committedOffsets(topics, partitions)
+	if err != nil {
+		return nil, err
+	}
+
+	consumer, err := sarama.NewConsumerFromClient(s.client)
+	if err != nil {
+		return nil, err
+	}
+	defer consumer.Close()
+
+	for tier, topic := range topics {
+		for _, partition := range partitions {
+			keys, err := s.scanRetries(ctx, consumer, topic, partition, committed[topic][partition])
+			if err != nil {
+				return nil, err
+			}
+			for _, key := range keys {
+				if pending[key] == nil {
+					pending[key] = make([]int, len(topics))
+				}
+				pending[key][tier]++
+			}
+		}
+	}
+
+	s.config.logger.Log("level", "debug", "msg", "retries counted", "partitions", partitions, "usernames", len(pending))
+	return pending, nil
+}
+
+// scanRetries returns the keys of the messages in the retry topic's partition from the committed offset to the end.
+// If the offset is not committed or it has expired, the group starts from the initial offset, and so does the scan.
+// Retry topics are written without transactions, so the partition ends with a message rather than a control record.
+func (s *SignupService) scanRetries(ctx context.Context, consumer sarama.Consumer, topic string, partition int32, offset int64) ([]string, error) {
+	newest, err := s.client.GetOffset(topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return nil, err
+	}
+	oldest, err := s.client.GetOffset(topic, partition, sarama.OffsetOldest)
+	if err != nil {
+		return nil, err
+	}
+	if offset < oldest || offset > newest {
+		offset = newest
+		if s.client.Config().Consumer.Offsets.Initial == sarama.OffsetOldest {
+			offset = oldest
+		}
+	}
+	if offset >= newest {
+		return nil, nil
+	}
+
+	pc, err := consumer.ConsumePartition(topic, partition, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer pc.Close()
+
+	var keys []string
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case m, ok := <-pc.Messages():
+			if !ok {
+				return nil, fmt.Errorf("kafka: %s/%d is closed before offset %d", topic, partition, newest)
+			}
+			keys = append(keys, string(m.Key))
+			if m.Offset+1 >= newest {
+				return keys, nil
+			}
+		}
+	}
+}
+
+// header returns the value of the message's header or a blank string if there is no such header.
+func header(m *sarama.ConsumerMessage, key string) string {
+	for _, h := range m.Headers {
+		if h != nil && string(h.Key) == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+// waitRetry restores the request's offset in the request topic from the message of a retry topic
+// and waits until the request should be retried. It returns false if ctx is cancelled earlier.
+func waitRetry(ctx context.Context, m *sarama.ConsumerMessage, r *account.SignupRequest) bool {
+	if offset, err := strconv.ParseInt(header(m, HeaderSourceOffset), 10, 64); err == nil {
+		r.SequenceID = offset
+	}
+
+	retryAt, err := time.Parse(time.RFC3339Nano, header(m, HeaderRetryAt))
+	if err != nil {
+		return true
+	}
+	t := time.NewTimer(time.Until(retryAt))
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
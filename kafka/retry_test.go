@@ -0,0 +1,152 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/marselester/distributed-signup"
+)
+
+func TestRetry(t *testing.T) {
+	producer := fakeProducer{}
+	s := NewSignupService(WithRetryTopics(5*time.Second, 2*time.Minute))
+	s.producer = &producer
+
+	ctx := context.Background()
+	cause := errors.New("connection lost")
+	req := account.SignupRequest{ID: "13rUw7cUfrGO9Go9xbZearzuuAu", Username: "Bob", Partition: 2, SequenceID: 7}
+	for retries, topic := range []string{"account.signup_request.retry.5s", "account.signup_request.retry.120s"} {
+		req.Retries = retries
+		if err := s.Retry(ctx, &req, cause); err != nil {
+			t.Fatalf("Retry(%+v) = %v", req, err)
+		}
+		m := producer.messages[retries]
+		if m.Topic != topic {
+			t.Errorf("Retry(%+v) topic = %q, want %q", req, m.Topic, topic)
+		}
+		if k, _ := m.Key.Encode(); string(k) != "bob" {
+			t.Errorf("Retry(%+v) key = %s, want bob", req, k)
+		}
+		h := headers(m)
+		if h[HeaderSourceOffset] != "7" || h[HeaderError] != "connection lost" || h[HeaderRetryAt] == "" {
+			t.Errorf("Retry(%+v) headers = %v", req, h)
+		}
+	}
+
+	req.Retries = 2
+	if err := s.Retry(ctx, &req, cause); err != cause {
+		t.Errorf("Retry(%+v) = %v, want the cause after the last topic", req, err)
+	}
+}
+
+func TestRetryTracker(t *testing.T) {
+	tr := newRetryTracker(2)
+	// Bob's first request is postponed to the first retry topic.
+	if tr.consume("bob", 0) {
+		t.Fatal("consume(bob, 0) = true, no requests are postponed yet")
+	}
+	tr.add("bob", 0)
+
+	// Bob's second request has to wait behind the first one, while alice is not affected.
+	if !tr.consume("bob", 0) {
+		t.Error("consume(bob, 0) = false, the second request must be postponed")
+	}
+	tr.add("bob", 0)
+	if tr.consume("alice", 0) {
+		t.Error("consume(alice, 0) = true, want false")
+	}
+
+	// The first request fails again and it is postponed to the second retry topic.
+	if tr.consume("bob", 1) {
+		t.Fatal("consume(bob, 1) = true, the first request must be processed")
+	}
+	tr.add("bob", 1)
+	// The second request is read from the first retry topic and it has to follow the first one.
+	if !tr.consume("bob", 1) {
+		t.Fatal("consume(bob, 1) = false, the second request must be postponed")
+	}
+	tr.add("bob", 1)
+
+	// Both requests are read from the second retry topic in order.
+	if tr.consume("bob", 2) || tr.consume("bob", 2) {
+		t.Error("consume(bob, 2) = true, the requests must be processed")
+	}
+	if len(tr.pending) != 0 {
+		t.Errorf("pending = %v, want none", tr.pending)
+	}
+}
+
+func TestPendingRetries(t *testing.T) {
+	const (
+		first  = "account.signup_request.retry.5s"
+		second = "account.signup_request.retry.30s"
+	)
+	// Bob's request and alice's request wait in the first retry topic after the committed offset 11,
+	// and bob's earlier request waits in the second retry topic. The rest have been read already.
+	fetch := &sarama.FetchResponse{Version: 2}
+	fetch.AddMessage(first, 2, sarama.StringEncoder("sam"), sarama.StringEncoder("{}"), 10)
+	fetch.AddMessage(first, 2, sarama.StringEncoder("bob"), sarama.StringEncoder("{}"), 11)
+	fetch.AddMessage(first, 2, sarama.StringEncoder("alice"), sarama.StringEncoder("{}"), 12)
+	fetch.AddMessage(second, 2, sarama.StringEncoder("bob"), sarama.StringEncoder("{}"), 0)
+	fetch.GetBlock(first, 2).HighWaterMarkOffset = 13
+	fetch.GetBlock(second, 2).HighWaterMarkOffset = 1
+
+	b := sarama.NewMockBroker(t, 1)
+	defer b.Close()
+	b.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(b.Addr(), b.BrokerID()).
+			SetLeader(first, 2, b.BrokerID()).
+			SetLeader(second, 2, b.BrokerID()),
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+			SetCoordinator(sarama.CoordinatorGroup, "signup-server", b),
+		"OffsetFetchRequest": sarama.NewMockOffsetFetchResponse(t).
+			SetOffset("signup-server", first, 2, 11, "", sarama.ErrNoError).
+			SetOffset("signup-server", second, 2, -1, "", sarama.ErrNoError),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetOffset(first, 2, sarama.OffsetOldest, 0).
+			SetOffset(first, 2, sarama.OffsetNewest, 13).
+			SetOffset(second, 2, sarama.OffsetOldest, 0).
+			SetOffset(second, 2, sarama.OffsetNewest, 1),
+		"FetchRequest": sarama.NewMockWrapper(fetch),
+	})
+
+	s := NewSignupService(
+		WithBrokers(b.Addr()),
+		WithConsumerGroup("signup-server"),
+		WithRetryTopics(5*time.Second, 30*time.Second),
+		WithRequestOffset(sarama.OffsetOldest),
+	)
+	// Sarama's mock broker answers offset requests with the Kafka 0.10.0 protocol only.
+	c := s.groupConfig()
+	c.Version = sarama.V0_10_0_0
+	var err error
+	if s.client, err = sarama.NewClient([]string{b.Addr()}, c); err != nil {
+		t.Fatal(err)
+	}
+	defer s.client.Close()
+
+	pending, err := s.pendingRetries(context.Background(), []int32{2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]int{"bob": {1, 1}, "alice": {1, 0}}
+	if !reflect.DeepEqual(pending, want) {
+		t.Errorf("pendingRetries(2) = %v, want %v", pending, want)
+	}
+
+	// Bob's new request must wait behind his earlier requests as before the rebalance.
+	tr := newRetryTracker(2)
+	tr.restore(pending)
+	if !tr.consume("bob", 0) {
+		t.Error("consume(bob, 0) = false, the request must be postponed after the restore")
+	}
+	if tr.consume("sam", 0) {
+		t.Error("consume(sam, 0) = true, sam has no pending requests")
+	}
+}
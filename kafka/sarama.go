@@ -0,0 +1,93 @@
+package kafka
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Shopify/sarama"
+)
+
+// saramaConfig returns a copy of the base Sarama config with the typed options applied.
+// The producer and consumers start with it, see producerConfig.
+func (s *SignupService) saramaConfig() *sarama.Config {
+	c := sarama.NewConfig()
+	if s.config.base != nil {
+		base := *s.config.base
+		c = &base
+	}
+	for _, f := range s.config.sarama {
+		f(c)
+	}
+
+	if s.tlsConfig != nil {
+		c.Net.TLS.Enable = true
+		c.Net.TLS.Config = s.tlsConfig
+	}
+	if s.config.sasl != nil {
+		s.config.sasl.configure(c)
+	}
+	return c
+}
+
+// requireVersion raises the config's protocol version to v unless it is newer already.
+func requireVersion(c *sarama.Config, v sarama.KafkaVersion) {
+	if !c.Version.IsAtLeast(v) {
+		c.Version = v
+	}
+}
+
+// validateConfig checks Sarama configs of the producer and consumers, so a misconfiguration is reported by Open
+// before connecting to Kafka.
+func (s *SignupService) validateConfig() error {
+	if s.config.idempotent && s.config.requiredAcksSet && s.config.requiredAcks != sarama.WaitForAll {
+		return fmt.Errorf("kafka: idempotent producer requires acks=all (-1), got acks=%d", s.config.requiredAcks)
+	}
+	pc := s.producerConfig()
+	if err := pc.Validate(); err != nil {
+		return fmt.Errorf("kafka: invalid producer config: %v", err)
+	}
+	if pc.Producer.Compression == sarama.CompressionZSTD && !pc.Version.IsAtLeast(sarama.V2_1_0_0) {
+		return fmt.Errorf("kafka: zstd compression requires Kafka 2.1.0 or newer, got %s", pc.Version)
+	}
+	if err := s.consumerConfig().Validate(); err != nil {
+		return fmt.Errorf("kafka: invalid consumer config: %v", err)
+	}
+	if s.config.group == "" {
+		return nil
+	}
+	if err := s.groupConfig().Validate(); err != nil {
+		return fmt.Errorf("kafka: invalid consumer group config: %v", err)
+	}
+	return nil
+}
+
+// ParseRequiredAcks returns the producer's required acknowledgements by name:
+// none (don't wait), leader (wait for the leader), or all (wait for all in-sync replicas).
+func ParseRequiredAcks(s string) (sarama.RequiredAcks, error) {
+	switch strings.ToLower(s) {
+	case "none", "0":
+		return sarama.NoResponse, nil
+	case "leader", "1":
+		return sarama.WaitForLocal, nil
+	case "all", "-1":
+		return sarama.WaitForAll, nil
+	}
+	return 0, fmt.Errorf("kafka: unknown required acks %q", s)
+}
+
+// ParseCompression returns the compression codec by name: none, gzip, snappy, lz4, or zstd.
+// Note, zstd requires Kafka 2.1 or newer, see WithVersion, otherwise Open fails.
+func ParseCompression(s string) (sarama.CompressionCodec, error) {
+	for _, codec := range []sarama.CompressionCodec{
+		sarama.CompressionNone,
+		sarama.CompressionGZIP,
+		sarama.CompressionSnappy,
+		sarama.CompressionLZ4,
+		sarama.CompressionZSTD,
+	} {
+		if strings.ToLower(s) == codec.String() {
+			return codec, nil
+		}
+	}
+	return 0, fmt.Errorf("kafka: unknown compression codec %q", s)
+}
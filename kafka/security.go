@@ -0,0 +1,107 @@
+package kafka
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/Shopify/sarama"
+	"github.com/xdg/scram"
+)
+
+// tlsFiles are the files to set up TLS connections to brokers, see WithTLS.
+type tlsFiles struct {
+	certFile           string
+	keyFile            string
+	caFile             string
+	insecureSkipVerify bool
+}
+
+// load reads the client certificate and the CA certificates to verify brokers.
+// If there is no CA file, the system's CA certificates are used.
+func (f *tlsFiles) load() (*tls.Config, error) {
+	c := tls.Config{
+		InsecureSkipVerify: f.insecureSkipVerify,
+	}
+
+	if f.certFile != "" || f.keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(f.certFile, f.keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("kafka: failed to load client certificate: %v", err)
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+
+	if f.caFile != "" {
+		ca, err := ioutil.ReadFile(f.caFile)
+		if err != nil {
+			return nil, fmt.Errorf("kafka: failed to read CA certificates: %v", err)
+		}
+		c.RootCAs = x509.NewCertPool()
+		if !c.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("kafka: no CA certificates found in %s", f.caFile)
+		}
+	}
+	return &c, nil
+}
+
+// saslAuth are the credentials to authenticate with brokers, see WithSASL.
+type saslAuth struct {
+	mechanism string
+	user      string
+	password  string
+}
+
+// configure enables SASL authentication in Sarama config.
+// SCRAM requires Kafka 1.0 or newer because Sarama authenticates with SaslAuthenticate requests.
+func (a *saslAuth) configure(c *sarama.Config) {
+	c.Net.SASL.Enable = true
+	c.Net.SASL.Mechanism = sarama.SASLMechanism(strings.ToUpper(a.mechanism))
+	c.Net.SASL.User = a.user
+	c.Net.SASL.Password = a.password
+
+	var hashFn scram.HashGeneratorFcn
+	switch c.Net.SASL.Mechanism {
+	case sarama.SASLTypeSCRAMSHA256:
+		hashFn = sha256.New
+	case sarama.SASLTypeSCRAMSHA512:
+		hashFn = sha512.New
+	default:
+		return
+	}
+	requireVersion(c, sarama.V1_0_0_0)
+	c.Net.SASL.Handshake = true
+	c.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+		return &scramClient{hashFn: hashFn}
+	}
+}
+
+// scramClient implements sarama.SCRAMClient to authenticate with SCRAM-SHA-256 or SCRAM-SHA-512.
+type scramClient struct {
+	hashFn scram.HashGeneratorFcn
+	conv   *scram.ClientConversation
+}
+
+// Begin prepares the client for the SCRAM exchange with the server.
+func (c *scramClient) Begin(user, password, authzID string) error {
+	client, err := c.hashFn.NewClient(user, password, authzID)
+	if err != nil {
+		return err
+	}
+	c.conv = client.NewConversation()
+	return nil
+}
+
+// Step returns the response to the server's challenge.
+func (c *scramClient) Step(challenge string) (string, error) {
+	return c.conv.Step(challenge)
+}
+
+// Done reports whether the SCRAM exchange is over.
+func (c *scramClient) Done() bool {
+	return c.conv.Done()
+}
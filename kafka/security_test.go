@@ -0,0 +1,234 @@
+package kafka
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/xdg/scram"
+)
+
+// testCert is a certificate with its key signed by a test CA.
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+// mustCert issues a certificate for localhost signed by the parent, or a self-signed CA certificate if parent is nil.
+func mustCert(t *testing.T, serial int64, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := &tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+// writePEM writes the certificate and its key into PEM files in the dir and returns their paths.
+func (c *testCert) writePEM(t *testing.T, dir, name string) (certFile, keyFile string) {
+	certFile = filepath.Join(dir, name+".pem")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	b, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile = filepath.Join(dir, name+"-key.pem")
+	if err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: b}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+// quietReporter is a sarama.TestReporter which ignores errors of a mock broker, e.g., failed TLS handshakes.
+type quietReporter struct {
+	*testing.T
+}
+
+func (quietReporter) Error(...interface{})          {}
+func (quietReporter) Errorf(string, ...interface{}) {}
+
+// mustTLSBroker starts a mock broker which accepts TLS connections from the clients having certificates signed by ca.
+func mustTLSBroker(t sarama.TestReporter, ca, server *testCert) *sarama.MockBroker {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{server.der}, PrivateKey: server.key}},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := sarama.NewMockBrokerListener(t, 1, ln)
+	b.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).SetBroker(b.Addr(), b.BrokerID()),
+	})
+	return b
+}
+
+func TestOpenTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kafka")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := mustCert(t, 1, nil)
+	caFile, _ := ca.writePEM(t, dir, "ca")
+	certFile, keyFile := mustCert(t, 3, ca).writePEM(t, dir, "client")
+
+	b := mustTLSBroker(t, ca, mustCert(t, 2, ca))
+	defer b.Close()
+
+	s := NewSignupService(
+		WithBrokers(b.Addr()),
+		WithTLS(certFile, keyFile, caFile, false),
+	)
+	if err = s.Open(); err != nil {
+		t.Fatalf("Open() = %v, want TLS connection", err)
+	}
+	s.Close()
+}
+
+func TestOpenTLSNoClientCert(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kafka")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := mustCert(t, 1, nil)
+	caFile, _ := ca.writePEM(t, dir, "ca")
+
+	b := mustTLSBroker(quietReporter{t}, ca, mustCert(t, 2, ca))
+	defer b.Close()
+
+	s := NewSignupService(
+		WithBrokers(b.Addr()),
+		WithTLS("", "", caFile, false),
+		withSarama(func(c *sarama.Config) {
+			c.Metadata.Retry.Max = 0
+		}),
+	)
+	if err = s.Open(); err == nil {
+		s.Close()
+		t.Fatal("Open() must fail without client certificate")
+	}
+}
+
+func TestOpenTLSMissingCA(t *testing.T) {
+	s := NewSignupService(WithTLS("", "", "/nonexistent/ca.pem", false))
+	if err := s.Open(); err == nil {
+		t.Error("Open() must fail when CA file is missing")
+	}
+}
+
+func TestSASL(t *testing.T) {
+	s := NewSignupService(WithSASL("plain", "account", "swordfish"))
+	c := s.producerConfig()
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if !c.Net.SASL.Enable || c.Net.SASL.Mechanism != sarama.SASLTypePlaintext || c.Net.SASL.User != "account" || c.Net.SASL.Password != "swordfish" {
+		t.Errorf("producerConfig() SASL = %+v, want PLAIN account:swordfish", c.Net.SASL)
+	}
+
+	s = NewSignupService(WithSASL("unknown", "account", "swordfish"))
+	if err := s.Open(); err == nil {
+		t.Error("Open() must fail with unknown SASL mechanism")
+	}
+}
+
+func TestSASLSCRAM(t *testing.T) {
+	for mechanism, hashFn := range map[string]scram.HashGeneratorFcn{
+		"SCRAM-SHA-256": sha256.New,
+		"SCRAM-SHA-512": sha512.New,
+	} {
+		s := NewSignupService(WithSASL(mechanism, "account", "swordfish"))
+		c := s.consumerConfig()
+		if err := c.Validate(); err != nil {
+			t.Fatalf("%s: %v", mechanism, err)
+		}
+		if !c.Version.IsAtLeast(sarama.V1_0_0_0) {
+			t.Errorf("%s: consumerConfig() version = %s, SCRAM requires Kafka 1.0", mechanism, c.Version)
+		}
+
+		// The client authenticates with a SCRAM server which knows the user's credentials.
+		client, err := hashFn.NewClient("account", "swordfish", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		creds := client.GetStoredCredentials(scram.KeyFactors{Salt: "pepper", Iters: 4096})
+		server, err := hashFn.NewServer(func(user string) (scram.StoredCredentials, error) {
+			return creds, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sconv := server.NewConversation()
+
+		cc := c.Net.SASL.SCRAMClientGeneratorFunc()
+		if err = cc.Begin(c.Net.SASL.User, c.Net.SASL.Password, ""); err != nil {
+			t.Fatal(err)
+		}
+		var challenge string
+		for !cc.Done() {
+			resp, err := cc.Step(challenge)
+			if err != nil {
+				t.Fatalf("%s: Step() = %v", mechanism, err)
+			}
+			if resp == "" && cc.Done() {
+				break
+			}
+			if challenge, err = sconv.Step(resp); err != nil {
+				t.Fatalf("%s: server Step() = %v", mechanism, err)
+			}
+		}
+		if !sconv.Valid() {
+			t.Errorf("%s: the client wasn't authenticated", mechanism)
+		}
+	}
+}
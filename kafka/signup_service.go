@@ -3,8 +3,11 @@ package kafka
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"sync"
+	"sync/atomic"
 
 	"github.com/Shopify/sarama"
 
@@ -14,20 +17,42 @@ import (
 // SignupService reprensets a service to register user accounts.
 // It processes signup requests from a Kafka topic and writes responses to another one.
 type SignupService struct {
+	// deadLetters is a number of dead-lettered messages.
+	// It is accessed atomically, so it must be 64-bit aligned.
+	deadLetters int64
+	// skippedResponses is a number of responses which couldn't be decoded, it is accessed atomically.
+	skippedResponses int64
+
 	config Config
 
 	consumer sarama.Consumer
 	producer sarama.SyncProducer
+	// group is a consumer group which reads signup requests in consumer-group mode.
+	group sarama.ConsumerGroup
+	// retries tracks the requests postponed to retry topics.
+	retries *retryTracker
+	// client counts the requests in retry topics when partitions are assigned,
+	// and it sends transactions, see WithTransactionalID.
+	// It is separate from the group's client, because Sarama doesn't let consumer groups share clients.
+	client sarama.Client
+	// txns are the transactional producers of the request topic's partitions.
+	txnsMu sync.Mutex
+	txns   map[int32]*txnProducer
+	// tlsConfig is loaded from the files set by WithTLS when the service is opened.
+	tlsConfig *tls.Config
 }
 
 // NewSignupService returns a SignupService which can be configured with config options.
-// By default logs are discarded.
+// By default messages are partitioned by Sarama's hash partitioner, Sarama's default config is used, logs are discarded.
 func NewSignupService(options ...ConfigOption) *SignupService {
 	s := SignupService{
 		config: Config{
 			requestTopic:  defaultRequestTopic,
 			requestOffset: defaultRequestOffset,
 			responseTopic: defaultResponseTopic,
+			onAssign:      func([]int32) error { return nil },
+			onRevoke:      func([]int32) {},
+			partitioner:   account.FNV1aPartitioner{},
 			logger:        &account.NoopLogger{},
 		},
 	}
@@ -35,29 +60,80 @@ func NewSignupService(options ...ConfigOption) *SignupService {
 	for _, opt := range options {
 		opt(&s.config)
 	}
+	s.retries = newRetryTracker(len(s.config.retryDelays))
+	s.txns = make(map[int32]*txnProducer)
 	return &s
 }
 
 // Open creates Kafka consumer and producer.
+// Sarama configs are validated before connecting, so a misconfiguration is reported right away.
 // Make sure you call Close to clean up resources.
 func (s *SignupService) Open() error {
+	if len(s.config.retryDelays) > 0 && s.config.group == "" {
+		return errors.New("kafka: retry topics require consumer-group mode")
+	}
+	if s.config.transactionalID != "" && s.config.group == "" {
+		return errors.New("kafka: transactions require consumer-group mode")
+	}
+	if s.config.tls != nil {
+		c, err := s.config.tls.load()
+		if err != nil {
+			return err
+		}
+		s.tlsConfig = c
+	}
+	if err := s.validateConfig(); err != nil {
+		return err
+	}
+
 	var err error
-	s.consumer, err = sarama.NewConsumer(s.config.brokers, nil)
+	s.consumer, err = sarama.NewConsumer(s.config.brokers, s.consumerConfig())
 	if err != nil {
 		s.config.logger.Log("level", "debug", "msg", "consumer not created", "err", err)
 		return err
 	}
 	s.config.logger.Log("level", "debug", "msg", "consumer created")
 
-	s.producer, err = sarama.NewSyncProducer(s.config.brokers, nil)
+	s.producer, err = sarama.NewSyncProducer(s.config.brokers, s.producerConfig())
 	if err != nil {
 		s.config.logger.Log("level", "debug", "msg", "producer not created", "err", err)
 		return err
 	}
 	s.config.logger.Log("level", "debug", "msg", "producer created")
+
+	if s.config.group == "" {
+		return nil
+	}
+	s.group, err = sarama.NewConsumerGroup(s.config.brokers, s.config.group, s.groupConfig())
+	if err != nil {
+		s.config.logger.Log("level", "debug", "msg", "consumer group not created", "group", s.config.group, "err", err)
+		return err
+	}
+	s.config.logger.Log("level", "debug", "msg", "consumer group created", "group", s.config.group)
+
+	if len(s.config.retryDelays) == 0 && s.config.transactionalID == "" {
+		return nil
+	}
+	s.client, err = sarama.NewClient(s.config.brokers, s.groupConfig())
+	if err != nil {
+		s.config.logger.Log("level", "debug", "msg", "client not created", "err", err)
+		return err
+	}
+	s.config.logger.Log("level", "debug", "msg", "client created")
 	return nil
 }
 
+// consumerConfig returns Sarama config of the consumer which reads requests from a single partition and responses.
+// The read_committed isolation level requires Kafka 0.11 or newer.
+func (s *SignupService) consumerConfig() *sarama.Config {
+	c := s.saramaConfig()
+	if s.config.readCommitted {
+		requireVersion(c, sarama.V0_11_0_0)
+		c.Consumer.IsolationLevel = sarama.ReadCommitted
+	}
+	return c
+}
+
 // Close shuts the producer and waits for any buffered messages to be flushed.
 // It also shuts down the consumer.
 func (s *SignupService) Close() {
@@ -66,6 +142,16 @@ func (s *SignupService) Close() {
 
 	s.producer.Close()
 	s.config.logger.Log("level", "debug", "msg", "producer closed")
+
+	if s.group != nil {
+		s.group.Close()
+		s.config.logger.Log("level", "debug", "msg", "consumer group closed")
+	}
+	if s.client != nil {
+		s.closeTxns()
+		s.client.Close()
+		s.config.logger.Log("level", "debug", "msg", "client closed")
+	}
 }
 
 // CreateRequest writes a signup request into Kafka topic.
@@ -78,10 +164,11 @@ func (s *SignupService) CreateRequest(ctx context.Context, req *account.SignupRe
 
 	m := sarama.ProducerMessage{
 		Topic: s.config.requestTopic,
-		// Sarama uses the message's key to consistently assign a partition to a message using hashing.
+		// The partitioner uses the message's key to consistently assign a partition to a message using hashing.
 		// Given that, all attempts to sign up as bob123 will emit events on the same partition.
 		// We shall send a sign up response to the same partition (for convenience of a client?).
-		Key:   sarama.StringEncoder(req.Username),
+		// The username is normalized, so Bob123 and bob123 are stored in the same partition as well.
+		Key:   sarama.StringEncoder(account.NormalizeUsername(req.Username)),
 		Value: sarama.ByteEncoder(b),
 	}
 	partition, offset, err := s.producer.SendMessage(&m)
@@ -95,11 +182,26 @@ func (s *SignupService) CreateRequest(ctx context.Context, req *account.SignupRe
 }
 
 // Requests reads signup requests from Kafka and passes them to f until
-// an error occurs (json unmarshal) or ctx is cancelled.
+// an error occurs or ctx is cancelled. A request which can't be decoded stops reading
+// unless it is forwarded to the dead-letter topic, see WithDeadLetterTopic.
 // Make sure ctx is always cancelled, or else underlying Kafka channel will not be drained.
-func (s *SignupService) Requests(ctx context.Context, f func(*account.SignupRequest)) error {
-	s.config.logger.Log("level", "debug", "msg", "requests reading started", "topic", s.config.requestTopic, "partition", s.config.requestPartition, "offset", s.config.requestOffset)
-	pConsumer, err := s.consumer.ConsumePartition(s.config.requestTopic, s.config.requestPartition, s.config.requestOffset)
+//
+// In consumer-group mode f is called concurrently for requests from different partitions,
+// see WithConsumerGroup. The context passed to f is done when the group is rebalanced,
+// then the request isn't marked as consumed, and it is read again by the partition's new owner.
+func (s *SignupService) Requests(ctx context.Context, f func(context.Context, *account.SignupRequest)) error {
+	if s.group != nil {
+		return s.groupRequests(ctx, f)
+	}
+
+	offset, err := s.requestOffset(ctx)
+	if err != nil {
+		s.config.logger.Log("level", "debug", "msg", "requests offset not found", "err", err)
+		return err
+	}
+
+	s.config.logger.Log("level", "debug", "msg", "requests reading started", "topic", s.config.requestTopic, "partition", s.config.requestPartition, "offset", offset)
+	pConsumer, err := s.consumer.ConsumePartition(s.config.requestTopic, s.config.requestPartition, offset)
 	if err != nil {
 		s.config.logger.Log("level", "debug", "msg", "requests consumer not created", "err", err)
 		return err
@@ -115,18 +217,55 @@ func (s *SignupService) Requests(ctx context.Context, f func(*account.SignupRequ
 		s.config.logger.Log("level", "debug", "msg", "request received", "body", m.Value)
 		r := account.SignupRequest{}
 		if err := json.Unmarshal(m.Value, &r); err != nil {
-			return err
+			if err = s.deadLetter(m, err); err != nil {
+				return err
+			}
+			// The dead-lettered request is not read again when the server resumes from the offset store.
+			if err = s.saveOffset(ctx, m.Partition, m.Offset); err != nil {
+				return err
+			}
+			continue
 		}
 		r.Partition = m.Partition
 		r.SequenceID = m.Offset
-		f(&r)
+		f(ctx, &r)
 	}
 
 	s.config.logger.Log("level", "debug", "msg", "requests reading stopped")
 	return nil
 }
 
+// requestOffset returns the offset to start reading signup requests from.
+// It is the one after the latest processed request if an offset store is configured,
+// and the request offset wasn't set explicitly.
+func (s *SignupService) requestOffset(ctx context.Context) (int64, error) {
+	if s.config.offsets == nil || s.config.requestOffsetSet {
+		return s.config.requestOffset, nil
+	}
+
+	offset, err := s.config.offsets.Offset(ctx, s.config.requestPartition)
+	switch err {
+	case nil:
+		return offset + 1, nil
+	case account.ErrOffsetNotFound:
+		return s.config.requestOffset, nil
+	}
+	return 0, err
+}
+
+// saveOffset records the offset of a request which got no response if the offset store can save offsets,
+// see account.OffsetSaver.
+func (s *SignupService) saveOffset(ctx context.Context, partition int32, offset int64) error {
+	saver, ok := s.config.offsets.(account.OffsetSaver)
+	if !ok {
+		return nil
+	}
+	return saver.SaveOffset(ctx, partition, offset)
+}
+
 // CreateResponse writes a response to a signup request into Kafka topic.
+// If transactions are enabled, ctx must be the one Requests passed to f along with the request,
+// then the response is committed together with the request's offset, see WithTransactionalID.
 func (s *SignupService) CreateResponse(ctx context.Context, resp *account.SignupResponse) error {
 	b, err := json.Marshal(resp)
 	if err != nil {
@@ -136,13 +275,18 @@ func (s *SignupService) CreateResponse(ctx context.Context, resp *account.Signup
 
 	m := sarama.ProducerMessage{
 		Topic: s.config.responseTopic,
-		// Sarama uses the message's key to consistently assign a partition to a message using hashing.
+		// The partitioner uses the message's key to consistently assign a partition to a message using hashing.
 		// Given that, all attempts to sign up as bob will emit events on the same partition.
 		// We shall send a signup response to the same partition.
-		Key:   sarama.StringEncoder(resp.Username),
+		Key:   sarama.StringEncoder(account.NormalizeUsername(resp.Username)),
 		Value: sarama.ByteEncoder(b),
 	}
-	partition, offset, err := s.producer.SendMessage(&m)
+	send := s.producer.SendMessage
+	// The response is written in the transaction of its request, see WithTransactionalID.
+	if t := txnFromContext(ctx); t != nil {
+		send = t.send
+	}
+	partition, offset, err := send(&m)
 	if err != nil {
 		s.config.logger.Log("level", "debug", "msg", "response not created", "body", b)
 		return err
@@ -153,7 +297,8 @@ func (s *SignupService) CreateResponse(ctx context.Context, resp *account.Signup
 }
 
 // Responses reads signup responses from Kafka and passes them to f until
-// an error occurs (json unmarshal) or ctx is cancelled.
+// an error occurs or ctx is cancelled. A response which can't be decoded is skipped, see SkippedResponses.
+// It isn't dead-lettered, because every reader of the responses would forward the same message.
 // Make sure ctx is always cancelled, or else underlying Kafka channel will not be drained.
 func (s *SignupService) Responses(ctx context.Context, f func(*account.SignupResponse)) error {
 	s.config.logger.Log("level", "debug", "msg", "responses looks for partitions", "topic", s.config.responseTopic)
@@ -187,7 +332,9 @@ func (s *SignupService) Responses(ctx context.Context, f func(*account.SignupRes
 		s.config.logger.Log("level", "debug", "msg", "response received", "partition", m.Partition, "offset", m.Offset, "body", m.Value)
 		r := account.SignupResponse{}
 		if err := json.Unmarshal(m.Value, &r); err != nil {
-			return err
+			n := atomic.AddInt64(&s.skippedResponses, 1)
+			s.config.logger.Log("level", "debug", "msg", "response skipped", "partition", m.Partition, "offset", m.Offset, "err", err, "skipped_responses", n)
+			continue
 		}
 		r.Partition = m.Partition
 		r.SequenceID = m.Offset
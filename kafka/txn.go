@@ -0,0 +1,461 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/marselester/distributed-signup"
+)
+
+// txnTimeout is how long the transaction coordinator waits before it aborts a transaction left open,
+// e.g., by a crashed signup-server.
+const txnTimeout = time.Minute
+
+// txnKey is the context key of the transactional producer a request is processed with.
+type txnKey struct{}
+
+// withTxn returns a copy of ctx which carries the transactional producer,
+// so CreateResponse writes the response in its transaction.
+func withTxn(ctx context.Context, t *txnProducer) context.Context {
+	return context.WithValue(ctx, txnKey{}, t)
+}
+
+// txnFromContext returns the transactional producer carried by ctx or nil.
+func txnFromContext(ctx context.Context) *txnProducer {
+	t, _ := ctx.Value(txnKey{}).(*txnProducer)
+	return t
+}
+
+// topicPartition identifies a partition of a topic.
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+// txnProducer writes messages and commits the consumer group's offsets in Kafka transactions
+// of one transactional ID, see WithTransactionalID. Sarama 1.23 producers don't support transactions,
+// so the txnProducer sends the protocol requests to the brokers of the client itself.
+//
+// A transaction begins with the first message sent, and it ends when an offset is committed or the transaction
+// is aborted. Any failure leaves the transaction to be aborted when the producer ID is initialized again.
+// A txnProducer is not safe for concurrent use, the requests of its partition are processed one at a time.
+type txnProducer struct {
+	id          string
+	group       string
+	client      sarama.Client
+	config      *sarama.Config
+	partitioner sarama.Partitioner
+	logger      account.Logger
+
+	// producerID is -1 until the producer ID is initialized.
+	producerID int64
+	epoch      int16
+	// sequences are the sequence numbers of the next messages in the partitions, they restart with every epoch.
+	sequences map[topicPartition]int32
+	// partitions were added to the ongoing transaction.
+	partitions map[topicPartition]bool
+	// coordinator is the transaction coordinator of the transactional ID.
+	coordinator *sarama.Broker
+}
+
+// txn returns the transactional producer of the request topic's partition.
+func (s *SignupService) txn(partition int32) *txnProducer {
+	s.txnsMu.Lock()
+	defer s.txnsMu.Unlock()
+
+	if t, ok := s.txns[partition]; ok {
+		return t
+	}
+	t := &txnProducer{
+		id:          fmt.Sprintf("%s-%d", s.config.transactionalID, partition),
+		group:       s.config.group,
+		client:      s.client,
+		config:      s.producerConfig(),
+		partitioner: &partitioner{p: s.config.partitioner},
+		logger:      s.config.logger,
+		producerID:  -1,
+	}
+	s.txns[partition] = t
+	return t
+}
+
+// closeTxns closes connections to the transaction coordinators.
+func (s *SignupService) closeTxns() {
+	s.txnsMu.Lock()
+	defer s.txnsMu.Unlock()
+
+	for _, t := range s.txns {
+		t.closeCoordinator()
+	}
+}
+
+// init gets a new epoch of the producer ID, so the transactions of the previous owner
+// of the transactional ID are fenced off, and its ongoing transaction is aborted.
+func (t *txnProducer) init() error {
+	t.producerID = -1
+	req := sarama.InitProducerIDRequest{
+		TransactionalID:    &t.id,
+		TransactionTimeout: txnTimeout,
+	}
+	err := t.retry(func() error {
+		coordinator, err := t.txnCoordinator()
+		if err != nil {
+			return err
+		}
+		resp, err := coordinator.InitProducerID(&req)
+		if err != nil {
+			return err
+		}
+		if resp.Err != sarama.ErrNoError {
+			return resp.Err
+		}
+		t.producerID = resp.ProducerID
+		t.epoch = resp.ProducerEpoch
+		return nil
+	})
+	if err != nil {
+		t.logger.Log("level", "debug", "msg", "producer id not initialized", "transactional_id", t.id, "err", err)
+		return err
+	}
+
+	t.sequences = make(map[topicPartition]int32)
+	t.partitions = make(map[topicPartition]bool)
+	t.logger.Log("level", "debug", "msg", "producer id initialized", "transactional_id", t.id, "producer_id", t.producerID, "epoch", t.epoch)
+	return nil
+}
+
+// send writes the message in the ongoing transaction or begins a new one.
+// The message's partition is assigned by the partitioner like SyncProducer does.
+func (t *txnProducer) send(m *sarama.ProducerMessage) (partition int32, offset int64, err error) {
+	if t.producerID < 0 {
+		if err = t.init(); err != nil {
+			return -1, -1, err
+		}
+	}
+	defer func() {
+		if err != nil {
+			t.fail()
+		}
+	}()
+
+	partitions, err := t.client.Partitions(m.Topic)
+	if err != nil {
+		return -1, -1, err
+	}
+	if partition, err = t.partitioner.Partition(m, int32(len(partitions))); err != nil {
+		return -1, -1, err
+	}
+	tp := topicPartition{topic: m.Topic, partition: partition}
+	if err = t.addPartition(tp); err != nil {
+		return -1, -1, err
+	}
+
+	record, err := newRecord(m)
+	if err != nil {
+		return -1, -1, err
+	}
+	req := sarama.ProduceRequest{
+		TransactionalID: &t.id,
+		RequiredAcks:    t.config.Producer.RequiredAcks,
+		Timeout:         int32(t.config.Producer.Timeout / time.Millisecond),
+		Version:         3,
+	}
+	req.AddBatch(tp.topic, tp.partition, &sarama.RecordBatch{
+		Version:          2,
+		Codec:            t.config.Producer.Compression,
+		CompressionLevel: t.config.Producer.CompressionLevel,
+		FirstTimestamp:   time.Now(),
+		ProducerID:       t.producerID,
+		ProducerEpoch:    t.epoch,
+		FirstSequence:    t.sequences[tp],
+		IsTransactional:  true,
+		Records:          []*sarama.Record{record},
+	})
+	err = t.retry(func() error {
+		leader, err := t.client.Leader(tp.topic, tp.partition)
+		if err != nil {
+			return err
+		}
+		resp, err := leader.Produce(&req)
+		if err != nil {
+			return err
+		}
+		b := resp.GetBlock(tp.topic, tp.partition)
+		if b == nil {
+			return sarama.ErrIncompleteResponse
+		}
+		// The message was written by the previous attempt whose response was lost.
+		if b.Err == sarama.ErrDuplicateSequenceNumber {
+			offset = -1
+			return nil
+		}
+		if b.Err != sarama.ErrNoError {
+			return b.Err
+		}
+		offset = b.Offset
+		return nil
+	})
+	if err != nil {
+		return -1, -1, err
+	}
+	t.sequences[tp]++
+	return partition, offset, nil
+}
+
+// newRecord returns a record of the message's key, value, and headers.
+func newRecord(m *sarama.ProducerMessage) (*sarama.Record, error) {
+	r := sarama.Record{}
+	var err error
+	if m.Key != nil {
+		if r.Key, err = m.Key.Encode(); err != nil {
+			return nil, err
+		}
+	}
+	if m.Value != nil {
+		if r.Value, err = m.Value.Encode(); err != nil {
+			return nil, err
+		}
+	}
+	for i := range m.Headers {
+		r.Headers = append(r.Headers, &m.Headers[i])
+	}
+	return &r, nil
+}
+
+// addPartition adds the partition to the ongoing transaction unless it was added already.
+func (t *txnProducer) addPartition(tp topicPartition) error {
+	if t.partitions[tp] {
+		return nil
+	}
+	req := sarama.AddPartitionsToTxnRequest{
+		TransactionalID: t.id,
+		ProducerID:      t.producerID,
+		ProducerEpoch:   t.epoch,
+		TopicPartitions: map[string][]int32{tp.topic: {tp.partition}},
+	}
+	err := t.retry(func() error {
+		coordinator, err := t.txnCoordinator()
+		if err != nil {
+			return err
+		}
+		resp, err := coordinator.AddPartitionsToTxn(&req)
+		if err != nil {
+			return err
+		}
+		for _, pe := range resp.Errors[tp.topic] {
+			if pe.Partition == tp.partition && pe.Err != sarama.ErrNoError {
+				return pe.Err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	t.partitions[tp] = true
+	return nil
+}
+
+// commit commits the group's offset of the partition in the ongoing transaction and ends the transaction,
+// so the messages sent in the transaction become visible to read_committed consumers along with the offset.
+// The offset is the one of the next message to read.
+func (t *txnProducer) commit(topic string, partition int32, offset int64) (err error) {
+	if t.producerID < 0 {
+		if err = t.init(); err != nil {
+			return err
+		}
+	}
+	defer func() {
+		if err != nil {
+			t.fail()
+		}
+	}()
+
+	addReq := sarama.AddOffsetsToTxnRequest{
+		TransactionalID: t.id,
+		ProducerID:      t.producerID,
+		ProducerEpoch:   t.epoch,
+		GroupID:         t.group,
+	}
+	err = t.retry(func() error {
+		coordinator, err := t.txnCoordinator()
+		if err != nil {
+			return err
+		}
+		resp, err := coordinator.AddOffsetsToTxn(&addReq)
+		if err != nil {
+			return err
+		}
+		if resp.Err != sarama.ErrNoError {
+			return resp.Err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	commitReq := sarama.TxnOffsetCommitRequest{
+		TransactionalID: t.id,
+		GroupID:         t.group,
+		ProducerID:      t.producerID,
+		ProducerEpoch:   t.epoch,
+		Topics: map[string][]*sarama.PartitionOffsetMetadata{
+			topic: {{Partition: partition, Offset: offset}},
+		},
+	}
+	err = t.retry(func() error {
+		coordinator, err := t.client.Coordinator(t.group)
+		if err != nil {
+			return err
+		}
+		resp, err := coordinator.TxnOffsetCommit(&commitReq)
+		if err != nil {
+			return err
+		}
+		for _, pe := range resp.Topics[topic] {
+			if pe.Partition == partition && pe.Err != sarama.ErrNoError {
+				return pe.Err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = t.end(true); err != nil {
+		return err
+	}
+	t.logger.Log("level", "debug", "msg", "transaction committed", "transactional_id", t.id, "topic", topic, "partition", partition, "offset", offset)
+	return nil
+}
+
+// abort aborts the ongoing transaction, so its messages are never visible to read_committed consumers.
+// It does nothing if no messages were sent.
+func (t *txnProducer) abort() error {
+	if t.producerID < 0 || len(t.partitions) == 0 {
+		return nil
+	}
+	if err := t.end(false); err != nil {
+		t.fail()
+		return err
+	}
+	t.logger.Log("level", "debug", "msg", "transaction aborted", "transactional_id", t.id)
+	return nil
+}
+
+// end commits or aborts the ongoing transaction.
+func (t *txnProducer) end(commit bool) error {
+	req := sarama.EndTxnRequest{
+		TransactionalID:   t.id,
+		ProducerID:        t.producerID,
+		ProducerEpoch:     t.epoch,
+		TransactionResult: commit,
+	}
+	err := t.retry(func() error {
+		coordinator, err := t.txnCoordinator()
+		if err != nil {
+			return err
+		}
+		resp, err := coordinator.EndTxn(&req)
+		if err != nil {
+			return err
+		}
+		if resp.Err != sarama.ErrNoError {
+			return resp.Err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	t.partitions = make(map[topicPartition]bool)
+	return nil
+}
+
+// fail makes the producer initialize its ID before the next transaction,
+// because the state of the ongoing one is unknown. The coordinator aborts it then.
+func (t *txnProducer) fail() {
+	t.producerID = -1
+}
+
+// txnCoordinator returns the transaction coordinator of the transactional ID.
+// It is looked up through the group coordinator, because any broker can find it.
+func (t *txnProducer) txnCoordinator() (*sarama.Broker, error) {
+	if t.coordinator != nil {
+		return t.coordinator, nil
+	}
+
+	b, err := t.client.Coordinator(t.group)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := b.FindCoordinator(&sarama.FindCoordinatorRequest{
+		Version:         1,
+		CoordinatorKey:  t.id,
+		CoordinatorType: sarama.CoordinatorTransaction,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Err != sarama.ErrNoError {
+		return nil, resp.Err
+	}
+	if err = resp.Coordinator.Open(t.client.Config()); err != nil && err != sarama.ErrAlreadyConnected {
+		return nil, err
+	}
+	t.coordinator = resp.Coordinator
+	return t.coordinator, nil
+}
+
+// closeCoordinator closes the connection to the transaction coordinator, so it is looked up again when needed.
+func (t *txnProducer) closeCoordinator() {
+	if t.coordinator == nil {
+		return
+	}
+	t.coordinator.Close()
+	t.coordinator = nil
+}
+
+// retry calls f until it succeeds, fails with an error which is not retriable, or the producer's retries run out.
+// The metadata and coordinators are refreshed before every retry, because partition leaders
+// and coordinators might have moved to other brokers.
+func (t *txnProducer) retry(f func() error) error {
+	err := f()
+	for attempt := 0; err != nil && retriable(err) && attempt < t.config.Producer.Retry.Max; attempt++ {
+		t.logger.Log("level", "debug", "msg", "transaction request retried", "transactional_id", t.id, "attempt", attempt+1, "err", err)
+		time.Sleep(t.config.Producer.Retry.Backoff)
+
+		t.closeCoordinator()
+		if rerr := t.client.RefreshCoordinator(t.group); rerr != nil {
+			t.logger.Log("level", "debug", "msg", "group coordinator not refreshed", "group", t.group, "err", rerr)
+		}
+		if rerr := t.client.RefreshMetadata(); rerr != nil {
+			t.logger.Log("level", "debug", "msg", "metadata not refreshed", "err", rerr)
+		}
+		err = f()
+	}
+	return err
+}
+
+// retriable reports whether the request failed because of the brokers' state which is likely to change soon,
+// e.g., a leader election or a transaction being completed.
+func retriable(err error) bool {
+	switch err {
+	case sarama.ErrNotLeaderForPartition,
+		sarama.ErrLeaderNotAvailable,
+		sarama.ErrRequestTimedOut,
+		sarama.ErrNotEnoughReplicas,
+		sarama.ErrNotEnoughReplicasAfterAppend,
+		sarama.ErrOffsetsLoadInProgress,
+		sarama.ErrConsumerCoordinatorNotAvailable,
+		sarama.ErrNotCoordinatorForConsumer,
+		sarama.ErrConcurrentTransactions:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,188 @@
+package kafka
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/marselester/distributed-signup"
+)
+
+// newTxnBroker returns a mock broker which coordinates the signup-server group and its transactions.
+func newTxnBroker(t *testing.T) *sarama.MockBroker {
+	b := sarama.NewMockBroker(t, 1)
+	b.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(b.Addr(), b.BrokerID()).
+			SetLeader(defaultResponseTopic, 0, b.BrokerID()),
+		// The group coordinator is looked up first. Sarama's mock answers only the protocol v0,
+		// so the transaction coordinator's response is encoded by hand.
+		"FindCoordinatorRequest": sarama.NewMockSequence(
+			sarama.NewMockFindCoordinatorResponse(t).SetCoordinator(sarama.CoordinatorGroup, "signup-server", b),
+			&sarama.FindCoordinatorResponse{Version: 1, Coordinator: sarama.NewBroker(b.Addr())},
+		),
+		"InitProducerIDRequest":     sarama.NewMockWrapper(&sarama.InitProducerIDResponse{ProducerID: 7, ProducerEpoch: 1}),
+		"AddPartitionsToTxnRequest": sarama.NewMockWrapper(&sarama.AddPartitionsToTxnResponse{}),
+		"ProduceRequest":            sarama.NewMockProduceResponse(t).SetVersion(3),
+		"AddOffsetsToTxnRequest":    sarama.NewMockWrapper(&sarama.AddOffsetsToTxnResponse{}),
+		"TxnOffsetCommitRequest":    sarama.NewMockWrapper(&sarama.TxnOffsetCommitResponse{}),
+		"EndTxnRequest":             sarama.NewMockWrapper(&sarama.EndTxnResponse{}),
+	})
+	return b
+}
+
+// newTxnService returns a SignupService whose transactions are sent to the broker.
+func newTxnService(t *testing.T, b *sarama.MockBroker) *SignupService {
+	s := NewSignupService(
+		WithBrokers(b.Addr()),
+		WithConsumerGroup("signup-server"),
+		WithTransactionalID("signup-server"),
+	)
+	var err error
+	if s.client, err = sarama.NewClient([]string{b.Addr()}, s.groupConfig()); err != nil {
+		t.Fatal(err)
+	}
+	s.producer = &fakeProducer{}
+	return s
+}
+
+// txnRequests returns the names of the transaction requests the broker received.
+func txnRequests(b *sarama.MockBroker) []string {
+	var names []string
+	for _, rr := range b.History() {
+		switch rr.Request.(type) {
+		case *sarama.InitProducerIDRequest:
+			names = append(names, "InitProducerID")
+		case *sarama.AddPartitionsToTxnRequest:
+			names = append(names, "AddPartitionsToTxn")
+		case *sarama.ProduceRequest:
+			names = append(names, "Produce")
+		case *sarama.AddOffsetsToTxnRequest:
+			names = append(names, "AddOffsetsToTxn")
+		case *sarama.TxnOffsetCommitRequest:
+			names = append(names, "TxnOffsetCommit")
+		case *sarama.EndTxnRequest:
+			names = append(names, "EndTxn")
+		}
+	}
+	return names
+}
+
+func TestCreateResponseTxn(t *testing.T) {
+	b := newTxnBroker(t)
+	defer b.Close()
+	s := newTxnService(t, b)
+	defer s.client.Close()
+	defer s.closeTxns()
+
+	txn := s.txn(2)
+	if err := txn.init(); err != nil {
+		t.Fatalf("init() = %v", err)
+	}
+	ctx := withTxn(context.Background(), txn)
+	resp := account.SignupResponse{RequestID: "13rUw7cUfrGO9Go9xbZearzuuAu", Username: "Bob", Success: true}
+	if err := s.CreateResponse(ctx, &resp); err != nil {
+		t.Fatalf("CreateResponse(%+v) = %v", resp, err)
+	}
+	if n := len(s.producer.(*fakeProducer).messages); n != 0 {
+		t.Errorf("CreateResponse(%+v) sent %d messages outside of the transaction", resp, n)
+	}
+	if err := txn.commit(defaultRequestTopic, 2, 8); err != nil {
+		t.Fatalf("commit() = %v", err)
+	}
+
+	want := []string{"InitProducerID", "AddPartitionsToTxn", "Produce", "AddOffsetsToTxn", "TxnOffsetCommit", "EndTxn"}
+	if got := txnRequests(b); !reflect.DeepEqual(got, want) {
+		t.Fatalf("requests = %v, want %v", got, want)
+	}
+	for _, rr := range b.History() {
+		switch req := rr.Request.(type) {
+		case *sarama.InitProducerIDRequest:
+			if *req.TransactionalID != "signup-server-2" {
+				t.Errorf("InitProducerID transactional id = %q, want signup-server-2", *req.TransactionalID)
+			}
+		case *sarama.AddPartitionsToTxnRequest:
+			want := map[string][]int32{defaultResponseTopic: {0}}
+			if req.ProducerID != 7 || req.ProducerEpoch != 1 || !reflect.DeepEqual(req.TopicPartitions, want) {
+				t.Errorf("AddPartitionsToTxn = %+v, want producer 7 epoch 1 adding %v", req, want)
+			}
+		case *sarama.ProduceRequest:
+			if req.TransactionalID == nil || *req.TransactionalID != "signup-server-2" || req.RequiredAcks != sarama.WaitForAll {
+				t.Errorf("Produce = %+v, want transactional id signup-server-2 and acks=all", req)
+			}
+		case *sarama.AddOffsetsToTxnRequest:
+			if req.GroupID != "signup-server" {
+				t.Errorf("AddOffsetsToTxn group = %q, want signup-server", req.GroupID)
+			}
+		case *sarama.TxnOffsetCommitRequest:
+			offsets := req.Topics[defaultRequestTopic]
+			if req.GroupID != "signup-server" || len(offsets) != 1 || offsets[0].Partition != 2 || offsets[0].Offset != 8 {
+				t.Errorf("TxnOffsetCommit = %+v, want offset 8 of %s/2", req, defaultRequestTopic)
+			}
+		case *sarama.EndTxnRequest:
+			if !req.TransactionResult {
+				t.Error("EndTxn must commit the transaction")
+			}
+		}
+	}
+}
+
+func TestTxnAbort(t *testing.T) {
+	b := newTxnBroker(t)
+	defer b.Close()
+	s := newTxnService(t, b)
+	defer s.client.Close()
+	defer s.closeTxns()
+
+	txn := s.txn(0)
+	// Nothing was sent, so there is no transaction to abort.
+	if err := txn.abort(); err != nil {
+		t.Fatalf("abort() = %v", err)
+	}
+	m := sarama.ProducerMessage{Topic: defaultResponseTopic, Key: sarama.StringEncoder("bob"), Value: sarama.StringEncoder("{}")}
+	if _, _, err := txn.send(&m); err != nil {
+		t.Fatalf("send() = %v", err)
+	}
+	if err := txn.abort(); err != nil {
+		t.Fatalf("abort() = %v", err)
+	}
+
+	want := []string{"InitProducerID", "AddPartitionsToTxn", "Produce", "EndTxn"}
+	if got := txnRequests(b); !reflect.DeepEqual(got, want) {
+		t.Fatalf("requests = %v, want %v", got, want)
+	}
+	for _, rr := range b.History() {
+		if req, ok := rr.Request.(*sarama.EndTxnRequest); ok && req.TransactionResult {
+			t.Error("EndTxn must abort the transaction")
+		}
+	}
+
+	// The partition is added again to the next transaction.
+	if _, _, err := txn.send(&m); err != nil {
+		t.Fatalf("send() = %v", err)
+	}
+	want = append(want, "AddPartitionsToTxn", "Produce")
+	if got := txnRequests(b); !reflect.DeepEqual(got, want) {
+		t.Errorf("requests = %v, want %v", got, want)
+	}
+}
+
+func TestTransactionalID(t *testing.T) {
+	s := NewSignupService(WithTransactionalID("signup-server"))
+	err := s.Open()
+	want := "kafka: transactions require consumer-group mode"
+	if err == nil || err.Error() != want {
+		t.Errorf("Open() = %v, want %s", err, want)
+	}
+
+	s = NewSignupService(WithTransactionalID("signup-server"), WithConsumerGroup("signup-server"))
+	c := s.producerConfig()
+	if !c.Producer.Idempotent || c.Producer.RequiredAcks != sarama.WaitForAll || !c.Version.IsAtLeast(sarama.V0_11_0_0) {
+		t.Errorf("producerConfig() = %+v, want idempotent producer waiting for all replicas", c.Producer)
+	}
+	if c = s.groupConfig(); !c.Version.IsAtLeast(sarama.V0_11_0_0) {
+		t.Errorf("groupConfig() version = %s, want 0.11 or newer", c.Version)
+	}
+}
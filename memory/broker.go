@@ -0,0 +1,157 @@
+// Package memory implements account services in process memory.
+// It is handy to run the signup flow in tests and locally without Kafka and PostgreSQL.
+package memory
+
+import (
+	"context"
+	"sync"
+
+	"github.com/marselester/distributed-signup"
+)
+
+const (
+	// OffsetNewest stands for the offset of the message that will be appended to a partition next.
+	// It has the same value as sarama.OffsetNewest.
+	OffsetNewest int64 = -1
+	// OffsetOldest stands for the oldest offset available on a partition.
+	// It has the same value as sarama.OffsetOldest.
+	OffsetOldest int64 = -2
+
+	// Default number of partitions of every topic, see docker-compose.yml.
+	defaultPartitions = 3
+)
+
+const (
+	// ErrUnknownPartition error indicates that a partition doesn't exist in a topic.
+	ErrUnknownPartition = account.Error("unknown partition")
+	// ErrOffsetOutOfRange error indicates that a requested offset is outside of a partition's range.
+	ErrOffsetOutOfRange = account.Error("offset out of range")
+)
+
+// Broker is an in-memory message broker which stores topics as partitioned append-only logs,
+// where a message is addressed by its offset like in Kafka.
+// The same Broker can be shared by many SignupServices, e.g., signup servers and a client.
+// It is safe for concurrent use by multiple goroutines.
+type Broker struct {
+	partitions int32
+
+	mu     sync.Mutex
+	topics map[string][][][]byte
+	// appended is closed (and replaced) every time a message is appended to notify waiting consumers.
+	appended chan struct{}
+}
+
+// NewBroker returns a Broker where every topic has the given number of partitions.
+func NewBroker(partitions int32) *Broker {
+	return &Broker{
+		partitions: partitions,
+		topics:     make(map[string][][][]byte),
+		appended:   make(chan struct{}),
+	}
+}
+
+// Partitions returns a number of partitions of every topic.
+func (b *Broker) Partitions() int32 {
+	return b.partitions
+}
+
+// append writes a message into a partition of a topic chosen by the partitioner p.
+// It returns the partition and the offset of the message.
+func (b *Broker) append(topic string, p account.Partitioner, key string, value []byte) (int32, int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	t := b.topic(topic)
+	i := p.Partition(key, b.partitions)
+	t[i] = append(t[i], value)
+
+	close(b.appended)
+	b.appended = make(chan struct{})
+
+	return i, int64(len(t[i]) - 1)
+}
+
+// topic returns partitions of a topic creating them if necessary.
+// The caller must hold the lock.
+func (b *Broker) topic(name string) [][][]byte {
+	t, ok := b.topics[name]
+	if !ok {
+		t = make([][][]byte, b.partitions)
+		b.topics[name] = t
+	}
+	return t
+}
+
+// offset resolves OffsetNewest and OffsetOldest into an actual offset of the partition.
+func (b *Broker) offset(topic string, partition int32, offset int64) (int64, error) {
+	if partition < 0 || partition >= b.partitions {
+		return 0, ErrUnknownPartition
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	size := int64(len(b.topic(topic)[partition]))
+	switch {
+	case offset == OffsetNewest:
+		return size, nil
+	case offset == OffsetOldest:
+		return 0, nil
+	case offset < 0 || offset > size:
+		return 0, ErrOffsetOutOfRange
+	}
+	return offset, nil
+}
+
+// fetch returns messages of the partition starting from the offset.
+// If there are no such messages yet, it blocks until they are appended or ctx is cancelled.
+func (b *Broker) fetch(ctx context.Context, topic string, partition int32, offset int64) ([][]byte, error) {
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		b.mu.Lock()
+		log := b.topic(topic)[partition]
+		appended := b.appended
+		b.mu.Unlock()
+
+		if offset < int64(len(log)) {
+			return log[offset:], nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-appended:
+		}
+	}
+}
+
+// fetchAny returns messages of any partition starting from the corresponding offsets.
+// If there are no such messages yet, it blocks until they are appended or ctx is cancelled.
+func (b *Broker) fetchAny(ctx context.Context, topic string, offsets []int64) (int32, [][]byte, error) {
+	for {
+		if err := ctx.Err(); err != nil {
+			return 0, nil, err
+		}
+
+		b.mu.Lock()
+		t := b.topic(topic)
+		appended := b.appended
+		for p, offset := range offsets {
+			if offset < int64(len(t[p])) {
+				messages := t[p][offset:]
+				b.mu.Unlock()
+				return int32(p), messages, nil
+			}
+		}
+		b.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return 0, nil, ctx.Err()
+		case <-appended:
+		}
+	}
+}
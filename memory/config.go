@@ -0,0 +1,107 @@
+package memory
+
+import (
+	"github.com/marselester/distributed-signup"
+)
+
+const (
+	// Default topic where signup requests are sent.
+	// You can change it using WithRequestTopic.
+	defaultRequestTopic = "account.signup_request"
+	// Use the newest signup requests by default.
+	defaultRequestOffset = OffsetNewest
+	// Default topic where signup responses are sent.
+	// You can change it using WithResponseTopic.
+	defaultResponseTopic = "account.signup_response"
+	// Use the newest signup responses by default.
+	defaultResponseOffset = OffsetNewest
+)
+
+// Config configures a SignupService. Config is set by the ConfigOption
+// values passed to NewSignupService.
+type Config struct {
+	broker           *Broker
+	requestTopic     string
+	requestPartition int32
+	requestOffset    int64
+	// requestOffsetSet indicates that the request offset was set explicitly,
+	// so it takes precedence over the offset store.
+	requestOffsetSet bool
+	offsets          account.OffsetStore
+	responseTopic    string
+	responseOffset   int64
+	partitioner      account.Partitioner
+
+	logger account.Logger
+}
+
+// ConfigOption configures how we set up the SignupService.
+type ConfigOption func(*Config)
+
+// WithBroker sets a broker where topics are stored.
+// Services which share a broker see each other's requests and responses.
+func WithBroker(b *Broker) ConfigOption {
+	return func(c *Config) {
+		c.broker = b
+	}
+}
+
+// WithRequestTopic sets a topic name where signup requests are written.
+func WithRequestTopic(topic string) ConfigOption {
+	return func(c *Config) {
+		c.requestTopic = topic
+	}
+}
+
+// WithRequestPartition sets a partition number of a topic.
+func WithRequestPartition(partition int32) ConfigOption {
+	return func(c *Config) {
+		c.requestPartition = partition
+	}
+}
+
+// WithRequestOffset sets offset index of a partition (-1 to start from the newest, -2 from the oldest).
+func WithRequestOffset(offset int64) ConfigOption {
+	return func(c *Config) {
+		c.requestOffset = offset
+		c.requestOffsetSet = true
+	}
+}
+
+// WithOffsetStore sets a store of processed request offsets. Unless the request offset is set explicitly,
+// the requests are read starting after the latest processed offset of the partition.
+// If the partition has no processed requests yet, the default request offset is used.
+func WithOffsetStore(s account.OffsetStore) ConfigOption {
+	return func(c *Config) {
+		c.offsets = s
+	}
+}
+
+// WithResponseTopic sets a topic name where signup responses are written.
+func WithResponseTopic(topic string) ConfigOption {
+	return func(c *Config) {
+		c.responseTopic = topic
+	}
+}
+
+// WithResponseOffset sets offset index of all response partitions (-1 to start from the newest, -2 from the oldest).
+// Reading from the oldest offset is useful in tests to make sure no response is missed.
+func WithResponseOffset(offset int64) ConfigOption {
+	return func(c *Config) {
+		c.responseOffset = offset
+	}
+}
+
+// WithPartitioner sets a partitioner which assigns partitions to messages keyed by username.
+func WithPartitioner(p account.Partitioner) ConfigOption {
+	return func(c *Config) {
+		c.partitioner = p
+	}
+}
+
+// WithLogger configures a logger to debug interactions with the broker.
+func WithLogger(l account.Logger) ConfigOption {
+	return func(c *Config) {
+		c.logger = l
+	}
+}
@@ -0,0 +1,140 @@
+package memory
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/marselester/distributed-signup"
+)
+
+// DedupStore keeps responses to signup requests in memory for a limited time.
+// The number of responses is also limited: when the store is full, the oldest response is evicted.
+// It is safe for concurrent use by multiple goroutines.
+type DedupStore struct {
+	size int
+	ttl  time.Duration
+	now  func() time.Time
+
+	mu sync.Mutex
+	// responses is a list of *dedupEntry ordered by time when they were saved, the oldest is at the front.
+	responses *list.List
+	// byRequestID indexes responses by signup request ID.
+	byRequestID map[string]*list.Element
+}
+
+// dedupEntry is a response which expires at a certain time.
+type dedupEntry struct {
+	requestID string
+	resp      account.SignupResponse
+	expires   time.Time
+}
+
+// NewDedupStore returns a DedupStore which keeps up to size responses for ttl duration.
+// Zero size or ttl means there is no limit.
+func NewDedupStore(size int, ttl time.Duration) *DedupStore {
+	return &DedupStore{
+		size:        size,
+		ttl:         ttl,
+		now:         time.Now,
+		responses:   list.New(),
+		byRequestID: make(map[string]*list.Element),
+	}
+}
+
+// Response returns a response recorded for the signup request ID or account.ErrResponseNotFound
+// if there is no such response or it has expired.
+func (s *DedupStore) Response(ctx context.Context, requestID string) (*account.SignupResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.evict()
+	el, ok := s.byRequestID[requestID]
+	if !ok {
+		return nil, account.ErrResponseNotFound
+	}
+	resp := el.Value.(*dedupEntry).resp
+	return &resp, nil
+}
+
+// SaveResponse records a response to the signup request.
+// It returns account.ErrDuplicateRequest if a response to the request ID has been already recorded.
+func (s *DedupStore) SaveResponse(ctx context.Context, req *account.SignupRequest, resp *account.SignupResponse) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.evict()
+	if _, ok := s.byRequestID[req.ID]; ok {
+		return account.ErrDuplicateRequest
+	}
+	e := dedupEntry{
+		requestID: req.ID,
+		resp:      *resp,
+		expires:   s.now().Add(s.ttl),
+	}
+	s.byRequestID[req.ID] = s.responses.PushBack(&e)
+
+	s.evict()
+	return nil
+}
+
+// Responses passes the responses which haven't expired and their requests to f in order they were saved
+// until f returns an error. It is used to move responses between shards, see shard.Migration.
+func (s *DedupStore) Responses(ctx context.Context, f func(req *account.SignupRequest, resp *account.SignupResponse) error) error {
+	s.mu.Lock()
+	s.evict()
+	responses := make([]account.SignupResponse, 0, s.responses.Len())
+	for el := s.responses.Front(); el != nil; el = el.Next() {
+		responses = append(responses, el.Value.(*dedupEntry).resp)
+	}
+	s.mu.Unlock()
+
+	for i := range responses {
+		req := account.SignupRequest{ID: responses[i].RequestID, Username: responses[i].Username}
+		if err := f(&req, &responses[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CopyResponse records a response moved from another store, see SaveResponse.
+func (s *DedupStore) CopyResponse(ctx context.Context, req *account.SignupRequest, resp *account.SignupResponse) error {
+	return s.SaveResponse(ctx, req, resp)
+}
+
+// DeleteResponse deletes the response to the signup request ID or returns account.ErrResponseNotFound.
+func (s *DedupStore) DeleteResponse(ctx context.Context, requestID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, ok := s.byRequestID[requestID]
+	if !ok {
+		return account.ErrResponseNotFound
+	}
+	s.responses.Remove(el)
+	delete(s.byRequestID, requestID)
+	return nil
+}
+
+// evict removes expired responses and the oldest ones if the store is over the size limit.
+// The caller must hold the lock.
+func (s *DedupStore) evict() {
+	now := s.now()
+	for {
+		el := s.responses.Front()
+		if el == nil {
+			return
+		}
+		e := el.Value.(*dedupEntry)
+		expired := s.ttl > 0 && !now.Before(e.expires)
+		full := s.size > 0 && s.responses.Len() > s.size
+		if !expired && !full {
+			return
+		}
+
+		s.responses.Remove(el)
+		delete(s.byRequestID, e.requestID)
+	}
+}
@@ -0,0 +1,91 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/marselester/distributed-signup"
+	"github.com/marselester/distributed-signup/accounttest"
+)
+
+// Ensure memory.DedupStore implements account.DedupStore.
+var _ account.DedupStore = &DedupStore{}
+
+func TestDedupStore(t *testing.T) {
+	s := NewDedupStore(0, 0)
+	ctx := context.Background()
+	req := account.SignupRequest{ID: "13rUw7cUfrGO9Go9xbZearzuuAu", Username: "bob"}
+	want := account.SignupResponse{RequestID: req.ID, Username: req.Username, Success: true}
+	if err := s.SaveResponse(ctx, &req, &want); err != nil {
+		t.Fatal(err)
+	}
+	// The first response must be kept.
+	other := account.SignupResponse{RequestID: req.ID, Username: req.Username, Success: false}
+	if err := s.SaveResponse(ctx, &req, &other); err != account.ErrDuplicateRequest {
+		t.Errorf("SaveResponse(%+v) = %v, want ErrDuplicateRequest", other, err)
+	}
+
+	got, err := s.Response(ctx, req.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != want {
+		t.Errorf("Response(%s) = %+v, want %+v", req.ID, got, want)
+	}
+
+	if _, err = s.Response(ctx, "13rVCgpmD0UgKH6zNHdfcPG63Df"); err != account.ErrResponseNotFound {
+		t.Errorf("Response(13rVCgpmD0UgKH6zNHdfcPG63Df) = %v, want ErrResponseNotFound", err)
+	}
+}
+
+func TestDedupStoreTTL(t *testing.T) {
+	s := NewDedupStore(0, time.Minute)
+	now := time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	for _, id := range []string{"13rUw7cUfrGO9Go9xbZearzuuAu", "13rVCgpmD0UgKH6zNHdfcPG63Df"} {
+		req := account.SignupRequest{ID: id, Username: "bob"}
+		resp := account.SignupResponse{RequestID: id, Username: "bob"}
+		if err := s.SaveResponse(ctx, &req, &resp); err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(30 * time.Second)
+	}
+
+	if _, err := s.Response(ctx, "13rUw7cUfrGO9Go9xbZearzuuAu"); err != account.ErrResponseNotFound {
+		t.Errorf("Response(13rUw7cUfrGO9Go9xbZearzuuAu) = %v, must expire", err)
+	}
+	if _, err := s.Response(ctx, "13rVCgpmD0UgKH6zNHdfcPG63Df"); err != nil {
+		t.Errorf("Response(13rVCgpmD0UgKH6zNHdfcPG63Df) = %v, must not expire", err)
+	}
+}
+
+func TestDedupStoreSize(t *testing.T) {
+	s := NewDedupStore(2, 0)
+	ctx := context.Background()
+	ids := []string{"13rUw7cUfrGO9Go9xbZearzuuAu", "13rVCgpmD0UgKH6zNHdfcPG63Df", "13rVFmwyaw2u5UXXNKIKMplycqb"}
+	for _, id := range ids {
+		req := account.SignupRequest{ID: id, Username: "bob"}
+		resp := account.SignupResponse{RequestID: id, Username: "bob"}
+		if err := s.SaveResponse(ctx, &req, &resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := s.Response(ctx, ids[0]); err != account.ErrResponseNotFound {
+		t.Errorf("Response(%s) = %v, must be evicted", ids[0], err)
+	}
+	for _, id := range ids[1:] {
+		if _, err := s.Response(ctx, id); err != nil {
+			t.Errorf("Response(%s) = %v, must not be evicted", id, err)
+		}
+	}
+}
+
+func TestDedupStoreConformance(t *testing.T) {
+	accounttest.TestDedupStore(t, func(t *testing.T) (account.DedupStore, func()) {
+		return NewDedupStore(0, 0), func() {}
+	})
+}
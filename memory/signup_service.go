@@ -0,0 +1,151 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/marselester/distributed-signup"
+)
+
+// SignupService represents a service to register user accounts.
+// Signup requests and responses are stored in a Broker's topics the same way kafka.SignupService stores them in Kafka.
+type SignupService struct {
+	config Config
+}
+
+// NewSignupService returns a SignupService which can be configured with config options.
+// By default a new broker with 3 partitions per topic is used, messages are partitioned
+// the same way Sarama does, logs are discarded.
+func NewSignupService(options ...ConfigOption) *SignupService {
+	s := SignupService{
+		config: Config{
+			requestTopic:   defaultRequestTopic,
+			requestOffset:  defaultRequestOffset,
+			responseTopic:  defaultResponseTopic,
+			responseOffset: defaultResponseOffset,
+			partitioner:    account.FNV1aPartitioner{},
+			logger:         &account.NoopLogger{},
+		},
+	}
+
+	for _, opt := range options {
+		opt(&s.config)
+	}
+	if s.config.broker == nil {
+		s.config.broker = NewBroker(defaultPartitions)
+	}
+	return &s
+}
+
+// CreateRequest appends a signup request to a partition assigned to the normalized username by the partitioner.
+func (s *SignupService) CreateRequest(ctx context.Context, req *account.SignupRequest) error {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	partition, offset := s.config.broker.append(s.config.requestTopic, s.config.partitioner, account.NormalizeUsername(req.Username), b)
+	s.config.logger.Log("level", "debug", "msg", "request created", "partition", partition, "offset", offset, "body", b)
+	return nil
+}
+
+// Requests reads signup requests from the configured partition and passes them to f until
+// an error occurs (json unmarshal) or ctx is cancelled.
+func (s *SignupService) Requests(ctx context.Context, f func(context.Context, *account.SignupRequest)) error {
+	offset, err := s.requestOffset(ctx)
+	if err != nil {
+		s.config.logger.Log("level", "debug", "msg", "requests offset not found", "err", err)
+		return err
+	}
+
+	s.config.logger.Log("level", "debug", "msg", "requests reading started", "topic", s.config.requestTopic, "partition", s.config.requestPartition, "offset", offset)
+	if offset, err = s.config.broker.offset(s.config.requestTopic, s.config.requestPartition, offset); err != nil {
+		s.config.logger.Log("level", "debug", "msg", "requests consumer not created", "err", err)
+		return err
+	}
+
+	for {
+		messages, err := s.config.broker.fetch(ctx, s.config.requestTopic, s.config.requestPartition, offset)
+		if err != nil {
+			s.config.logger.Log("level", "debug", "msg", "requests reading stopped", "err", err)
+			return nil
+		}
+
+		for _, m := range messages {
+			s.config.logger.Log("level", "debug", "msg", "request received", "body", m)
+			r := account.SignupRequest{}
+			if err := json.Unmarshal(m, &r); err != nil {
+				return err
+			}
+			r.Partition = s.config.requestPartition
+			r.SequenceID = offset
+			f(ctx, &r)
+			offset++
+		}
+	}
+}
+
+// requestOffset returns the offset to start reading signup requests from.
+// It is the one after the latest processed request if an offset store is configured,
+// and the request offset wasn't set explicitly.
+func (s *SignupService) requestOffset(ctx context.Context) (int64, error) {
+	if s.config.offsets == nil || s.config.requestOffsetSet {
+		return s.config.requestOffset, nil
+	}
+
+	offset, err := s.config.offsets.Offset(ctx, s.config.requestPartition)
+	switch err {
+	case nil:
+		return offset + 1, nil
+	case account.ErrOffsetNotFound:
+		return s.config.requestOffset, nil
+	}
+	return 0, err
+}
+
+// CreateResponse appends a response to a signup request to a partition assigned to the normalized username by the partitioner.
+func (s *SignupService) CreateResponse(ctx context.Context, resp *account.SignupResponse) error {
+	b, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+
+	partition, offset := s.config.broker.append(s.config.responseTopic, s.config.partitioner, account.NormalizeUsername(resp.Username), b)
+	s.config.logger.Log("level", "debug", "msg", "response created", "partition", partition, "offset", offset, "body", b)
+	return nil
+}
+
+// Responses reads signup responses from all partitions and passes them to f until
+// an error occurs (json unmarshal) or ctx is cancelled.
+func (s *SignupService) Responses(ctx context.Context, f func(*account.SignupResponse)) error {
+	offsets := make([]int64, s.config.broker.Partitions())
+	for i := range offsets {
+		s.config.logger.Log("level", "debug", "msg", "responses reading started", "topic", s.config.responseTopic, "partition", i, "offset", s.config.responseOffset)
+		offset, err := s.config.broker.offset(s.config.responseTopic, int32(i), s.config.responseOffset)
+		if err != nil {
+			s.config.logger.Log("level", "debug", "msg", "responses consumer not created", "topic", s.config.responseTopic, "partition", i, "err", err)
+			return err
+		}
+		offsets[i] = offset
+	}
+
+	for {
+		partition, messages, err := s.config.broker.fetchAny(ctx, s.config.responseTopic, offsets)
+		if err != nil {
+			s.config.logger.Log("level", "debug", "msg", "responses reading stopped", "err", err)
+			return nil
+		}
+
+		for _, m := range messages {
+			s.config.logger.Log("level", "debug", "msg", "response received", "partition", partition, "offset", offsets[partition], "body", m)
+			r := account.SignupResponse{}
+			if err := json.Unmarshal(m, &r); err != nil {
+				return err
+			}
+			r.Partition = partition
+			r.SequenceID = offsets[partition]
+			f(&r)
+			offsets[partition]++
+		}
+	}
+}
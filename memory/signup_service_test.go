@@ -0,0 +1,313 @@
+package memory_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/marselester/distributed-signup"
+	"github.com/marselester/distributed-signup/memory"
+)
+
+// Ensure memory.SignupService implements account.SignupService.
+var _ account.SignupService = &memory.SignupService{}
+
+// readRequests reads n signup requests using s.
+func readRequests(s *memory.SignupService, n int) ([]account.SignupRequest, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var got []account.SignupRequest
+	err := s.Requests(ctx, func(_ context.Context, req *account.SignupRequest) {
+		got = append(got, *req)
+		if len(got) == n {
+			cancel()
+		}
+	})
+	return got, err
+}
+
+// partition returns a partition number Kafka would assign to a username.
+func partition(t *testing.T, username string, partitions int32) int32 {
+	p := sarama.NewHashPartitioner("")
+	m := sarama.ProducerMessage{Key: sarama.StringEncoder(username)}
+	i, err := p.Partition(&m, partitions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return i
+}
+
+func TestCreateRequestPartition(t *testing.T) {
+	b := memory.NewBroker(3)
+	client := memory.NewSignupService(memory.WithBroker(b))
+
+	ctx := context.Background()
+	for _, username := range []string{"bob", "alice", "john", "lloyd", "aaron", "peter", "sam"} {
+		req := account.SignupRequest{ID: "13rUw7cUfrGO9Go9xbZearzuuAu", Username: username}
+		if err := client.CreateRequest(ctx, &req); err != nil {
+			t.Fatal(err)
+		}
+
+		want := partition(t, username, b.Partitions())
+		server := memory.NewSignupService(
+			memory.WithBroker(b),
+			memory.WithRequestPartition(want),
+			memory.WithRequestOffset(memory.OffsetOldest),
+		)
+		got, err := readRequests(server, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got[0].Partition != want {
+			t.Errorf("CreateRequest(%s) partition = %d, want %d", username, got[0].Partition, want)
+		}
+	}
+}
+
+func TestCreateRequestPartitioner(t *testing.T) {
+	b := memory.NewBroker(3)
+	client := memory.NewSignupService(
+		memory.WithBroker(b),
+		memory.WithPartitioner(account.Murmur2Partitioner{}),
+	)
+
+	ctx := context.Background()
+	for _, username := range []string{"bob", "alice", "john", "lloyd", "aaron", "peter", "sam"} {
+		req := account.SignupRequest{ID: "13rUw7cUfrGO9Go9xbZearzuuAu", Username: username}
+		if err := client.CreateRequest(ctx, &req); err != nil {
+			t.Fatal(err)
+		}
+
+		want := account.Murmur2Partitioner{}.Partition(username, b.Partitions())
+		server := memory.NewSignupService(
+			memory.WithBroker(b),
+			memory.WithRequestPartition(want),
+			memory.WithRequestOffset(memory.OffsetOldest),
+		)
+		got, err := readRequests(server, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got[0].Partition != want {
+			t.Errorf("CreateRequest(%s) partition = %d, want %d", username, got[0].Partition, want)
+		}
+	}
+}
+
+func TestCreateRequestNormalizedKey(t *testing.T) {
+	b := memory.NewBroker(3)
+	client := memory.NewSignupService(memory.WithBroker(b))
+	// All spellings of bob are stored in bob's partition which is 2.
+	server := memory.NewSignupService(
+		memory.WithBroker(b),
+		memory.WithRequestPartition(2),
+		memory.WithRequestOffset(memory.OffsetOldest),
+	)
+
+	ctx := context.Background()
+	usernames := []string{"bob", "Bob", "BOB ", "ｂｏｂ"}
+	for _, username := range usernames {
+		req := account.SignupRequest{ID: "13rUw7cUfrGO9Go9xbZearzuuAu", Username: username}
+		if err := client.CreateRequest(ctx, &req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := readRequests(server, len(usernames))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, username := range usernames {
+		if got[i].Username != username {
+			t.Errorf("CreateRequest(%q) stored %q in the partition 2", username, got[i].Username)
+		}
+	}
+}
+
+func TestRequestsOffset(t *testing.T) {
+	b := memory.NewBroker(3)
+	client := memory.NewSignupService(memory.WithBroker(b))
+
+	ctx := context.Background()
+	ids := []string{"13rUw7cUfrGO9Go9xbZearzuuAu", "13rVCgpmD0UgKH6zNHdfcPG63Df", "13rVFmwyaw2u5UXXNKIKMplycqb"}
+	for _, id := range ids {
+		req := account.SignupRequest{ID: id, Username: "bob"}
+		if err := client.CreateRequest(ctx, &req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := partition(t, "bob", b.Partitions())
+	tests := map[string]struct {
+		offset int64
+		want   []string
+	}{
+		"oldest":   {memory.OffsetOldest, ids},
+		"explicit": {1, ids[1:]},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			server := memory.NewSignupService(
+				memory.WithBroker(b),
+				memory.WithRequestPartition(p),
+				memory.WithRequestOffset(tc.offset),
+			)
+			got, err := readRequests(server, len(tc.want))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, req := range got {
+				if req.ID != tc.want[i] {
+					t.Errorf("Requests() = %s, want %s", req.ID, tc.want[i])
+				}
+				if wantSeq := int64(len(ids) - len(tc.want) + i); req.SequenceID != wantSeq {
+					t.Errorf("Requests() sequence ID = %d, want %d", req.SequenceID, wantSeq)
+				}
+			}
+		})
+	}
+}
+
+// offsetStore is an account.OffsetStore which keeps offsets in a map.
+type offsetStore map[int32]int64
+
+func (s offsetStore) Offset(ctx context.Context, partition int32) (int64, error) {
+	offset, ok := s[partition]
+	if !ok {
+		return 0, account.ErrOffsetNotFound
+	}
+	return offset, nil
+}
+
+func TestRequestsOffsetStore(t *testing.T) {
+	b := memory.NewBroker(3)
+	client := memory.NewSignupService(memory.WithBroker(b))
+
+	ctx := context.Background()
+	ids := []string{"13rUw7cUfrGO9Go9xbZearzuuAu", "13rVCgpmD0UgKH6zNHdfcPG63Df", "13rVFmwyaw2u5UXXNKIKMplycqb"}
+	for _, id := range ids {
+		req := account.SignupRequest{ID: id, Username: "bob"}
+		if err := client.CreateRequest(ctx, &req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := partition(t, "bob", b.Partitions())
+	tests := map[string]struct {
+		options []memory.ConfigOption
+		want    []string
+	}{
+		"stored": {
+			options: []memory.ConfigOption{memory.WithOffsetStore(offsetStore{p: 0})},
+			want:    ids[1:],
+		},
+		"explicit": {
+			options: []memory.ConfigOption{memory.WithOffsetStore(offsetStore{p: 0}), memory.WithRequestOffset(memory.OffsetOldest)},
+			want:    ids,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			options := append([]memory.ConfigOption{memory.WithBroker(b), memory.WithRequestPartition(p)}, tc.options...)
+			got, err := readRequests(memory.NewSignupService(options...), len(tc.want))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, req := range got {
+				if req.ID != tc.want[i] {
+					t.Errorf("Requests() = %s, want %s", req.ID, tc.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRequestsOffsetNewest(t *testing.T) {
+	b := memory.NewBroker(3)
+	client := memory.NewSignupService(memory.WithBroker(b))
+	p := partition(t, "bob", b.Partitions())
+	server := memory.NewSignupService(
+		memory.WithBroker(b),
+		memory.WithRequestPartition(p),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	old := account.SignupRequest{ID: "13rUw7cUfrGO9Go9xbZearzuuAu", Username: "bob"}
+	if err := client.CreateRequest(ctx, &old); err != nil {
+		t.Fatal(err)
+	}
+
+	// New requests are created until the server reads one of them,
+	// the old request must be skipped.
+	var got []account.SignupRequest
+	go func() {
+		req := account.SignupRequest{ID: "13rVCgpmD0UgKH6zNHdfcPG63Df", Username: "bob"}
+		for ctx.Err() == nil {
+			if err := client.CreateRequest(ctx, &req); err != nil {
+				t.Error(err)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	err := server.Requests(ctx, func(_ context.Context, req *account.SignupRequest) {
+		got = append(got, *req)
+		cancel()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[0].ID == old.ID {
+		t.Errorf("Requests() = %s, old request must be skipped", got[0].ID)
+	}
+}
+
+func TestRequestsOffsetOutOfRange(t *testing.T) {
+	s := memory.NewSignupService(memory.WithRequestOffset(10))
+	if _, err := readRequests(s, 1); err != memory.ErrOffsetOutOfRange {
+		t.Errorf("Requests() = %v, want ErrOffsetOutOfRange", err)
+	}
+}
+
+func TestRequestsUnknownPartition(t *testing.T) {
+	s := memory.NewSignupService(memory.WithRequestPartition(3))
+	if _, err := readRequests(s, 1); err != memory.ErrUnknownPartition {
+		t.Errorf("Requests() = %v, want ErrUnknownPartition", err)
+	}
+}
+
+func TestResponses(t *testing.T) {
+	s := memory.NewSignupService(memory.WithResponseOffset(memory.OffsetOldest))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	want := map[string]bool{"bob": true, "alice": true, "peter": false}
+	for username, success := range want {
+		resp := account.SignupResponse{RequestID: "13rUw7cUfrGO9Go9xbZearzuuAu", Username: username, Success: success}
+		if err := s.CreateResponse(ctx, &resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := make(map[string]bool)
+	err := s.Responses(ctx, func(resp *account.SignupResponse) {
+		got[resp.Username] = resp.Success
+		if p := partition(t, resp.Username, 3); resp.Partition != p {
+			t.Errorf("Responses() %s partition = %d, want %d", resp.Username, resp.Partition, p)
+		}
+		if len(got) == len(want) {
+			cancel()
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for username, success := range want {
+		if got[username] != success {
+			t.Errorf("Responses() %s success = %t, want %t", username, got[username], success)
+		}
+	}
+}
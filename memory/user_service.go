@@ -0,0 +1,109 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/marselester/distributed-signup"
+)
+
+// UserService represents a service to store signed up users in memory.
+// It enforces the same constraints as pg.UserSchema: user IDs and usernames are unique.
+// It is safe for concurrent use by multiple goroutines.
+type UserService struct {
+	mu sync.RWMutex
+	// byID maps user IDs to usernames.
+	byID map[string]string
+	// byUsername maps usernames to user IDs.
+	byUsername map[string]string
+}
+
+// NewUserService returns a UserService with no users.
+func NewUserService() *UserService {
+	return &UserService{
+		byID:       make(map[string]string),
+		byUsername: make(map[string]string),
+	}
+}
+
+// CreateUser stores a user or returns account.ErrDuplicateUserID or account.ErrUsernameTaken
+// when the user ID or username are already taken.
+func (s *UserService) CreateUser(ctx context.Context, u *account.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.byID[u.ID]; ok {
+		return account.ErrDuplicateUserID
+	}
+	if _, ok := s.byUsername[u.Username]; ok {
+		return account.ErrUsernameTaken
+	}
+	s.byID[u.ID] = u.Username
+	s.byUsername[u.Username] = u.ID
+	return nil
+}
+
+// ByUsername looks up a user by username or returns account.ErrUserNotFound when a user is not found.
+func (s *UserService) ByUsername(ctx context.Context, username string) (*account.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	u := account.User{Username: username}
+	id, ok := s.byUsername[username]
+	if !ok {
+		return &u, account.ErrUserNotFound
+	}
+	u.ID = id
+	return &u, nil
+}
+
+// ClaimUsername creates the user u unless its username is already claimed by the existing user.
+// It returns account.ErrDuplicateUserID when the user ID is already taken.
+func (s *UserService) ClaimUsername(ctx context.Context, u *account.User) (bool, *account.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id, ok := s.byUsername[u.Username]; ok {
+		return false, &account.User{ID: id, Username: u.Username}, nil
+	}
+	if _, ok := s.byID[u.ID]; ok {
+		return false, nil, account.ErrDuplicateUserID
+	}
+	s.byID[u.ID] = u.Username
+	s.byUsername[u.Username] = u.ID
+	return true, nil, nil
+}
+
+// Users passes all the users to f in order of their usernames until f returns an error.
+func (s *UserService) Users(ctx context.Context, f func(*account.User) error) error {
+	s.mu.RLock()
+	users := make([]account.User, 0, len(s.byUsername))
+	for username, id := range s.byUsername {
+		users = append(users, account.User{ID: id, Username: username})
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Username < users[j].Username
+	})
+	for i := range users {
+		if err := f(&users[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteUser deletes the user u or returns account.ErrUserNotFound when there is no such user.
+func (s *UserService) DeleteUser(ctx context.Context, u *account.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id, ok := s.byUsername[u.Username]; !ok || id != u.ID {
+		return account.ErrUserNotFound
+	}
+	delete(s.byUsername, u.Username)
+	delete(s.byID, u.ID)
+	return nil
+}
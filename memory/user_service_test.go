@@ -0,0 +1,18 @@
+package memory_test
+
+import (
+	"testing"
+
+	"github.com/marselester/distributed-signup"
+	"github.com/marselester/distributed-signup/accounttest"
+	"github.com/marselester/distributed-signup/memory"
+)
+
+// Ensure memory.UserService implements account.UserService.
+var _ account.UserService = &memory.UserService{}
+
+func TestUserService(t *testing.T) {
+	accounttest.TestUserService(t, func(t *testing.T) (account.UserService, func()) {
+		return memory.NewUserService(), func() {}
+	})
+}
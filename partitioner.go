@@ -0,0 +1,113 @@
+package account
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+)
+
+// Partitioner assigns a partition to a key, e.g., a username. All producers of signup requests
+// and all shard routers must use the same partitioner, otherwise the same username
+// can be claimed in different shards.
+type Partitioner interface {
+	// Partition returns a partition number of the key in range [0, partitions).
+	Partition(key string, partitions int32) int32
+}
+
+// PartitionerByName returns a partitioner by its name: fnv1a, murmur2, or jump.
+func PartitionerByName(name string) (Partitioner, error) {
+	switch name {
+	case "fnv1a":
+		return FNV1aPartitioner{}, nil
+	case "murmur2":
+		return Murmur2Partitioner{}, nil
+	case "jump":
+		return JumpPartitioner{}, nil
+	}
+	return nil, ErrUnknownPartitioner
+}
+
+// FNV1aPartitioner is the default partitioner of Sarama: FNV-1a 32-bit hash of the key modulo
+// the number of partitions. Note, the modulo is taken from a signed hash, and its absolute value is used.
+type FNV1aPartitioner struct{}
+
+// Partition returns a partition number of the key the same way sarama.NewHashPartitioner does.
+func (FNV1aPartitioner) Partition(key string, partitions int32) int32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	p := int32(h.Sum32()) % partitions
+	if p < 0 {
+		p = -p
+	}
+	return p
+}
+
+// Murmur2Partitioner is the default partitioner of the Java Kafka client:
+// murmur2 hash of the key with the sign bit cleared modulo the number of partitions.
+// Use it when signup requests are also produced by clients based on librdkafka (murmur2_random) or Java.
+type Murmur2Partitioner struct{}
+
+// Partition returns a partition number of the key the same way Java's DefaultPartitioner does.
+func (Murmur2Partitioner) Partition(key string, partitions int32) int32 {
+	return int32(murmur2([]byte(key))&0x7fffffff) % partitions
+}
+
+// murmur2 is a port of org.apache.kafka.common.utils.Utils.murmur2.
+func murmur2(data []byte) uint32 {
+	const (
+		seed uint32 = 0x9747b28c
+		m    uint32 = 0x5bd1e995
+		r           = 24
+	)
+	length := len(data)
+	h := seed ^ uint32(length)
+
+	for ; len(data) >= 4; data = data[4:] {
+		k := binary.LittleEndian.Uint32(data)
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+
+	switch len(data) {
+	case 3:
+		h ^= uint32(data[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(data[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(data[0])
+		h *= m
+	}
+
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return h
+}
+
+// JumpPartitioner is Jump Consistent Hash by John Lamping and Eric Veach https://arxiv.org/abs/1406.2294
+// applied to FNV-1a 64-bit hash of the key. When the number of partitions grows from n to n+1,
+// only 1/(n+1) of the keys move, and all of them move to the new partition,
+// whereas most of the keys change partitions with the modulo based partitioners.
+type JumpPartitioner struct{}
+
+// Partition returns a partition number of the key using Jump Consistent Hash.
+func (JumpPartitioner) Partition(key string, partitions int32) int32 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return jump(h.Sum64(), partitions)
+}
+
+// jump returns a bucket number of the key in range [0, buckets).
+func jump(key uint64, buckets int32) int32 {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int32(b)
+}
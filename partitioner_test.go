@@ -0,0 +1,89 @@
+package account
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestFNV1aPartitioner(t *testing.T) {
+	usernames := []string{"", "bob", "alice", "john", "lloyd", "aaron", "peter", "sam", "Bob", "bob ", "Ьоb", "用户", "😀"}
+	for i := 0; i < 1000; i++ {
+		usernames = append(usernames, fmt.Sprintf("user%d", i))
+	}
+
+	var p FNV1aPartitioner
+	kafka := sarama.NewHashPartitioner("")
+	for partitions := int32(1); partitions <= 16; partitions++ {
+		for _, username := range usernames {
+			m := sarama.ProducerMessage{Key: sarama.StringEncoder(username)}
+			want, err := kafka.Partition(&m, partitions)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := p.Partition(username, partitions); got != want {
+				t.Errorf("Partition(%q, %d) = %d, Kafka partition %d", username, partitions, got, want)
+			}
+		}
+	}
+}
+
+func TestMurmur2(t *testing.T) {
+	// The hashes are taken from Kafka's UtilsTest.testMurmur2.
+	tests := map[string]int32{
+		"21":                         -973932308,
+		"foobar":                     -790332482,
+		"a-little-bit-long-string":   -985981536,
+		"a-little-bit-longer-string": -1486304829,
+		"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8": -58897971,
+		"abc": 479470107,
+	}
+	for key, want := range tests {
+		if got := int32(murmur2([]byte(key))); got != want {
+			t.Errorf("murmur2(%q) = %d, want %d", key, got, want)
+		}
+	}
+}
+
+func TestPartitionerRange(t *testing.T) {
+	partitioners := map[string]Partitioner{
+		"fnv1a":   FNV1aPartitioner{},
+		"murmur2": Murmur2Partitioner{},
+		"jump":    JumpPartitioner{},
+	}
+	for name, p := range partitioners {
+		for partitions := int32(1); partitions <= 16; partitions++ {
+			for i := 0; i < 1000; i++ {
+				key := fmt.Sprintf("user%d", i)
+				if got := p.Partition(key, partitions); got < 0 || got >= partitions {
+					t.Fatalf("%s Partition(%s, %d) = %d, out of range", name, key, partitions, got)
+				}
+			}
+		}
+	}
+}
+
+func TestJumpPartitionerMoves(t *testing.T) {
+	var p JumpPartitioner
+	for partitions := int32(1); partitions < 16; partitions++ {
+		var moved int
+		for i := 0; i < 10000; i++ {
+			key := fmt.Sprintf("user%d", i)
+			before, after := p.Partition(key, partitions), p.Partition(key, partitions+1)
+			if before == after {
+				continue
+			}
+			if after != partitions {
+				t.Fatalf("Partition(%s) moved from %d to %d, want %d", key, before, after, partitions)
+			}
+			moved++
+		}
+
+		// About 1/(n+1) of keys move to the new partition.
+		want := 10000 / int(partitions+1)
+		if moved < want/2 || moved > want*2 {
+			t.Errorf("%d keys moved when partitions grew to %d, want about %d", moved, partitions+1, want)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package pg
+
+import (
+	"io"
+	"net"
+	"strings"
+
+	"github.com/jackc/pgx"
+
+	"github.com/marselester/distributed-signup"
+)
+
+const (
+	// uniqueViolation is SQLSTATE code of unique constraint violation error.
+	uniqueViolation = "23505"
+	// Names of constraints PostgreSQL generates for UserSchema.
+	userIDConstraint    = "account_pkey"
+	usernameConstraint  = "account_username_key"
+	requestIDConstraint = "signup_request_pkey"
+)
+
+// transientCodes are SQLSTATE codes and classes (first two characters of a code)
+// of errors which might not happen again if a query is retried,
+// see https://www.postgresql.org/docs/current/static/errcodes-appendix.html.
+var transientCodes = []string{
+	// Connection exception class.
+	"08",
+	// Insufficient resources class, e.g., too many connections.
+	"53",
+	// Serialization failure and deadlock detected.
+	"40001", "40P01",
+	// Operator intervention: admin shutdown, crash shutdown, cannot connect now.
+	"57P01", "57P02", "57P03",
+}
+
+// wrapError translates errors returned by pgx into account errors.
+// Unique violations of UserSchema constraints become account.ErrUsernameTaken, account.ErrDuplicateUserID,
+// or account.ErrDuplicateRequest,
+// and errors caused by temporary conditions such as connection failures are wrapped in account.TransientError.
+// Other errors are returned as is.
+func wrapError(err error) error {
+	switch e := err.(type) {
+	case nil:
+		return nil
+	case pgx.PgError:
+		if e.Code == uniqueViolation {
+			switch e.ConstraintName {
+			case usernameConstraint:
+				return account.ErrUsernameTaken
+			case userIDConstraint:
+				return account.ErrDuplicateUserID
+			case requestIDConstraint:
+				return account.ErrDuplicateRequest
+			}
+		}
+		if isTransientCode(e.Code) {
+			return &account.TransientError{Err: err}
+		}
+		return err
+	case net.Error:
+		return &account.TransientError{Err: err}
+	}
+
+	switch err {
+	case pgx.ErrDeadConn, pgx.ErrAcquireTimeout, io.EOF, io.ErrUnexpectedEOF:
+		return &account.TransientError{Err: err}
+	}
+	return err
+}
+
+// isTransientCode reports whether SQLSTATE code belongs to transient errors.
+func isTransientCode(code string) bool {
+	for _, c := range transientCodes {
+		if strings.HasPrefix(code, c) {
+			return true
+		}
+	}
+	return false
+}
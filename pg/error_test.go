@@ -0,0 +1,59 @@
+package pg
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/jackc/pgx"
+
+	"github.com/marselester/distributed-signup"
+)
+
+func TestWrapError(t *testing.T) {
+	tests := map[string]struct {
+		err       error
+		want      error
+		transient bool
+	}{
+		"nil": {nil, nil, false},
+		"duplicate username": {
+			err:  pgx.PgError{Code: "23505", ConstraintName: "account_username_key"},
+			want: account.ErrUsernameTaken,
+		},
+		"duplicate id": {
+			err:  pgx.PgError{Code: "23505", ConstraintName: "account_pkey"},
+			want: account.ErrDuplicateUserID,
+		},
+		"duplicate request id": {
+			err:  pgx.PgError{Code: "23505", ConstraintName: "signup_request_pkey"},
+			want: account.ErrDuplicateRequest,
+		},
+		"unknown constraint": {
+			err:  pgx.PgError{Code: "23505", ConstraintName: "account_email_key"},
+			want: pgx.PgError{Code: "23505", ConstraintName: "account_email_key"},
+		},
+		"syntax error": {
+			err:  pgx.PgError{Code: "42601"},
+			want: pgx.PgError{Code: "42601"},
+		},
+		"connection failure":   {pgx.PgError{Code: "08006"}, nil, true},
+		"too many connections": {pgx.PgError{Code: "53300"}, nil, true},
+		"deadlock":             {pgx.PgError{Code: "40P01"}, nil, true},
+		"admin shutdown":       {pgx.PgError{Code: "57P01"}, nil, true},
+		"dead conn":            {pgx.ErrDeadConn, nil, true},
+		"network":              {&net.OpError{Op: "dial", Err: errors.New("connection refused")}, nil, true},
+		"no rows":              {pgx.ErrNoRows, pgx.ErrNoRows, false},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := wrapError(tc.err)
+			if account.IsTransient(got) != tc.transient {
+				t.Errorf("wrapError(%v) transient = %t, want %t", tc.err, !tc.transient, tc.transient)
+			}
+			if !tc.transient && got != tc.want {
+				t.Errorf("wrapError(%v) = %v, want %v", tc.err, got, tc.want)
+			}
+		})
+	}
+}
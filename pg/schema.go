@@ -1,6 +1,13 @@
 package pg
 
+// MaxUsernameLength is the max number of characters in a username which account table can store.
+const MaxUsernameLength = 40
+
 // UserSchema is db schema which must be created before working with UserService.
+// Besides user accounts, it contains signup_request table where responses to processed signup requests
+// are recorded to deduplicate requests, and request_offset table where the latest processed offset
+// of each partition is recorded. The signup-servers using the database register themselves in signup_server table,
+// so reshard can check that they have paused.
 const UserSchema = `
 CREATE TABLE IF NOT EXISTS account (
     id varchar(27),
@@ -9,4 +16,35 @@ CREATE TABLE IF NOT EXISTS account (
     PRIMARY KEY(id),
     UNIQUE(username)
 );
+
+CREATE TABLE IF NOT EXISTS signup_request (
+    request_id text,
+    username text NOT NULL,
+    success boolean NOT NULL,
+    "partition" integer NOT NULL,
+    "offset" bigint NOT NULL,
+    processed_at timestamptz NOT NULL DEFAULT now(),
+
+    PRIMARY KEY(request_id)
+);
+CREATE INDEX IF NOT EXISTS signup_request_processed_at_idx ON signup_request (processed_at);
+-- Failure reasons were added later, so the columns are added to the existing tables as well.
+ALTER TABLE signup_request ADD COLUMN IF NOT EXISTS reason varchar(16) NOT NULL DEFAULT '';
+ALTER TABLE signup_request ADD COLUMN IF NOT EXISTS message text NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS request_offset (
+    "partition" integer,
+    "offset" bigint NOT NULL,
+
+    PRIMARY KEY("partition")
+);
+
+CREATE TABLE IF NOT EXISTS signup_server (
+    id varchar(64),
+    group_mode boolean NOT NULL,
+    paused_epoch integer NOT NULL,
+    seen_at timestamptz NOT NULL DEFAULT now(),
+
+    PRIMARY KEY(id)
+);
 `
@@ -3,6 +3,7 @@ package pg
 
 import (
 	"context"
+	"time"
 
 	"github.com/jackc/pgx"
 
@@ -59,6 +60,31 @@ func prepareSQL(conn *pgx.Conn) error {
 	q := map[string]string{
 		"create":     "INSERT INTO account (id, username) VALUES ($1, $2)",
 		"byUsername": "SELECT id FROM account WHERE username=$1",
+		"users":      "SELECT id, username FROM account ORDER BY username",
+		"delete":     "DELETE FROM account WHERE id=$1 AND username=$2",
+		// The user is inserted unless the username is taken, otherwise the owner's ID is returned.
+		// Note, the owner might not be visible in the statement's snapshot when it was inserted concurrently.
+		"claim": `WITH claimed AS (
+			INSERT INTO account (id, username) VALUES ($1, $2)
+			ON CONFLICT (username) DO NOTHING
+			RETURNING id
+		)
+		SELECT id, true FROM claimed
+		UNION ALL
+		SELECT id, false FROM account WHERE username=$2
+		LIMIT 1`,
+		"response":       "SELECT username, success, reason, message FROM signup_request WHERE request_id=$1",
+		"saveResponse":   `INSERT INTO signup_request (request_id, username, success, reason, message, "partition", "offset") VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		"responses":      `SELECT request_id, username, success, reason, message, "partition", "offset" FROM signup_request ORDER BY request_id`,
+		"deleteResponse": "DELETE FROM signup_request WHERE request_id=$1",
+		// The offset doesn't go back when an earlier request is recorded, e.g., it was processed concurrently.
+		"saveOffset": `INSERT INTO request_offset ("partition", "offset") VALUES ($1, $2)
+		ON CONFLICT ("partition") DO UPDATE SET "offset"=EXCLUDED."offset"
+		WHERE request_offset."offset" < EXCLUDED."offset"`,
+		"offset": `SELECT "offset" FROM request_offset WHERE "partition"=$1`,
+		"registerServer": `INSERT INTO signup_server (id, group_mode, paused_epoch) VALUES ($1, $2, $3)
+		ON CONFLICT (id) DO UPDATE SET group_mode=EXCLUDED.group_mode, paused_epoch=EXCLUDED.paused_epoch, seen_at=now()`,
+		"servers": "SELECT id, group_mode, paused_epoch, seen_at FROM signup_server WHERE seen_at >= $1 ORDER BY id",
 	}
 	for name, sql := range q {
 		if _, err := conn.Prepare(name, sql); err != nil {
@@ -74,9 +100,11 @@ func (s *UserService) Close() {
 }
 
 // CreateUser creates a user in Postgres.
+// It returns account.ErrUsernameTaken or account.ErrDuplicateUserID when the username or user ID are already taken.
+// Errors caused by temporary conditions such as lost connection are reported as account.TransientError.
 func (s *UserService) CreateUser(ctx context.Context, u *account.User) error {
 	_, err := s.pool.ExecEx(ctx, "create", nil, u.ID, u.Username)
-	return err
+	return wrapError(err)
 }
 
 // ByUsername looks up a user by username or returns account.ErrUserNotFound when a user is not found.
@@ -84,7 +112,243 @@ func (s *UserService) ByUsername(ctx context.Context, username string) (*account
 	u := account.User{Username: username}
 	err := s.pool.QueryRowEx(ctx, "byUsername", nil, username).Scan(&u.ID)
 	if err == pgx.ErrNoRows {
-		err = account.ErrUserNotFound
+		return &u, account.ErrUserNotFound
+	}
+	return &u, wrapError(err)
+}
+
+// Users passes all the users to f in order of their usernames until f returns an error.
+// It is used to move user accounts between shards, see shard.Migration.
+func (s *UserService) Users(ctx context.Context, f func(*account.User) error) error {
+	rows, err := s.pool.QueryEx(ctx, "users", nil)
+	if err != nil {
+		return wrapError(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var u account.User
+		if err = rows.Scan(&u.ID, &u.Username); err != nil {
+			return wrapError(err)
+		}
+		if err = f(&u); err != nil {
+			return err
+		}
+	}
+	return wrapError(rows.Err())
+}
+
+// DeleteUser deletes the user u or returns account.ErrUserNotFound when there is no such user.
+func (s *UserService) DeleteUser(ctx context.Context, u *account.User) error {
+	tag, err := s.pool.ExecEx(ctx, "delete", nil, u.ID, u.Username)
+	if err != nil {
+		return wrapError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return account.ErrUserNotFound
+	}
+	return nil
+}
+
+// ClaimUsername creates the user u in Postgres unless its username is already claimed by the existing user.
+// The decision is made by a single INSERT ... ON CONFLICT statement, so it is safe
+// to claim usernames concurrently. It returns account.ErrDuplicateUserID when the user ID is already taken.
+func (s *UserService) ClaimUsername(ctx context.Context, u *account.User) (bool, *account.User, error) {
+	existing := account.User{Username: u.Username}
+	var created bool
+	err := s.pool.QueryRowEx(ctx, "claim", nil, u.ID, u.Username).Scan(&existing.ID, &created)
+	switch {
+	case err == pgx.ErrNoRows:
+		// The username was claimed by a concurrent transaction which committed after the statement had started.
+		owner, err := s.ByUsername(ctx, u.Username)
+		if err != nil {
+			return false, nil, err
+		}
+		return false, owner, nil
+	case err != nil:
+		return false, nil, wrapError(err)
+	case created:
+		return true, nil, nil
+	}
+	return false, &existing, nil
+}
+
+// Response returns a response recorded for the signup request ID or account.ErrResponseNotFound.
+func (s *UserService) Response(ctx context.Context, requestID string) (*account.SignupResponse, error) {
+	resp := account.SignupResponse{RequestID: requestID}
+	var reason string
+	err := s.pool.QueryRowEx(ctx, "response", nil, requestID).Scan(&resp.Username, &resp.Success, &reason, &resp.Message)
+	if err == pgx.ErrNoRows {
+		return nil, account.ErrResponseNotFound
+	}
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	resp.Reason = account.Reason(reason)
+	return &resp, nil
+}
+
+// SaveResponse records a response to the signup request in signup_request table
+// along with the request's offset in one transaction.
+// It returns account.ErrDuplicateRequest when a response to the request has been already recorded.
+func (s *UserService) SaveResponse(ctx context.Context, req *account.SignupRequest, resp *account.SignupResponse) error {
+	tx, err := s.pool.BeginEx(ctx, nil)
+	if err != nil {
+		return wrapError(err)
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecEx(ctx, "saveResponse", nil, req.ID, resp.Username, resp.Success, string(resp.Reason), resp.Message, req.Partition, req.SequenceID); err != nil {
+		return wrapError(err)
+	}
+	if _, err = tx.ExecEx(ctx, "saveOffset", nil, req.Partition, req.SequenceID); err != nil {
+		return wrapError(err)
+	}
+	return wrapError(tx.CommitEx(ctx))
+}
+
+// Responses passes all the recorded responses and their requests to f in order of request IDs
+// until f returns an error. It is used to move responses between shards, see shard.Migration.
+func (s *UserService) Responses(ctx context.Context, f func(req *account.SignupRequest, resp *account.SignupResponse) error) error {
+	rows, err := s.pool.QueryEx(ctx, "responses", nil)
+	if err != nil {
+		return wrapError(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			req    account.SignupRequest
+			resp   account.SignupResponse
+			reason string
+		)
+		if err = rows.Scan(&req.ID, &resp.Username, &resp.Success, &reason, &resp.Message, &req.Partition, &req.SequenceID); err != nil {
+			return wrapError(err)
+		}
+		req.Username = resp.Username
+		resp.RequestID = req.ID
+		resp.Reason = account.Reason(reason)
+		if err = f(&req, &resp); err != nil {
+			return err
+		}
+	}
+	return wrapError(rows.Err())
+}
+
+// CopyResponse records a response moved from another shard without changing the partition's offset,
+// see shard.ResponseStore. The moved response is kept as long as a new one, see DeleteResponses.
+// It returns account.ErrDuplicateRequest when a response to the request has been already recorded.
+func (s *UserService) CopyResponse(ctx context.Context, req *account.SignupRequest, resp *account.SignupResponse) error {
+	_, err := s.pool.ExecEx(ctx, "saveResponse", nil, req.ID, resp.Username, resp.Success, string(resp.Reason), resp.Message, req.Partition, req.SequenceID)
+	return wrapError(err)
+}
+
+// DeleteResponse deletes the response to the signup request ID or returns account.ErrResponseNotFound.
+func (s *UserService) DeleteResponse(ctx context.Context, requestID string) error {
+	tag, err := s.pool.ExecEx(ctx, "deleteResponse", nil, requestID)
+	if err != nil {
+		return wrapError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return account.ErrResponseNotFound
+	}
+	return nil
+}
+
+// Offset returns the latest offset of a signup request processed from the partition
+// or account.ErrOffsetNotFound if no request has been recorded yet.
+// The offset is recorded in the same transaction as the user account and the response.
+func (s *UserService) Offset(ctx context.Context, partition int32) (int64, error) {
+	var offset int64
+	err := s.pool.QueryRowEx(ctx, "offset", nil, partition).Scan(&offset)
+	if err == pgx.ErrNoRows {
+		return 0, account.ErrOffsetNotFound
+	}
+	return offset, wrapError(err)
+}
+
+// SaveOffset records the offset of a signup request which got no response, see account.OffsetSaver.
+// The offset doesn't go back if a later one has been recorded.
+func (s *UserService) SaveOffset(ctx context.Context, partition int32, offset int64) error {
+	_, err := s.pool.ExecEx(ctx, "saveOffset", nil, partition, offset)
+	return wrapError(err)
+}
+
+// ClaimSignup claims a username and records the outcome as a response to the signup request in one transaction.
+// The request's offset is recorded in the transaction as well, see Offset.
+// It returns account.ErrDuplicateRequest when a response to the request has been already recorded,
+// in that case the user is not created.
+func (s *UserService) ClaimSignup(ctx context.Context, req *account.SignupRequest, u *account.User) (bool, *account.User, error) {
+	tx, err := s.pool.BeginEx(ctx, nil)
+	if err != nil {
+		return false, nil, wrapError(err)
+	}
+	// Rollback is safe to call even if the tx is already closed,
+	// so if the tx commits successfully, this is a no-op.
+	defer tx.Rollback()
+
+	existing := account.User{Username: u.Username}
+	var created bool
+	err = tx.QueryRowEx(ctx, "claim", nil, u.ID, u.Username).Scan(&existing.ID, &created)
+	if err == pgx.ErrNoRows {
+		// The username was claimed by a concurrent transaction which committed after the statement had started.
+		err = tx.QueryRowEx(ctx, "byUsername", nil, u.Username).Scan(&existing.ID)
+	}
+	if err != nil {
+		return false, nil, wrapError(err)
+	}
+
+	var reason account.Reason
+	if !created {
+		reason = account.ReasonTaken
+	}
+	if _, err = tx.ExecEx(ctx, "saveResponse", nil, req.ID, u.Username, created, string(reason), "", req.Partition, req.SequenceID); err != nil {
+		return false, nil, wrapError(err)
+	}
+	if _, err = tx.ExecEx(ctx, "saveOffset", nil, req.Partition, req.SequenceID); err != nil {
+		return false, nil, wrapError(err)
+	}
+	if err = tx.CommitEx(ctx); err != nil {
+		return false, nil, wrapError(err)
+	}
+
+	if created {
+		return true, nil, nil
+	}
+	return false, &existing, nil
+}
+
+// DeleteResponses deletes responses to signup requests which were processed before the given time.
+// It returns the number of deleted responses.
+func (s *UserService) DeleteResponses(ctx context.Context, before time.Time) (int64, error) {
+	tag, err := s.pool.ExecEx(ctx, "DELETE FROM signup_request WHERE processed_at < $1", nil, before)
+	if err != nil {
+		return 0, wrapError(err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// RegisterServer records the signup-server's state and the time it was seen, see account.ServerRegistry.
+func (s *UserService) RegisterServer(ctx context.Context, srv *account.Server) error {
+	_, err := s.pool.ExecEx(ctx, "registerServer", nil, srv.ID, srv.Group, srv.PausedEpoch)
+	return wrapError(err)
+}
+
+// Servers returns the signup-servers seen since the given time.
+func (s *UserService) Servers(ctx context.Context, since time.Time) ([]account.Server, error) {
+	rows, err := s.pool.QueryEx(ctx, "servers", nil, since)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	defer rows.Close()
+
+	var servers []account.Server
+	for rows.Next() {
+		var srv account.Server
+		if err = rows.Scan(&srv.ID, &srv.Group, &srv.PausedEpoch, &srv.SeenAt); err != nil {
+			return nil, wrapError(err)
+		}
+		servers = append(servers, srv)
 	}
-	return &u, err
+	return servers, wrapError(rows.Err())
 }
@@ -4,17 +4,40 @@ import (
 	"context"
 	"os"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/jackc/pgx"
 
 	"github.com/marselester/distributed-signup"
+	"github.com/marselester/distributed-signup/accounttest"
 	"github.com/marselester/distributed-signup/pg"
+	"github.com/marselester/distributed-signup/shard"
 )
 
 // Ensure pg.UserService implements account.UserService.
 var _ account.UserService = &pg.UserService{}
 
+// Ensure pg.UserService implements account.DedupStore and records responses atomically.
+var (
+	_ account.DedupStore    = &pg.UserService{}
+	_ account.SignupClaimer = &pg.UserService{}
+	_ account.OffsetStore   = &pg.UserService{}
+)
+
+// Ensure pg.UserService implements account.ServerRegistry.
+var _ account.ServerRegistry = &pg.UserService{}
+
+// Ensure pg.UserService records offsets of the requests without responses.
+var _ account.OffsetSaver = &pg.UserService{}
+
+// Ensure pg.UserService can be moved between shards along with the recorded responses.
+var (
+	_ shard.Store         = &pg.UserService{}
+	_ shard.ResponseStore = &pg.UserService{}
+)
+
 // client is a test wrapper for pg.UserService.
 type client struct {
 	// connConfig contains Postgres connection settings populated from the env.
@@ -122,6 +145,173 @@ func mustOpenClient() *client {
 	return c
 }
 
+func TestUserService(t *testing.T) {
+	accounttest.TestUserService(t, func(t *testing.T) (account.UserService, func()) {
+		c := mustOpenClient()
+		return c.user, c.close
+	})
+}
+
+func TestDedupStore(t *testing.T) {
+	accounttest.TestDedupStore(t, func(t *testing.T) (account.DedupStore, func()) {
+		c := mustOpenClient()
+		return c.user, c.close
+	})
+}
+
+func TestDeleteResponses(t *testing.T) {
+	c := mustOpenClient()
+	defer c.close()
+
+	ctx := context.Background()
+	req := account.SignupRequest{ID: "13rUw7cUfrGO9Go9xbZearzuuAu", Username: "bob"}
+	resp := account.SignupResponse{RequestID: req.ID, Username: req.Username, Success: true}
+	if err := c.user.SaveResponse(ctx, &req, &resp); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := c.user.DeleteResponses(ctx, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("DeleteResponses(an hour ago) deleted %d responses", n)
+	}
+
+	if n, err = c.user.DeleteResponses(ctx, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("DeleteResponses(in an hour) deleted %d responses, want 1", n)
+	}
+	if _, err = c.user.Response(ctx, req.ID); err != account.ErrResponseNotFound {
+		t.Errorf("DeleteResponses() must delete %s response", req.ID)
+	}
+}
+
+func TestMoveResponse(t *testing.T) {
+	c := mustOpenClient()
+	defer c.close()
+
+	ctx := context.Background()
+	req := account.SignupRequest{ID: "0ujzPyRiIAffKhBux4PvQdDqMHY", Username: "Bob", Partition: 2, SequenceID: 10}
+	resp := account.SignupResponse{RequestID: req.ID, Username: req.Username, Reason: account.ReasonTaken}
+	if err := c.user.CopyResponse(ctx, &req, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.user.CopyResponse(ctx, &req, &resp); err != account.ErrDuplicateRequest {
+		t.Errorf("CopyResponse(%s) = %v, must be ErrDuplicateRequest", req.ID, err)
+	}
+	// The moved response doesn't change the partition's offset.
+	if _, err := c.user.Offset(ctx, req.Partition); err != account.ErrOffsetNotFound {
+		t.Errorf("Offset(%d) = %v, must be ErrOffsetNotFound", req.Partition, err)
+	}
+
+	var got []account.SignupRequest
+	err := c.user.Responses(ctx, func(r *account.SignupRequest, _ *account.SignupResponse) error {
+		got = append(got, *r)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0] != req {
+		t.Errorf("Responses() = %+v, want %+v", got, req)
+	}
+
+	if err = c.user.DeleteResponse(ctx, req.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.user.DeleteResponse(ctx, req.ID); err != account.ErrResponseNotFound {
+		t.Errorf("DeleteResponse(%s) = %v, must be ErrResponseNotFound", req.ID, err)
+	}
+}
+
+func TestSaveResponseInvalid(t *testing.T) {
+	c := mustOpenClient()
+	defer c.close()
+
+	// Rejected requests are recorded as they were sent, even if they don't fit user accounts.
+	ctx := context.Background()
+	req := account.SignupRequest{ID: strings.Repeat("1", 100), Username: strings.Repeat("bob", 20)}
+	resp := account.SignupResponse{RequestID: req.ID, Username: req.Username, Reason: account.ReasonInvalid}
+	if err := c.user.SaveResponse(ctx, &req, &resp); err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.user.Response(ctx, req.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Username != req.Username || got.Reason != account.ReasonInvalid {
+		t.Errorf("Response(%s) = %+v, want %+v", req.ID, got, resp)
+	}
+}
+
+func TestRegisterServer(t *testing.T) {
+	c := mustOpenClient()
+	defer c.close()
+
+	ctx := context.Background()
+	since := time.Now().Add(-time.Minute)
+	for _, srv := range []account.Server{
+		{ID: "0ujzPyRiIAffKhBux4PvQdDqMHY", Group: true, PausedEpoch: account.NotPaused},
+		{ID: "0ujzPyRiIAffKhBux4PvQdDqMHY", Group: true, PausedEpoch: 3},
+	} {
+		if err := c.user.RegisterServer(ctx, &srv); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	servers, err := c.user.Servers(ctx, since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(servers) != 1 || !servers[0].Group || servers[0].PausedEpoch != 3 {
+		t.Errorf("Servers() = %+v, want one group server paused at epoch 3", servers)
+	}
+	if servers, err = c.user.Servers(ctx, time.Now().Add(time.Minute)); err != nil || len(servers) != 0 {
+		t.Errorf("Servers(in a minute) = %+v, %v, want none", servers, err)
+	}
+}
+
+func TestOffset(t *testing.T) {
+	c := mustOpenClient()
+	defer c.close()
+
+	ctx := context.Background()
+	if _, err := c.user.Offset(ctx, 2); err != account.ErrOffsetNotFound {
+		t.Errorf("Offset(2) = %v, must be ErrOffsetNotFound", err)
+	}
+
+	// The offset doesn't go back when an earlier request is recorded.
+	requests := []account.SignupRequest{
+		{ID: "13rUw7cUfrGO9Go9xbZearzuuAu", Username: "bob", Partition: 2, SequenceID: 3},
+		{ID: "13rVCgpmD0UgKH6zNHdfcPG63Df", Username: "sam", Partition: 2, SequenceID: 5},
+	}
+	users := []account.User{
+		{ID: "0ujzPyRiIAffKhBux4PvQdDqMHY", Username: "bob"},
+		{ID: "0ujtsYcgvSTl8PAuAdqWYSMnLOv", Username: "sam"},
+	}
+	for i := range requests {
+		if _, _, err := c.user.ClaimSignup(ctx, &requests[i], &users[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := account.SignupRequest{ID: "13rVFmwyaw2u5UXXNKIKMplycqb", Username: "bob", Partition: 2, SequenceID: 4}
+	resp := account.SignupResponse{RequestID: req.ID, Username: req.Username}
+	if err := c.user.SaveResponse(ctx, &req, &resp); err != nil {
+		t.Fatal(err)
+	}
+
+	offset, err := c.user.Offset(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset != 5 {
+		t.Errorf("Offset(2) = %d, want 5", offset)
+	}
+}
+
 func TestCreateUser(t *testing.T) {
 	c := mustOpenClient()
 	defer c.close()
@@ -158,8 +348,8 @@ func TestCreateUserDuplicateUsername(t *testing.T) {
 	}
 
 	u.ID = "123"
-	if err := c.user.CreateUser(ctx, &u); err == nil {
-		t.Errorf("CreateUser(%+v) must be duplicate username error", u)
+	if err := c.user.CreateUser(ctx, &u); err != account.ErrUsernameTaken {
+		t.Errorf("CreateUser(%+v) = %v, must be duplicate username error", u, err)
 	}
 }
 
@@ -177,8 +367,8 @@ func TestCreateUserDuplicateID(t *testing.T) {
 	}
 
 	u.Username = "alice"
-	if err := c.user.CreateUser(ctx, &u); err == nil {
-		t.Errorf("CreateUser(%+v) must be duplicate ID error", u)
+	if err := c.user.CreateUser(ctx, &u); err != account.ErrDuplicateUserID {
+		t.Errorf("CreateUser(%+v) = %v, must be duplicate ID error", u, err)
 	}
 }
 
@@ -215,3 +405,57 @@ func TestByUsernameNotFound(t *testing.T) {
 		t.Errorf("ByUsername(bob) = %+v, must be ErrUserNotFound", u)
 	}
 }
+
+func TestUsers(t *testing.T) {
+	c := mustOpenClient()
+	defer c.close()
+
+	ctx := context.Background()
+	want := []account.User{
+		{ID: "0ujtsYcgvSTl8PAuAdqWYSMnLOv", Username: "alice"},
+		{ID: "0ujzPyRiIAffKhBux4PvQdDqMHY", Username: "bob"},
+	}
+	for i := len(want) - 1; i >= 0; i-- {
+		if err := c.user.CreateUser(ctx, &want[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []account.User
+	err := c.user.Users(ctx, func(u *account.User) error {
+		got = append(got, *u)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Users() = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Users() user %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestDeleteUser(t *testing.T) {
+	c := mustOpenClient()
+	defer c.close()
+
+	ctx := context.Background()
+	u := account.User{ID: "0ujzPyRiIAffKhBux4PvQdDqMHY", Username: "bob"}
+	if err := c.user.CreateUser(ctx, &u); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.user.DeleteUser(ctx, &u); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.user.ByUsername(ctx, "bob"); err != account.ErrUserNotFound {
+		t.Errorf("DeleteUser(%+v) must delete bob", u)
+	}
+	if err := c.user.DeleteUser(ctx, &u); err != account.ErrUserNotFound {
+		t.Errorf("DeleteUser(%+v) = %v, must be ErrUserNotFound", u, err)
+	}
+}
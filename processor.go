@@ -0,0 +1,379 @@
+package account
+
+import (
+	"context"
+	"sync"
+)
+
+// maxUserIDAttempts is a number of times a Processor generates a user ID
+// when the previous one turned out to be taken.
+const maxUserIDAttempts = 3
+
+// Processor decides whether signup requests are granted and emits corresponding responses.
+// A username is granted to the first request which claims it, all the following requests fail.
+// A username is claimed atomically by UserService, so a decision is correct even if
+// requests for the same username are processed concurrently. Though to grant a username to the earliest request,
+// the requests should be processed sequentially, e.g., by reading them from a single partition of a signup requests topic.
+type Processor struct {
+	users    UserService
+	signups  SignupService
+	newID    func() (string, error)
+	dedup    DedupStore
+	policy   *UsernamePolicy
+	reserved *ReservedNames
+	retry    *RetryPolicy
+	retrier  Retrier
+
+	onSuccess   func(req *SignupRequest, u *User)
+	onFailure   func(req *SignupRequest, u *User)
+	onDuplicate func(req *SignupRequest, resp *SignupResponse)
+	onReject    func(req *SignupRequest, err error)
+	onRetry     func(req *SignupRequest, err error)
+	onPostpone  func(req *SignupRequest, err error)
+	// onError is called when the request is declined because of a permanent error.
+	// If it is nil, the error stops the processor.
+	onError func(req *SignupRequest, err error)
+}
+
+// ProcessorOption configures how we set up the Processor.
+type ProcessorOption func(*Processor)
+
+// WithSuccessHook sets a function which is called when a user u is signed up by the request.
+func WithSuccessHook(f func(req *SignupRequest, u *User)) ProcessorOption {
+	return func(p *Processor) {
+		p.onSuccess = f
+	}
+}
+
+// WithFailureHook sets a function which is called when the request is declined
+// because the username was already claimed by a user u.
+func WithFailureHook(f func(req *SignupRequest, u *User)) ProcessorOption {
+	return func(p *Processor) {
+		p.onFailure = f
+	}
+}
+
+// WithDuplicateHook sets a function which is called when the request has been already processed,
+// so the recorded response resp is emitted again.
+func WithDuplicateHook(f func(req *SignupRequest, resp *SignupResponse)) ProcessorOption {
+	return func(p *Processor) {
+		p.onDuplicate = f
+	}
+}
+
+// WithRejectHook sets a function which is called when the request is declined
+// because the username violates the username policy or it is reserved.
+func WithRejectHook(f func(req *SignupRequest, err error)) ProcessorOption {
+	return func(p *Processor) {
+		p.onReject = f
+	}
+}
+
+// WithRetryHook sets a function which is called before the request is retried in-process
+// because it failed with a transient error err.
+func WithRetryHook(f func(req *SignupRequest, err error)) ProcessorOption {
+	return func(p *Processor) {
+		p.onRetry = f
+	}
+}
+
+// WithPostponeHook sets a function which is called when the request is postponed by the retrier
+// because it kept failing with a transient error err.
+func WithPostponeHook(f func(req *SignupRequest, err error)) ProcessorOption {
+	return func(p *Processor) {
+		p.onPostpone = f
+	}
+}
+
+// WithDeclineOnError sets a function which is called when the request fails because of a permanent error,
+// e.g., a constraint violation in a database. The request is declined with the internal reason
+// instead of stopping the processor, though the response is not recorded in the dedup store,
+// so the request is processed again if it is replayed.
+// Transient errors such as a lost db connection still stop the processor, see IsTransient.
+func WithDeclineOnError(f func(req *SignupRequest, err error)) ProcessorOption {
+	return func(p *Processor) {
+		p.onError = f
+	}
+}
+
+// WithUsernamePolicy sets a policy which normalizes usernames and declines the invalid ones
+// with a response explaining the reason. The normalized username is claimed instead of the requested one.
+// By default usernames are claimed verbatim.
+func WithUsernamePolicy(policy *UsernamePolicy) ProcessorOption {
+	return func(p *Processor) {
+		p.policy = policy
+	}
+}
+
+// WithReservedNames sets the names which can't be claimed, e.g., admin or support.
+// The request for a reserved username is declined with the reserved reason before the username is looked up.
+// By default no names are reserved.
+func WithReservedNames(n *ReservedNames) ProcessorOption {
+	return func(p *Processor) {
+		p.reserved = n
+	}
+}
+
+// WithRetryPolicy sets a policy to retry the requests which failed because of transient errors,
+// e.g., a brief Postgres outage. The request is retried in-process with a backoff,
+// so the following requests of the partition wait and their order is preserved.
+// By default a transient error is returned right away.
+func WithRetryPolicy(r *RetryPolicy) ProcessorOption {
+	return func(p *Processor) {
+		p.retry = r
+	}
+}
+
+// WithRetrier sets a retrier which postpones the request when it still fails with a transient error
+// after in-process retries, so the processor moves on to the following requests instead of stopping.
+// By default the transient error stops the processor.
+func WithRetrier(r Retrier) ProcessorOption {
+	return func(p *Processor) {
+		p.retrier = r
+	}
+}
+
+// WithDedupStore sets a store to look up responses to already processed requests.
+// Responses are recorded in the store, so a request ID always gets the same response.
+// If the store implements SignupClaimer, usernames are claimed through the store instead of UserService,
+// so make sure they share the same database.
+// If the store implements SignupMarker, a request replayed after a crash between the claim and SaveResponse
+// gets the response it would have got.
+// By default requests are not deduplicated.
+func WithDedupStore(s DedupStore) ProcessorOption {
+	return func(p *Processor) {
+		p.dedup = s
+	}
+}
+
+// NewProcessor returns a Processor which reads signup requests from signups and keeps user accounts in users.
+// IDs of new users are generated by newID, e.g., KSUID.
+func NewProcessor(users UserService, signups SignupService, newID func() (string, error), options ...ProcessorOption) *Processor {
+	p := Processor{
+		users:       users,
+		signups:     signups,
+		newID:       newID,
+		onSuccess:   func(*SignupRequest, *User) {},
+		onFailure:   func(*SignupRequest, *User) {},
+		onDuplicate: func(*SignupRequest, *SignupResponse) {},
+		onReject:    func(*SignupRequest, error) {},
+		onRetry:     func(*SignupRequest, error) {},
+		onPostpone:  func(*SignupRequest, error) {},
+	}
+
+	for _, opt := range options {
+		opt(&p)
+	}
+	return &p
+}
+
+// Run processes signup requests as they arrive and writes responses until
+// an error occurs or ctx is cancelled.
+// It is safe if the SignupService passes requests from different partitions concurrently.
+func (p *Processor) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu  sync.Mutex
+		err error
+	)
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return err != nil
+	}
+	rerr := p.signups.Requests(ctx, func(reqCtx context.Context, req *SignupRequest) {
+		// The requests which arrived after a failure are not processed,
+		// so the server could be restarted from the failed request.
+		if failed() {
+			return
+		}
+		herr := p.Handle(reqCtx, req)
+		// The request which was interrupted because its partition was revoked is processed by the new owner.
+		if herr != nil && reqCtx.Err() != nil && ctx.Err() == nil {
+			return
+		}
+		if herr != nil {
+			mu.Lock()
+			if err == nil {
+				err = herr
+			}
+			mu.Unlock()
+			cancel()
+		}
+	})
+	if failed() {
+		return err
+	}
+	return rerr
+}
+
+// Handle processes a signup request and writes the response.
+// If the request fails because of a transient error, it is retried according to the retry policy,
+// and then it is postponed by the retrier if there is one.
+func (p *Processor) Handle(ctx context.Context, req *SignupRequest) error {
+	resp, err := p.Process(ctx, req)
+	for attempt := 0; err != nil && p.retry != nil && attempt < p.retry.attempts && IsTransient(err); attempt++ {
+		p.onRetry(req, err)
+		if !p.retry.wait(ctx, attempt) {
+			return err
+		}
+		resp, err = p.Process(ctx, req)
+	}
+	if err != nil && p.retrier != nil && IsTransient(err) && ctx.Err() == nil {
+		if rerr := p.retrier.Retry(ctx, req, err); rerr != nil {
+			return rerr
+		}
+		p.onPostpone(req, err)
+		return nil
+	}
+	if err != nil {
+		if p.onError == nil || IsTransient(err) || ctx.Err() != nil {
+			return err
+		}
+		p.onError(req, err)
+		resp = &SignupResponse{
+			RequestID: req.ID,
+			Username:  req.Username,
+			Reason:    ReasonInternal,
+		}
+	}
+	return p.signups.CreateResponse(ctx, resp)
+}
+
+// claimUsername creates a user with a new ID unless the username is claimed by the existing user.
+// There are a few attempts to generate an ID in case the user ID is already taken.
+// If the dedup store is a SignupClaimer, the outcome is recorded as a response to the request.
+// If the dedup store is a SignupMarker, the user ID is marked before the username is claimed,
+// and the user is considered created if the username turns out to be claimed by the marked ID.
+func (p *Processor) claimUsername(ctx context.Context, req *SignupRequest) (u *User, created bool, existing *User, err error) {
+	claimer, recorded := p.dedup.(SignupClaimer)
+	marker, marked := p.dedup.(SignupMarker)
+	marked = marked && !recorded
+
+	var pendingID string
+	if marked {
+		if pendingID, err = marker.PendingSignup(ctx, req.ID); err != nil {
+			return nil, false, nil, err
+		}
+	}
+
+	u = &User{Username: req.Username}
+	for i := 0; i < maxUserIDAttempts; i++ {
+		switch {
+		case pendingID != "":
+			// The request has been processed up to the claim before, e.g., the server crashed,
+			// so the same user ID is claimed again.
+			u.ID, pendingID = pendingID, ""
+		case marked:
+			if u.ID, err = p.newID(); err != nil {
+				return nil, false, nil, err
+			}
+			if err = marker.MarkSignup(ctx, req, u.ID); err != nil {
+				return nil, false, nil, err
+			}
+		default:
+			if u.ID, err = p.newID(); err != nil {
+				return nil, false, nil, err
+			}
+		}
+
+		if recorded {
+			created, existing, err = claimer.ClaimSignup(ctx, req, u)
+		} else {
+			created, existing, err = p.users.ClaimUsername(ctx, u)
+		}
+		if err != ErrDuplicateUserID {
+			break
+		}
+	}
+
+	if marked && err == nil && !created && existing != nil && existing.ID == u.ID {
+		// The user was created by this request, but its response wasn't recorded.
+		return u, true, nil, nil
+	}
+	return u, created, existing, err
+}
+
+// Process creates a user if the requested username is not claimed yet.
+// The returned response indicates whether the signup request was successful.
+// If the request has been already processed, the recorded response is returned.
+func (p *Processor) Process(ctx context.Context, req *SignupRequest) (*SignupResponse, error) {
+	if p.dedup != nil {
+		resp, err := p.dedup.Response(ctx, req.ID)
+		switch err {
+		case nil:
+			p.onDuplicate(req, resp)
+			return resp, nil
+		case ErrResponseNotFound:
+		default:
+			return nil, err
+		}
+	}
+
+	resp := SignupResponse{
+		RequestID: req.ID,
+		Username:  req.Username,
+	}
+
+	if p.policy != nil {
+		name, err := p.policy.Apply(req.Username)
+		if err != nil {
+			resp.Reason = ReasonInvalid
+			resp.Message = err.Error()
+			p.onReject(req, err)
+			return p.saveResponse(ctx, req, &resp)
+		}
+		normalized := *req
+		normalized.Username = name
+		req = &normalized
+		resp.Username = name
+	}
+
+	if p.reserved != nil && p.reserved.Reserved(req.Username) {
+		resp.Reason = ReasonReserved
+		resp.Message = ErrUsernameReserved.Error()
+		p.onReject(req, ErrUsernameReserved)
+		return p.saveResponse(ctx, req, &resp)
+	}
+
+	u, created, existing, err := p.claimUsername(ctx, req)
+	if err == ErrDuplicateRequest {
+		// The same request was processed concurrently, so its recorded response is returned.
+		return p.dedup.Response(ctx, req.ID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if created {
+		resp.Success = true
+		p.onSuccess(req, u)
+	} else {
+		resp.Success = false
+		resp.Reason = ReasonTaken
+		p.onFailure(req, existing)
+	}
+
+	if _, recorded := p.dedup.(SignupClaimer); recorded {
+		return &resp, nil
+	}
+	return p.saveResponse(ctx, req, &resp)
+}
+
+// saveResponse records the response in the dedup store if there is one.
+// If the request was processed concurrently, its recorded response is returned instead.
+func (p *Processor) saveResponse(ctx context.Context, req *SignupRequest, resp *SignupResponse) (*SignupResponse, error) {
+	if p.dedup == nil {
+		return resp, nil
+	}
+
+	err := p.dedup.SaveResponse(ctx, req, resp)
+	if err == ErrDuplicateRequest {
+		return p.dedup.Response(ctx, req.ID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
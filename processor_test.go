@@ -0,0 +1,442 @@
+package account_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/marselester/distributed-signup"
+	"github.com/marselester/distributed-signup/memory"
+)
+
+// sequentialID returns ID generator which returns the given IDs one by one.
+func sequentialID(ids ...string) func() (string, error) {
+	return func() (string, error) {
+		if len(ids) == 0 {
+			return "", errors.New("no ids left")
+		}
+		id := ids[0]
+		ids = ids[1:]
+		return id, nil
+	}
+}
+
+func TestProcess(t *testing.T) {
+	users := memory.NewUserService()
+	var signedUp, declined []string
+	p := account.NewProcessor(users, memory.NewSignupService(), sequentialID("0ujzPyRiIAffKhBux4PvQdDqMHY", "0ujtsYcgvSTl8PAuAdqWYSMnLOv"),
+		account.WithSuccessHook(func(req *account.SignupRequest, u *account.User) {
+			signedUp = append(signedUp, req.ID)
+		}),
+		account.WithFailureHook(func(req *account.SignupRequest, u *account.User) {
+			declined = append(declined, req.ID)
+		}),
+	)
+
+	ctx := context.Background()
+	tests := []struct {
+		req  account.SignupRequest
+		want account.SignupResponse
+	}{
+		{
+			req:  account.SignupRequest{ID: "13rUw7cUfrGO9Go9xbZearzuuAu", Username: "bob"},
+			want: account.SignupResponse{RequestID: "13rUw7cUfrGO9Go9xbZearzuuAu", Username: "bob", Success: true},
+		},
+		{
+			req:  account.SignupRequest{ID: "13rVCgpmD0UgKH6zNHdfcPG63Df", Username: "bob"},
+			want: account.SignupResponse{RequestID: "13rVCgpmD0UgKH6zNHdfcPG63Df", Username: "bob", Success: false, Reason: account.ReasonTaken},
+		},
+	}
+	for _, tc := range tests {
+		got, err := p.Process(ctx, &tc.req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *got != tc.want {
+			t.Errorf("Process(%+v) = %+v, want %+v", tc.req, got, tc.want)
+		}
+	}
+
+	u, err := users.ByUsername(ctx, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.ID != "0ujzPyRiIAffKhBux4PvQdDqMHY" {
+		t.Errorf("Process() created user %+v", u)
+	}
+	if len(signedUp) != 1 || signedUp[0] != "13rUw7cUfrGO9Go9xbZearzuuAu" {
+		t.Errorf("Process() success hook called for %v", signedUp)
+	}
+	if len(declined) != 1 || declined[0] != "13rVCgpmD0UgKH6zNHdfcPG63Df" {
+		t.Errorf("Process() failure hook called for %v", declined)
+	}
+}
+
+func TestRun(t *testing.T) {
+	b := memory.NewBroker(3)
+	client := memory.NewSignupService(
+		memory.WithBroker(b),
+		memory.WithResponseOffset(memory.OffsetOldest),
+	)
+	// Bob's requests are stored in the partition 2.
+	server := memory.NewSignupService(
+		memory.WithBroker(b),
+		memory.WithRequestPartition(2),
+		memory.WithRequestOffset(memory.OffsetOldest),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, id := range []string{"13rUw7cUfrGO9Go9xbZearzuuAu", "13rVCgpmD0UgKH6zNHdfcPG63Df", "13rVFmwyaw2u5UXXNKIKMplycqb"} {
+		req := account.SignupRequest{ID: id, Username: "bob"}
+		if err := client.CreateRequest(ctx, &req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := account.NewProcessor(memory.NewUserService(), server, sequentialID(
+		"0ujzPyRiIAffKhBux4PvQdDqMHY", "0ujtsYcgvSTl8PAuAdqWYSMnLOv", "0ujsswThIGTUYm2K8FjOOfXtY1K",
+	))
+	errc := make(chan error, 1)
+	go func() {
+		err := p.Run(ctx)
+		if err != nil {
+			cancel()
+		}
+		errc <- err
+	}()
+
+	var got []bool
+	err := client.Responses(ctx, func(resp *account.SignupResponse) {
+		got = append(got, resp.Success)
+		if len(got) == 3 {
+			cancel()
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = <-errc; err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+	want := []bool{true, false, false}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Run() response %d success = %t, want %t", i, got[i], want[i])
+		}
+	}
+}
+
+func TestRunError(t *testing.T) {
+	s := memory.NewSignupService(
+		memory.WithRequestPartition(2),
+		memory.WithRequestOffset(memory.OffsetOldest),
+	)
+	ctx := context.Background()
+	for _, username := range []string{"bob", "sam"} {
+		req := account.SignupRequest{ID: "13rUw7cUfrGO9Go9xbZearzuuAu", Username: username}
+		if err := s.CreateRequest(ctx, &req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var calls int
+	newID := func() (string, error) {
+		calls++
+		return "", errors.New("no ids left")
+	}
+	p := account.NewProcessor(memory.NewUserService(), s, newID)
+	if err := p.Run(ctx); err == nil || err.Error() != "no ids left" {
+		t.Errorf("Run() = %v, want no ids left error", err)
+	}
+	if calls != 1 {
+		t.Errorf("Run() must stop processing on error, %d requests were processed", calls)
+	}
+}
+
+// revokedSignups passes a signup request to f with the context which is done as if its partition was revoked.
+type revokedSignups struct {
+	*memory.SignupService
+}
+
+func (s *revokedSignups) Requests(ctx context.Context, f func(context.Context, *account.SignupRequest)) error {
+	reqCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	f(reqCtx, &account.SignupRequest{ID: "13rUw7cUfrGO9Go9xbZearzuuAu", Username: "bob"})
+	return nil
+}
+
+func TestRunRevoked(t *testing.T) {
+	newID := func() (string, error) {
+		return "", context.Canceled
+	}
+	p := account.NewProcessor(memory.NewUserService(), &revokedSignups{memory.NewSignupService()}, newID)
+	if err := p.Run(context.Background()); err != nil {
+		t.Errorf("Run() = %v, the request of the revoked partition must not stop processing", err)
+	}
+}
+
+func TestHandleDeclineOnError(t *testing.T) {
+	b := memory.NewBroker(3)
+	client := memory.NewSignupService(
+		memory.WithBroker(b),
+		memory.WithResponseOffset(memory.OffsetOldest),
+	)
+	var declined []error
+	newID := func() (string, error) {
+		return "", errors.New("no ids left")
+	}
+	p := account.NewProcessor(memory.NewUserService(), memory.NewSignupService(memory.WithBroker(b)), newID,
+		account.WithDeclineOnError(func(req *account.SignupRequest, err error) {
+			declined = append(declined, err)
+		}),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req := account.SignupRequest{ID: "13rUw7cUfrGO9Go9xbZearzuuAu", Username: "bob"}
+	if err := p.Handle(ctx, &req); err != nil {
+		t.Fatalf("Handle(%+v) = %v, the request must be declined", req, err)
+	}
+	if len(declined) != 1 {
+		t.Errorf("Handle(%+v) called the error hook %d times", req, len(declined))
+	}
+
+	var got account.SignupResponse
+	err := client.Responses(ctx, func(resp *account.SignupResponse) {
+		got = *resp
+		cancel()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Success || got.Reason != account.ReasonInternal {
+		t.Errorf("Handle(%+v) emitted %+v, want internal reason", req, got)
+	}
+
+	// Transient errors stop the processor, so the request is retried.
+	p = account.NewProcessor(memory.NewUserService(), memory.NewSignupService(), func() (string, error) {
+		return "", &account.TransientError{Err: errors.New("connection lost")}
+	}, account.WithDeclineOnError(func(*account.SignupRequest, error) {}))
+	if err = p.Handle(context.Background(), &req); !account.IsTransient(err) {
+		t.Errorf("Handle(%+v) = %v, want transient error", req, err)
+	}
+}
+
+func TestProcessDuplicateUserID(t *testing.T) {
+	users := memory.NewUserService()
+	ctx := context.Background()
+	alice := account.User{ID: "0ujtsYcgvSTl8PAuAdqWYSMnLOv", Username: "alice"}
+	if err := users.CreateUser(ctx, &alice); err != nil {
+		t.Fatal(err)
+	}
+
+	p := account.NewProcessor(users, memory.NewSignupService(), sequentialID(alice.ID, "0ujzPyRiIAffKhBux4PvQdDqMHY"))
+	req := account.SignupRequest{ID: "13rUw7cUfrGO9Go9xbZearzuuAu", Username: "bob"}
+	resp, err := p.Process(ctx, &req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Success {
+		t.Errorf("Process(%+v) must succeed with another user ID", req)
+	}
+
+	u, err := users.ByUsername(ctx, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.ID != "0ujzPyRiIAffKhBux4PvQdDqMHY" {
+		t.Errorf("Process(%+v) created user %+v", req, u)
+	}
+}
+
+func TestProcessDuplicateRequest(t *testing.T) {
+	users := memory.NewUserService()
+	var replayed []string
+	p := account.NewProcessor(users, memory.NewSignupService(), sequentialID("0ujzPyRiIAffKhBux4PvQdDqMHY", "0ujtsYcgvSTl8PAuAdqWYSMnLOv"),
+		account.WithDedupStore(memory.NewDedupStore(0, 0)),
+		account.WithDuplicateHook(func(req *account.SignupRequest, resp *account.SignupResponse) {
+			replayed = append(replayed, req.ID)
+		}),
+	)
+
+	// The first request is replayed, e.g., the server was restarted from the oldest offset.
+	ctx := context.Background()
+	requests := []account.SignupRequest{
+		{ID: "13rUw7cUfrGO9Go9xbZearzuuAu", Username: "bob"},
+		{ID: "13rVCgpmD0UgKH6zNHdfcPG63Df", Username: "bob"},
+		{ID: "13rUw7cUfrGO9Go9xbZearzuuAu", Username: "bob"},
+	}
+	var got []bool
+	for i := range requests {
+		resp, err := p.Process(ctx, &requests[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, resp.Success)
+	}
+
+	want := []bool{true, false, true}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Process(%+v) success = %t, want %t", requests[i], got[i], want[i])
+		}
+	}
+	if len(replayed) != 1 || replayed[0] != "13rUw7cUfrGO9Go9xbZearzuuAu" {
+		t.Errorf("Process() duplicate hook called for %v", replayed)
+	}
+}
+
+func TestProcessUsernamePolicy(t *testing.T) {
+	users := memory.NewUserService()
+	var rejected []error
+	p := account.NewProcessor(users, memory.NewSignupService(), sequentialID("0ujzPyRiIAffKhBux4PvQdDqMHY", "0ujtsYcgvSTl8PAuAdqWYSMnLOv"),
+		account.WithUsernamePolicy(account.NewUsernamePolicy()),
+		account.WithDedupStore(memory.NewDedupStore(0, 0)),
+		account.WithRejectHook(func(req *account.SignupRequest, err error) {
+			rejected = append(rejected, err)
+		}),
+	)
+
+	ctx := context.Background()
+	tests := []struct {
+		req  account.SignupRequest
+		want account.SignupResponse
+	}{
+		{
+			req:  account.SignupRequest{ID: "13rUw7cUfrGO9Go9xbZearzuuAu", Username: "Bob "},
+			want: account.SignupResponse{RequestID: "13rUw7cUfrGO9Go9xbZearzuuAu", Username: "bob", Success: true},
+		},
+		{
+			req:  account.SignupRequest{ID: "13rVCgpmD0UgKH6zNHdfcPG63Df", Username: "bob"},
+			want: account.SignupResponse{RequestID: "13rVCgpmD0UgKH6zNHdfcPG63Df", Username: "bob", Success: false, Reason: account.ReasonTaken},
+		},
+		{
+			req:  account.SignupRequest{ID: "13rVFmwyaw2u5UXXNKIKMplycqb", Username: "Ьоb"},
+			want: account.SignupResponse{RequestID: "13rVFmwyaw2u5UXXNKIKMplycqb", Username: "Ьоb", Success: false, Reason: account.ReasonInvalid, Message: "username mixes scripts"},
+		},
+		// The rejected request is replayed.
+		{
+			req:  account.SignupRequest{ID: "13rVFmwyaw2u5UXXNKIKMplycqb", Username: "Ьоb"},
+			want: account.SignupResponse{RequestID: "13rVFmwyaw2u5UXXNKIKMplycqb", Username: "Ьоb", Success: false, Reason: account.ReasonInvalid, Message: "username mixes scripts"},
+		},
+	}
+	for _, tc := range tests {
+		got, err := p.Process(ctx, &tc.req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *got != tc.want {
+			t.Errorf("Process(%+v) = %+v, want %+v", tc.req, got, tc.want)
+		}
+	}
+
+	if _, err := users.ByUsername(ctx, "bob"); err != nil {
+		t.Errorf("Process() must claim the normalized username: %v", err)
+	}
+	if len(rejected) != 1 || rejected[0] != account.ErrUsernameMixedScripts {
+		t.Errorf("Process() reject hook called with %v", rejected)
+	}
+}
+
+func TestProcessReservedNames(t *testing.T) {
+	reserved, err := account.ReadReservedNames(strings.NewReader("admin\nprefix:support"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := memory.NewUserService()
+	var rejected []error
+	p := account.NewProcessor(users, memory.NewSignupService(), sequentialID("0ujzPyRiIAffKhBux4PvQdDqMHY"),
+		account.WithUsernamePolicy(account.NewUsernamePolicy()),
+		account.WithReservedNames(reserved),
+		account.WithRejectHook(func(req *account.SignupRequest, err error) {
+			rejected = append(rejected, err)
+		}),
+	)
+
+	ctx := context.Background()
+	tests := []struct {
+		req  account.SignupRequest
+		want account.SignupResponse
+	}{
+		{
+			req:  account.SignupRequest{ID: "13rUw7cUfrGO9Go9xbZearzuuAu", Username: "Admin"},
+			want: account.SignupResponse{RequestID: "13rUw7cUfrGO9Go9xbZearzuuAu", Username: "admin", Success: false, Reason: account.ReasonReserved, Message: "username is reserved"},
+		},
+		{
+			req:  account.SignupRequest{ID: "13rVCgpmD0UgKH6zNHdfcPG63Df", Username: "support-team"},
+			want: account.SignupResponse{RequestID: "13rVCgpmD0UgKH6zNHdfcPG63Df", Username: "support-team", Success: false, Reason: account.ReasonReserved, Message: "username is reserved"},
+		},
+		{
+			req:  account.SignupRequest{ID: "13rVFmwyaw2u5UXXNKIKMplycqb", Username: "bob"},
+			want: account.SignupResponse{RequestID: "13rVFmwyaw2u5UXXNKIKMplycqb", Username: "bob", Success: true},
+		},
+	}
+	for _, tc := range tests {
+		got, err := p.Process(ctx, &tc.req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *got != tc.want {
+			t.Errorf("Process(%+v) = %+v, want %+v", tc.req, got, tc.want)
+		}
+	}
+
+	if _, err = users.ByUsername(ctx, "admin"); err != account.ErrUserNotFound {
+		t.Errorf("Process() must not claim reserved username: %v", err)
+	}
+	if len(rejected) != 2 || rejected[0] != account.ErrUsernameReserved {
+		t.Errorf("Process() reject hook called with %v", rejected)
+	}
+}
+
+// claimingStore is a DedupStore which claims usernames and records responses together.
+type claimingStore struct {
+	*memory.DedupStore
+	users  *memory.UserService
+	claims int
+}
+
+func (s *claimingStore) ClaimSignup(ctx context.Context, req *account.SignupRequest, u *account.User) (bool, *account.User, error) {
+	s.claims++
+	created, existing, err := s.users.ClaimUsername(ctx, u)
+	if err != nil {
+		return false, nil, err
+	}
+	resp := account.SignupResponse{RequestID: req.ID, Username: req.Username, Success: created}
+	if !created {
+		resp.Reason = account.ReasonTaken
+	}
+	return created, existing, s.SaveResponse(ctx, req, &resp)
+}
+
+func TestProcessSignupClaimer(t *testing.T) {
+	store := claimingStore{
+		DedupStore: memory.NewDedupStore(0, 0),
+		users:      memory.NewUserService(),
+	}
+	// The processor's UserService must not be used because usernames are claimed by the store.
+	p := account.NewProcessor(memory.NewUserService(), memory.NewSignupService(), sequentialID("0ujzPyRiIAffKhBux4PvQdDqMHY"),
+		account.WithDedupStore(&store),
+	)
+
+	ctx := context.Background()
+	req := account.SignupRequest{ID: "13rUw7cUfrGO9Go9xbZearzuuAu", Username: "bob"}
+	for i := 0; i < 2; i++ {
+		resp, err := p.Process(ctx, &req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !resp.Success {
+			t.Errorf("Process(%+v) must succeed", req)
+		}
+	}
+
+	if store.claims != 1 {
+		t.Errorf("Process() claimed username %d times, want 1", store.claims)
+	}
+	if _, err := store.users.ByUsername(ctx, "bob"); err != nil {
+		t.Errorf("Process() must claim username using the store: %v", err)
+	}
+}
@@ -0,0 +1,121 @@
+package account
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// ErrUsernameReserved error indicates that a username is blocked by ReservedNames,
+// e.g., it impersonates the staff or it is offensive.
+const ErrUsernameReserved = Error("username is reserved")
+
+// ReservedNames decides whether a username is reserved, so it can't be claimed.
+// The rules are loaded from a file and can be reloaded while the names are being checked.
+// The zero value reserves nothing.
+type ReservedNames struct {
+	mu       sync.RWMutex
+	exact    map[string]bool
+	prefixes []string
+	patterns []*regexp.Regexp
+}
+
+// ReadReservedNames parses the rules from r, one rule per line.
+// A line is either a username, a prefix, or a regular expression, for example:
+//
+//	# Staff.
+//	admin
+//	support
+//	prefix:root
+//	regex:^sys(op|admin)\d*$
+//
+// Usernames and prefixes are normalized, see NormalizeUsername.
+// Regular expressions are matched against normalized usernames.
+// Blank lines and the lines starting with # are ignored.
+func ReadReservedNames(r io.Reader) (*ReservedNames, error) {
+	n := ReservedNames{
+		exact: make(map[string]bool),
+	}
+
+	s := bufio.NewScanner(r)
+	for i := 1; s.Scan(); i++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "prefix:"):
+			prefix := NormalizeUsername(strings.TrimPrefix(line, "prefix:"))
+			if prefix == "" {
+				return nil, fmt.Errorf("reserved names: line %d: blank prefix", i)
+			}
+			n.prefixes = append(n.prefixes, prefix)
+		case strings.HasPrefix(line, "regex:"):
+			re, err := regexp.Compile(strings.TrimPrefix(line, "regex:"))
+			if err != nil {
+				return nil, fmt.Errorf("reserved names: line %d: %v", i, err)
+			}
+			n.patterns = append(n.patterns, re)
+		default:
+			n.exact[NormalizeUsername(strings.TrimPrefix(line, "exact:"))] = true
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+// OpenReservedNames reads the rules from the file, see ReadReservedNames.
+func OpenReservedNames(path string) (*ReservedNames, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadReservedNames(f)
+}
+
+// Reload replaces the rules with the ones read from the file.
+// If the file can't be read or parsed, the current rules are kept.
+func (n *ReservedNames) Reload(path string) error {
+	next, err := OpenReservedNames(path)
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	n.exact = next.exact
+	n.prefixes = next.prefixes
+	n.patterns = next.patterns
+	n.mu.Unlock()
+	return nil
+}
+
+// Reserved reports whether the username matches any of the rules.
+func (n *ReservedNames) Reserved(username string) bool {
+	name := NormalizeUsername(username)
+
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	if n.exact[name] {
+		return true
+	}
+	for _, prefix := range n.prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	for _, re := range n.patterns {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
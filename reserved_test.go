@@ -0,0 +1,92 @@
+package account_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/marselester/distributed-signup"
+)
+
+func TestReservedNames(t *testing.T) {
+	n, err := account.ReadReservedNames(strings.NewReader(`
+		# Staff.
+		Admin
+		exact:support
+		prefix:root
+		regex:^sys(op|admin)\d*$
+	`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]bool{
+		"admin":     true,
+		"ADMIN ":    true,
+		"ａｄｍｉｎ":     true,
+		"admin1":    false,
+		"support":   true,
+		"root":      true,
+		"rootkit":   true,
+		"groot":     false,
+		"sysop":     true,
+		"SysAdmin2": true,
+		"sysadmins": false,
+		"bob":       false,
+	}
+	for username, want := range tests {
+		if got := n.Reserved(username); got != want {
+			t.Errorf("Reserved(%q) = %t, want %t", username, got, want)
+		}
+	}
+}
+
+func TestReadReservedNamesError(t *testing.T) {
+	tests := map[string]string{
+		"admin\nregex:(":  "reserved names: line 2: error parsing regexp: missing closing ): `(`",
+		"admin\nprefix: ": "reserved names: line 2: blank prefix",
+	}
+	for rules, want := range tests {
+		_, err := account.ReadReservedNames(strings.NewReader(rules))
+		if err == nil || err.Error() != want {
+			t.Errorf("ReadReservedNames(%q) = %v, want %s", rules, err, want)
+		}
+	}
+}
+
+func TestReservedNamesReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reserved")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "reserved.txt")
+
+	var n account.ReservedNames
+	if n.Reserved("admin") {
+		t.Error("Reserved(admin) = true, zero value must reserve nothing")
+	}
+
+	if err = ioutil.WriteFile(path, []byte("admin\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = n.Reload(path); err != nil {
+		t.Fatal(err)
+	}
+	if !n.Reserved("admin") {
+		t.Error("Reserved(admin) = false, want true after reload")
+	}
+
+	// The broken rules are not applied.
+	if err = ioutil.WriteFile(path, []byte("regex:("), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = n.Reload(path); err == nil {
+		t.Error("Reload() must fail on invalid regex")
+	}
+	if !n.Reserved("admin") {
+		t.Error("Reserved(admin) = false, failed reload must keep the rules")
+	}
+}
@@ -0,0 +1,114 @@
+package account
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+const (
+	// Default number of times a request is retried in-process.
+	// With the default backoffs a request is retried for at least 13s, so a server rides out a brief Postgres outage
+	// even if it reads a single partition and can't postpone requests to retry topics.
+	defaultRetryAttempts = 10
+	// Default backoff before the first retry.
+	defaultMinBackoff = 100 * time.Millisecond
+	// Default max backoff between retries.
+	defaultMaxBackoff = 5 * time.Second
+)
+
+// Retrier postpones signup requests which failed because of transient errors, e.g., by writing them to retry topics.
+// A postponed request is passed to SignupService.Requests again later with incremented Retries.
+type Retrier interface {
+	// Retry postpones the request which failed because of the cause.
+	// It returns an error if the request can't be postponed anymore.
+	Retry(ctx context.Context, req *SignupRequest, cause error) error
+}
+
+// RetryPolicy decides how many times and how soon a request is retried in-process
+// when it fails because of a transient error, see IsTransient.
+// Backoff grows exponentially with jitter, so servers don't retry in lockstep after an outage.
+type RetryPolicy struct {
+	attempts   int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+// RetryPolicyOption configures how we set up the RetryPolicy.
+type RetryPolicyOption func(*RetryPolicy)
+
+// WithRetryAttempts sets max number of retries, 0 disables them.
+func WithRetryAttempts(n int) RetryPolicyOption {
+	return func(p *RetryPolicy) {
+		p.attempts = n
+	}
+}
+
+// WithRetryBackoff sets the backoff before the first retry and the max backoff between retries.
+func WithRetryBackoff(min, max time.Duration) RetryPolicyOption {
+	return func(p *RetryPolicy) {
+		p.minBackoff = min
+		p.maxBackoff = max
+	}
+}
+
+// NewRetryPolicy returns a RetryPolicy which can be configured with options.
+// By default a request is retried 10 times with backoff from 100ms to 5s.
+func NewRetryPolicy(options ...RetryPolicyOption) *RetryPolicy {
+	p := RetryPolicy{
+		attempts:   defaultRetryAttempts,
+		minBackoff: defaultMinBackoff,
+		maxBackoff: defaultMaxBackoff,
+	}
+
+	for _, opt := range options {
+		opt(&p)
+	}
+	return &p
+}
+
+// Backoff returns how long to wait before the given retry attempt (starting from 0).
+// The backoff is doubled every attempt up to the max backoff, and then half of it is randomized.
+func (p *RetryPolicy) Backoff(attempt int) time.Duration {
+	d := p.minBackoff
+	for i := 0; i < attempt && d < p.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.maxBackoff {
+		d = p.maxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// MinDuration returns the least time a request is retried for before the policy gives up,
+// i.e., the sum of the shortest backoffs of all the attempts.
+func (p *RetryPolicy) MinDuration() time.Duration {
+	var total time.Duration
+	d := p.minBackoff
+	for i := 0; i < p.attempts; i++ {
+		if d > p.maxBackoff {
+			d = p.maxBackoff
+		}
+		if d > 0 {
+			total += d / 2
+		}
+		d *= 2
+	}
+	return total
+}
+
+// wait blocks for the backoff of the given attempt. It returns false if ctx is cancelled earlier.
+func (p *RetryPolicy) wait(ctx context.Context, attempt int) bool {
+	t := time.NewTimer(p.Backoff(attempt))
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
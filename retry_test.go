@@ -0,0 +1,129 @@
+package account_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/marselester/distributed-signup"
+	"github.com/marselester/distributed-signup/memory"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := account.NewRetryPolicy(account.WithRetryBackoff(100*time.Millisecond, time.Second))
+	tests := []struct {
+		attempt  int
+		min, max time.Duration
+	}{
+		{0, 50 * time.Millisecond, 100 * time.Millisecond},
+		{1, 100 * time.Millisecond, 200 * time.Millisecond},
+		{3, 400 * time.Millisecond, 800 * time.Millisecond},
+		{4, 500 * time.Millisecond, time.Second},
+		{100, 500 * time.Millisecond, time.Second},
+	}
+	for _, tc := range tests {
+		for i := 0; i < 100; i++ {
+			if got := p.Backoff(tc.attempt); got < tc.min || got > tc.max {
+				t.Fatalf("Backoff(%d) = %s, want from %s to %s", tc.attempt, got, tc.min, tc.max)
+			}
+		}
+	}
+}
+
+func TestRetryPolicyMinDuration(t *testing.T) {
+	p := account.NewRetryPolicy(account.WithRetryAttempts(3), account.WithRetryBackoff(100*time.Millisecond, 300*time.Millisecond))
+	// The shortest backoffs are 50ms, 100ms, and 150ms.
+	if got := p.MinDuration(); got != 300*time.Millisecond {
+		t.Errorf("MinDuration() = %s, want 300ms", got)
+	}
+	if got := account.NewRetryPolicy(account.WithRetryAttempts(0)).MinDuration(); got != 0 {
+		t.Errorf("MinDuration() = %s, want 0 when retries are disabled", got)
+	}
+}
+
+func TestRetryPolicyDefault(t *testing.T) {
+	// A server which reads a single partition can only retry in-process,
+	// so the default policy must outlast Postgres restarts and failovers which take a few seconds.
+	const outage = 10 * time.Second
+	if got := account.NewRetryPolicy().MinDuration(); got < outage {
+		t.Errorf("MinDuration() = %s, the default policy must retry for at least %s", got, outage)
+	}
+}
+
+// flakyID returns ID generator which fails with a transient error the given number of times.
+func flakyID(failures int, id string) func() (string, error) {
+	return func() (string, error) {
+		if failures > 0 {
+			failures--
+			return "", &account.TransientError{Err: errors.New("connection lost")}
+		}
+		return id, nil
+	}
+}
+
+// retrierFunc is an account.Retrier which calls the function.
+type retrierFunc func(ctx context.Context, req *account.SignupRequest, cause error) error
+
+func (f retrierFunc) Retry(ctx context.Context, req *account.SignupRequest, cause error) error {
+	return f(ctx, req, cause)
+}
+
+func TestHandleRetry(t *testing.T) {
+	users := memory.NewUserService()
+	var retried int
+	p := account.NewProcessor(users, memory.NewSignupService(), flakyID(2, "0ujzPyRiIAffKhBux4PvQdDqMHY"),
+		account.WithRetryPolicy(account.NewRetryPolicy(
+			account.WithRetryAttempts(2),
+			account.WithRetryBackoff(time.Millisecond, time.Millisecond),
+		)),
+		account.WithRetryHook(func(req *account.SignupRequest, err error) {
+			retried++
+		}),
+	)
+
+	ctx := context.Background()
+	req := account.SignupRequest{ID: "13rUw7cUfrGO9Go9xbZearzuuAu", Username: "bob"}
+	if err := p.Handle(ctx, &req); err != nil {
+		t.Fatalf("Handle(%+v) = %v, the request must be retried", req, err)
+	}
+	if retried != 2 {
+		t.Errorf("Handle(%+v) retried %d times, want 2", req, retried)
+	}
+	if _, err := users.ByUsername(ctx, "bob"); err != nil {
+		t.Errorf("Handle(%+v) must claim username after retries: %v", req, err)
+	}
+}
+
+func TestHandleRetrier(t *testing.T) {
+	var postponed []account.SignupRequest
+	retrier := retrierFunc(func(ctx context.Context, req *account.SignupRequest, cause error) error {
+		if req.Retries > 0 {
+			return cause
+		}
+		postponed = append(postponed, *req)
+		return nil
+	})
+	p := account.NewProcessor(memory.NewUserService(), memory.NewSignupService(), flakyID(100, "0ujzPyRiIAffKhBux4PvQdDqMHY"),
+		account.WithRetryPolicy(account.NewRetryPolicy(
+			account.WithRetryAttempts(1),
+			account.WithRetryBackoff(time.Millisecond, time.Millisecond),
+		)),
+		account.WithRetrier(retrier),
+	)
+
+	ctx := context.Background()
+	req := account.SignupRequest{ID: "13rUw7cUfrGO9Go9xbZearzuuAu", Username: "bob"}
+	if err := p.Handle(ctx, &req); err != nil {
+		t.Fatalf("Handle(%+v) = %v, the request must be postponed", req, err)
+	}
+	if len(postponed) != 1 || postponed[0] != req {
+		t.Errorf("Handle(%+v) postponed %+v", req, postponed)
+	}
+
+	// The request can't be postponed anymore, so the transient error stops the processor.
+	req.Retries = 1
+	if err := p.Handle(ctx, &req); !account.IsTransient(err) {
+		t.Errorf("Handle(%+v) = %v, want transient error", req, err)
+	}
+}
@@ -0,0 +1,133 @@
+// Package rpc implements gRPC SignupService defined in signup.proto on top of account.SignupService.
+// Internal services can sign up users and stream signup responses without talking to Kafka.
+package rpc
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative signup.proto
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/marselester/distributed-signup"
+	"github.com/marselester/distributed-signup/client"
+	"github.com/marselester/distributed-signup/memory"
+)
+
+// streamBuffer is a number of responses a stream can fall behind the listener before it is closed.
+const streamBuffer = 100
+
+// Server implements SignupServiceServer. Signup requests are sent and their responses are received
+// by client.Client, so the server shares one response listener with the other users of the client.
+// Make sure the client's Listen is running, otherwise Signup requests never get their responses.
+type Server struct {
+	UnimplementedSignupServiceServer
+
+	client *client.Client
+	// responses keeps the responses received by the listener, so their status can be looked up.
+	responses *memory.DedupStore
+}
+
+// NewServer returns a Server which sends signup requests using the client c
+// and remembers responses during ttl (0 is forever).
+func NewServer(c *client.Client, ttl time.Duration) *Server {
+	s := Server{
+		client:    c,
+		responses: memory.NewDedupStore(0, ttl),
+	}
+	c.Subscribe(func(resp *account.SignupResponse) {
+		req := account.SignupRequest{ID: resp.RequestID, Username: resp.Username}
+		// A replayed request has the same response which is already recorded.
+		s.responses.SaveResponse(context.Background(), &req, resp)
+	})
+	return &s
+}
+
+// Signup sends a signup request and waits for the response.
+func (s *Server) Signup(ctx context.Context, in *SignupRequest) (*SignupResponse, error) {
+	if in.Username == "" {
+		return nil, status.Error(codes.InvalidArgument, "username is required")
+	}
+	if len(in.RequestId) > account.MaxRequestIDLength {
+		return nil, status.Errorf(codes.InvalidArgument, "request_id must not be longer than %d bytes", account.MaxRequestIDLength)
+	}
+
+	var (
+		resp *account.SignupResponse
+		err  error
+	)
+	if in.RequestId == "" {
+		resp, err = s.client.Signup(ctx, in.Username)
+	} else {
+		resp, err = s.client.Send(ctx, &account.SignupRequest{ID: in.RequestId, Username: in.Username})
+	}
+	switch {
+	case err == client.ErrTimeout:
+		return nil, status.Error(codes.DeadlineExceeded, err.Error())
+	case err == context.Canceled || err == context.DeadlineExceeded:
+		return nil, status.FromContextError(err).Err()
+	case err != nil:
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	return fromResponse(resp), nil
+}
+
+// GetSignupStatus returns a response to the signup request ID.
+func (s *Server) GetSignupStatus(ctx context.Context, in *GetSignupStatusRequest) (*SignupResponse, error) {
+	resp, err := s.responses.Response(ctx, in.RequestId)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	return fromResponse(resp), nil
+}
+
+// StreamResponses streams signup responses as they arrive until the client cancels the stream.
+// The header is sent once the stream is subscribed, so a client can wait for it not to miss the following responses.
+// The stream is closed if the client can't keep up with the responses.
+func (s *Server) StreamResponses(in *StreamResponsesRequest, stream SignupService_StreamResponsesServer) error {
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+
+	// The listener passes responses through the buffer, so a slow stream doesn't block it.
+	responses := make(chan *account.SignupResponse, streamBuffer)
+	unsubscribe := s.client.Subscribe(func(resp *account.SignupResponse) {
+		select {
+		case responses <- resp:
+		default:
+			cancel()
+		}
+	})
+	defer unsubscribe()
+	if err := stream.SendHeader(nil); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case resp := <-responses:
+			if err := stream.Send(fromResponse(resp)); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			if stream.Context().Err() != nil {
+				return nil
+			}
+			return status.Error(codes.ResourceExhausted, "stream fell behind signup responses")
+		}
+	}
+}
+
+// fromResponse converts account.SignupResponse to its protobuf message.
+func fromResponse(resp *account.SignupResponse) *SignupResponse {
+	return &SignupResponse{
+		RequestId:  resp.RequestID,
+		Username:   resp.Username,
+		Success:    resp.Success,
+		Partition:  resp.Partition,
+		SequenceId: resp.SequenceID,
+		Reason:     string(resp.FailureReason()),
+		Message:    resp.Message,
+	}
+}
@@ -0,0 +1,181 @@
+package rpc_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/marselester/distributed-signup"
+	"github.com/marselester/distributed-signup/client"
+	"github.com/marselester/distributed-signup/memory"
+	"github.com/marselester/distributed-signup/rpc"
+)
+
+// singleReader is a SignupService which fails if responses are read concurrently,
+// like Kafka consumer which can't consume the same partition twice.
+type singleReader struct {
+	account.SignupService
+	reading int32
+}
+
+func (r *singleReader) Responses(ctx context.Context, f func(*account.SignupResponse)) error {
+	if !atomic.CompareAndSwapInt32(&r.reading, 0, 1) {
+		return errors.New("signup responses are already being read")
+	}
+	defer atomic.StoreInt32(&r.reading, 0)
+	return r.SignupService.Responses(ctx, f)
+}
+
+// mustDial starts the gRPC server in-process and returns a client connected to it.
+// Bob's signup requests are processed if serve is true.
+func mustDial(ctx context.Context, t *testing.T, serve bool) rpc.SignupServiceClient {
+	b := memory.NewBroker(3)
+	if serve {
+		// Bob's requests are stored in the partition 2.
+		server := memory.NewSignupService(
+			memory.WithBroker(b),
+			memory.WithRequestPartition(2),
+			memory.WithRequestOffset(memory.OffsetOldest),
+		)
+		newID := func() (string, error) { return "0ujzPyRiIAffKhBux4PvQdDqMHY", nil }
+		p := account.NewProcessor(memory.NewUserService(), server, newID)
+		go p.Run(ctx)
+	}
+
+	c := client.New(&singleReader{SignupService: memory.NewSignupService(
+		memory.WithBroker(b),
+		memory.WithResponseOffset(memory.OffsetOldest),
+	)}, client.WithTimeout(100*time.Millisecond))
+	go func() {
+		if err := c.Listen(ctx); err != nil {
+			t.Error(err)
+		}
+	}()
+	srv := rpc.NewServer(c, time.Hour)
+
+	lis := bufconn.Listen(1024 * 1024)
+	s := grpc.NewServer()
+	rpc.RegisterSignupServiceServer(s, srv)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return rpc.NewSignupServiceClient(conn)
+}
+
+func TestSignup(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := mustDial(ctx, t, true)
+
+	tests := []struct {
+		success bool
+		reason  string
+	}{
+		{success: true},
+		{success: false, reason: "taken"},
+	}
+	for _, tc := range tests {
+		want := tc.success
+		resp, err := c.Signup(ctx, &rpc.SignupRequest{Username: "bob"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Username != "bob" || resp.Success != want || resp.RequestId == "" || resp.Reason != tc.reason {
+			t.Errorf("Signup(bob) = %+v, want success %t reason %q", resp, want, tc.reason)
+		}
+
+		status, err := c.GetSignupStatus(ctx, &rpc.GetSignupStatusRequest{RequestId: resp.RequestId})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.Success != want {
+			t.Errorf("GetSignupStatus(%s) = %+v, want success %t", resp.RequestId, status, want)
+		}
+	}
+}
+
+func TestSignupErrors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// No server processes the requests.
+	c := mustDial(ctx, t, false)
+
+	_, err := c.Signup(ctx, &rpc.SignupRequest{})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Signup() = %v, want InvalidArgument", err)
+	}
+	_, err = c.Signup(ctx, &rpc.SignupRequest{Username: "bob", RequestId: strings.Repeat("x", 65)})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Signup() = %v, want InvalidArgument", err)
+	}
+	_, err = c.Signup(ctx, &rpc.SignupRequest{Username: "bob", RequestId: "13rUw7cUfrGO9Go9xbZearzuuAu"})
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("Signup(bob) = %v, want DeadlineExceeded", err)
+	}
+	_, err = c.GetSignupStatus(ctx, &rpc.GetSignupStatusRequest{RequestId: "13rUw7cUfrGO9Go9xbZearzuuAu"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("GetSignupStatus(13rUw7cUfrGO9Go9xbZearzuuAu) = %v, want NotFound", err)
+	}
+}
+
+func TestStreamResponses(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := mustDial(ctx, t, true)
+
+	// Streams share the listener with Signup and GetSignupStatus.
+	var streams []rpc.SignupService_StreamResponsesClient
+	for i := 0; i < 2; i++ {
+		stream, err := c.StreamResponses(ctx, &rpc.StreamResponsesRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The header indicates that the stream is subscribed to responses.
+		if _, err = stream.Header(); err != nil {
+			t.Fatal(err)
+		}
+		streams = append(streams, stream)
+	}
+
+	ids := []string{"13rUw7cUfrGO9Go9xbZearzuuAu", "13rVCgpmD0UgKH6zNHdfcPG63Df"}
+	for _, id := range ids {
+		if _, err := c.Signup(ctx, &rpc.SignupRequest{Username: "bob", RequestId: id}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.GetSignupStatus(ctx, &rpc.GetSignupStatusRequest{RequestId: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, stream := range streams {
+		for i, id := range ids {
+			resp, err := stream.Recv()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.RequestId != id || resp.Partition != 2 || resp.SequenceId != int64(i) {
+				t.Errorf("StreamResponses() = %+v, want %s at 2:%d", resp, id, i)
+			}
+		}
+	}
+}
@@ -0,0 +1,201 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v4.25.0
+// source: signup.proto
+
+package rpc
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	SignupService_Signup_FullMethodName          = "/signup.SignupService/Signup"
+	SignupService_GetSignupStatus_FullMethodName = "/signup.SignupService/GetSignupStatus"
+	SignupService_StreamResponses_FullMethodName = "/signup.SignupService/StreamResponses"
+)
+
+// SignupServiceClient is the client API for SignupService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type SignupServiceClient interface {
+	Signup(ctx context.Context, in *SignupRequest, opts ...grpc.CallOption) (*SignupResponse, error)
+	GetSignupStatus(ctx context.Context, in *GetSignupStatusRequest, opts ...grpc.CallOption) (*SignupResponse, error)
+	StreamResponses(ctx context.Context, in *StreamResponsesRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[SignupResponse], error)
+}
+
+type signupServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewSignupServiceClient(cc grpc.ClientConnInterface) SignupServiceClient {
+	return &signupServiceClient{cc}
+}
+
+func (c *signupServiceClient) Signup(ctx context.Context, in *SignupRequest, opts ...grpc.CallOption) (*SignupResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SignupResponse)
+	err := c.cc.Invoke(ctx, SignupService_Signup_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *signupServiceClient) GetSignupStatus(ctx context.Context, in *GetSignupStatusRequest, opts ...grpc.CallOption) (*SignupResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SignupResponse)
+	err := c.cc.Invoke(ctx, SignupService_GetSignupStatus_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *signupServiceClient) StreamResponses(ctx context.Context, in *StreamResponsesRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[SignupResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &SignupService_ServiceDesc.Streams[0], SignupService_StreamResponses_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[StreamResponsesRequest, SignupResponse]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type SignupService_StreamResponsesClient = grpc.ServerStreamingClient[SignupResponse]
+
+// SignupServiceServer is the server API for SignupService service.
+// All implementations must embed UnimplementedSignupServiceServer
+// for forward compatibility.
+type SignupServiceServer interface {
+	Signup(context.Context, *SignupRequest) (*SignupResponse, error)
+	GetSignupStatus(context.Context, *GetSignupStatusRequest) (*SignupResponse, error)
+	StreamResponses(*StreamResponsesRequest, grpc.ServerStreamingServer[SignupResponse]) error
+	mustEmbedUnimplementedSignupServiceServer()
+}
+
+// UnimplementedSignupServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedSignupServiceServer struct{}
+
+func (UnimplementedSignupServiceServer) Signup(context.Context, *SignupRequest) (*SignupResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Signup not implemented")
+}
+func (UnimplementedSignupServiceServer) GetSignupStatus(context.Context, *GetSignupStatusRequest) (*SignupResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetSignupStatus not implemented")
+}
+func (UnimplementedSignupServiceServer) StreamResponses(*StreamResponsesRequest, grpc.ServerStreamingServer[SignupResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method StreamResponses not implemented")
+}
+func (UnimplementedSignupServiceServer) mustEmbedUnimplementedSignupServiceServer() {}
+func (UnimplementedSignupServiceServer) testEmbeddedByValue()                       {}
+
+// UnsafeSignupServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to SignupServiceServer will
+// result in compilation errors.
+type UnsafeSignupServiceServer interface {
+	mustEmbedUnimplementedSignupServiceServer()
+}
+
+func RegisterSignupServiceServer(s grpc.ServiceRegistrar, srv SignupServiceServer) {
+	// If the following call pancis, it indicates UnimplementedSignupServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&SignupService_ServiceDesc, srv)
+}
+
+func _SignupService_Signup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SignupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SignupServiceServer).Signup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SignupService_Signup_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SignupServiceServer).Signup(ctx, req.(*SignupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SignupService_GetSignupStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetSignupStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SignupServiceServer).GetSignupStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SignupService_GetSignupStatus_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SignupServiceServer).GetSignupStatus(ctx, req.(*GetSignupStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SignupService_StreamResponses_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamResponsesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(SignupServiceServer).StreamResponses(m, &grpc.GenericServerStream[StreamResponsesRequest, SignupResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type SignupService_StreamResponsesServer = grpc.ServerStreamingServer[SignupResponse]
+
+// SignupService_ServiceDesc is the grpc.ServiceDesc for SignupService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var SignupService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "signup.SignupService",
+	HandlerType: (*SignupServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Signup",
+			Handler:    _SignupService_Signup_Handler,
+		},
+		{
+			MethodName: "GetSignupStatus",
+			Handler:    _SignupService_GetSignupStatus_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamResponses",
+			Handler:       _SignupService_StreamResponses_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "signup.proto",
+}
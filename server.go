@@ -0,0 +1,31 @@
+package account
+
+import (
+	"context"
+	"time"
+)
+
+// NotPaused is the PausedEpoch of a server which processes signup requests.
+const NotPaused = -1
+
+// Server is a signup-server which registers itself in the shards it uses,
+// so a migration can tell whether the servers follow the shard map and whether they have paused.
+type Server struct {
+	ID string
+	// Group indicates that the server is in consumer-group mode, so it follows the shard map.
+	// A server which reads a single partition ignores the map and can't be paused.
+	Group bool
+	// PausedEpoch is the epoch of the paused shard map the server acknowledged
+	// after its requests in progress were processed, or NotPaused.
+	PausedEpoch int
+	// SeenAt is when the server registered itself the last time.
+	SeenAt time.Time
+}
+
+// ServerRegistry keeps track of the signup-servers which use a shard, e.g., pg.UserService.
+type ServerRegistry interface {
+	// RegisterServer records the server's state and the time it was seen.
+	RegisterServer(ctx context.Context, s *Server) error
+	// Servers returns the servers seen since the given time.
+	Servers(ctx context.Context, since time.Time) ([]Server, error)
+}
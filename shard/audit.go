@@ -0,0 +1,102 @@
+package shard
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/marselester/distributed-signup"
+)
+
+// Placement is a user account stored in a shard.
+type Placement struct {
+	Shard    string `json:"shard"`
+	ID       string `json:"id"`
+	Username string `json:"username"`
+}
+
+// Misplaced is a user account stored in a shard which doesn't own the username's partition.
+type Misplaced struct {
+	Placement
+	// Owner is the shard which owns the username's partition.
+	Owner string `json:"owner"`
+}
+
+// Duplicate is a username or a user ID which is stored more than once across the shards.
+type Duplicate struct {
+	Key      string      `json:"key"`
+	Accounts []Placement `json:"accounts"`
+}
+
+// AuditReport describes the violations of username uniqueness found across the shards.
+type AuditReport struct {
+	Accounts           int         `json:"accounts"`
+	Misplaced          []Misplaced `json:"misplaced"`
+	DuplicateUsernames []Duplicate `json:"duplicate_usernames"`
+	DuplicateIDs       []Duplicate `json:"duplicate_ids"`
+}
+
+// OK reports whether no violations were found.
+func (r *AuditReport) OK() bool {
+	return len(r.Misplaced) == 0 && len(r.DuplicateUsernames) == 0 && len(r.DuplicateIDs) == 0
+}
+
+// Audit reads user accounts of all the shards of the map m and reports usernames stored in the shards
+// which don't own their partitions, usernames stored in more than one shard, and user IDs duplicated across shards.
+// Usernames are assigned partitions by the partitioner p, see kafka.WithPartitioner.
+// The stores are keyed by shard name. Note, usernames and IDs of all the accounts are kept in memory.
+//
+// Usernames are compared and partitioned in the normalized form, see account.NormalizeUsername,
+// so spellings of the same username such as Bob and bob are reported as duplicates.
+// Accounts stored verbatim before usernames were normalized might be reported as misplaced,
+// because they were partitioned by the username as it was requested.
+func Audit(ctx context.Context, m *Map, p account.Partitioner, stores map[string]Store) (*AuditReport, error) {
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+
+	var r AuditReport
+	byUsername := make(map[string][]Placement)
+	byID := make(map[string][]Placement)
+	for _, s := range m.Shards {
+		store := stores[s.Name]
+		if store == nil {
+			return nil, fmt.Errorf("shard: no store for %s", s.Name)
+		}
+
+		err := store.Users(ctx, func(u *account.User) error {
+			r.Accounts++
+			pl := Placement{Shard: s.Name, ID: u.ID, Username: u.Username}
+			name := account.NormalizeUsername(u.Username)
+			byUsername[name] = append(byUsername[name], pl)
+			byID[u.ID] = append(byID[u.ID], pl)
+
+			owner := m.byPartition[p.Partition(name, m.Partitions())].Name
+			if owner != s.Name {
+				r.Misplaced = append(r.Misplaced, Misplaced{Placement: pl, Owner: owner})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("shard %s: %v", s.Name, err)
+		}
+	}
+
+	r.DuplicateUsernames = duplicates(byUsername)
+	r.DuplicateIDs = duplicates(byID)
+	return &r, nil
+}
+
+// duplicates returns the keys which have more than one account sorted by key.
+func duplicates(accounts map[string][]Placement) []Duplicate {
+	var dd []Duplicate
+	for key, pp := range accounts {
+		if len(pp) > 1 {
+			dd = append(dd, Duplicate{Key: key, Accounts: pp})
+		}
+	}
+	sort.Slice(dd, func(i, j int) bool {
+		return dd[i].Key < dd[j].Key
+	})
+	return dd
+}
@@ -0,0 +1,100 @@
+package shard_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/marselester/distributed-signup"
+	"github.com/marselester/distributed-signup/memory"
+	"github.com/marselester/distributed-signup/shard"
+)
+
+// mustAudit audits three shards which have the given users.
+func mustAudit(t *testing.T, users map[string][]account.User) *shard.AuditReport {
+	m, err := shard.ReadMap(strings.NewReader(`{"shards": [
+		{"name": "account-0", "partitions": [0]},
+		{"name": "account-1", "partitions": [1]},
+		{"name": "account-2", "partitions": [2]}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	stores := make(map[string]shard.Store)
+	for _, s := range m.Shards {
+		store := memory.NewUserService()
+		for _, u := range users[s.Name] {
+			if err = store.CreateUser(ctx, &u); err != nil {
+				t.Fatal(err)
+			}
+		}
+		stores[s.Name] = store
+	}
+
+	r, err := shard.Audit(ctx, m, account.FNV1aPartitioner{}, stores)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestAudit(t *testing.T) {
+	r := mustAudit(t, map[string][]account.User{
+		"account-0": {{ID: "0ujsswThIGTUYm2K8FjOOfXtY1K", Username: "lloyd"}},
+		"account-1": {{ID: "0ujtsYcgvSTl8PAuAdqWYSMnLOv", Username: "peter"}},
+		"account-2": {{ID: "0ujzPyRiIAffKhBux4PvQdDqMHY", Username: "bob"}},
+	})
+	if !r.OK() || r.Accounts != 3 {
+		t.Errorf("Audit() = %+v, want 3 accounts without violations", r)
+	}
+}
+
+func TestAuditViolations(t *testing.T) {
+	// Bob's partition is 2, so his account in account-0 is misplaced, and the username is claimed twice.
+	// Peter has the same ID as bob in account-2.
+	r := mustAudit(t, map[string][]account.User{
+		"account-0": {{ID: "0ujsswThIGTUYm2K8FjOOfXtY1K", Username: "bob"}},
+		"account-1": {{ID: "0ujzPyRiIAffKhBux4PvQdDqMHY", Username: "peter"}},
+		"account-2": {{ID: "0ujzPyRiIAffKhBux4PvQdDqMHY", Username: "bob"}},
+	})
+	if r.OK() {
+		t.Fatalf("Audit() = %+v, must report violations", r)
+	}
+
+	want := shard.Misplaced{
+		Placement: shard.Placement{Shard: "account-0", ID: "0ujsswThIGTUYm2K8FjOOfXtY1K", Username: "bob"},
+		Owner:     "account-2",
+	}
+	if len(r.Misplaced) != 1 || r.Misplaced[0] != want {
+		t.Errorf("Audit() misplaced = %+v, want %+v", r.Misplaced, want)
+	}
+	if len(r.DuplicateUsernames) != 1 || r.DuplicateUsernames[0].Key != "bob" || len(r.DuplicateUsernames[0].Accounts) != 2 {
+		t.Errorf("Audit() duplicate usernames = %+v, want bob in account-0 and account-2", r.DuplicateUsernames)
+	}
+	if len(r.DuplicateIDs) != 1 || r.DuplicateIDs[0].Key != "0ujzPyRiIAffKhBux4PvQdDqMHY" {
+		t.Errorf("Audit() duplicate IDs = %+v, want 0ujzPyRiIAffKhBux4PvQdDqMHY", r.DuplicateIDs)
+	}
+}
+
+func TestAuditNormalized(t *testing.T) {
+	// Bob's normalized partition is 2, so " Bob" is in place, though "Bob" stored verbatim in account-0
+	// before usernames were normalized is misplaced, and both are spellings of the same username.
+	r := mustAudit(t, map[string][]account.User{
+		"account-0": {{ID: "0ujsswThIGTUYm2K8FjOOfXtY1K", Username: "Bob"}},
+		"account-1": {{ID: "0ujtsYcgvSTl8PAuAdqWYSMnLOv", Username: "PETER"}},
+		"account-2": {{ID: "0ujzPyRiIAffKhBux4PvQdDqMHY", Username: " Bob"}},
+	})
+
+	want := shard.Misplaced{
+		Placement: shard.Placement{Shard: "account-0", ID: "0ujsswThIGTUYm2K8FjOOfXtY1K", Username: "Bob"},
+		Owner:     "account-2",
+	}
+	if len(r.Misplaced) != 1 || r.Misplaced[0] != want {
+		t.Errorf("Audit() misplaced = %+v, want %+v", r.Misplaced, want)
+	}
+	if len(r.DuplicateUsernames) != 1 || r.DuplicateUsernames[0].Key != "bob" || len(r.DuplicateUsernames[0].Accounts) != 2 {
+		t.Errorf("Audit() duplicate usernames = %+v, want Bob in account-0 and account-2", r.DuplicateUsernames)
+	}
+}
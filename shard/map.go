@@ -0,0 +1,151 @@
+// Package shard maps partitions of account.signup_request topic to PostgreSQL shards which own them.
+// A username is stored in the shard which owns the username's partition,
+// so a signup-server has to know the shard before it processes a partition's requests.
+package shard
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/marselester/distributed-signup"
+)
+
+// ErrShardNotFound error indicates that no shard owns a partition.
+const ErrShardNotFound = account.Error("shard not found")
+
+// Shard is a PostgreSQL database which stores user accounts of the given partitions.
+type Shard struct {
+	// Name identifies the shard, e.g., account-0.
+	Name       string  `json:"name"`
+	Host       string  `json:"host"`
+	Port       uint16  `json:"port"`
+	Database   string  `json:"database"`
+	Partitions []int32 `json:"partitions"`
+}
+
+// Map is a mapping of partitions to shards. Every partition is owned by exactly one shard.
+type Map struct {
+	// Epoch is incremented every time usernames are moved between shards, see Migration.
+	Epoch int `json:"epoch"`
+	// Paused indicates that the migration to the next epoch is in progress,
+	// so signup requests must not be processed until the map is switched.
+	Paused bool    `json:"paused,omitempty"`
+	Shards []Shard `json:"shards"`
+
+	// byPartition indexes Shards by partitions, it is built by validate.
+	byPartition map[int32]*Shard
+}
+
+// ReadMap decodes a JSON mapping from r and validates it, so the shards own partitions from 0 to N-1
+// without gaps or overlaps, for example:
+//
+//	{"epoch": 0, "shards": [
+//		{"name": "account-0", "host": "localhost", "port": 5433, "database": "account", "partitions": [0]},
+//		{"name": "account-1", "host": "localhost", "port": 5434, "database": "account", "partitions": [1, 2]}
+//	]}
+func ReadMap(r io.Reader) (*Map, error) {
+	var m Map
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return nil, err
+	}
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// OpenMap reads a JSON mapping from the file, see ReadMap.
+func OpenMap(path string) (*Map, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadMap(f)
+}
+
+// SaveMap writes the JSON mapping to the file. The file is replaced atomically,
+// so the servers watching the file never read a partially written map.
+func SaveMap(path string, m *Map) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err = f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Partitions returns the number of partitions owned by the shards.
+func (m *Map) Partitions() int32 {
+	var n int32
+	for _, s := range m.Shards {
+		n += int32(len(s.Partitions))
+	}
+	return n
+}
+
+// validate checks that the shards own all the partitions of the topic, i.e., from 0 to the number of partitions,
+// without overlaps, and indexes the shards by partitions.
+// A map is validated by ReadMap and by the services using it, so maps built in code don't need a constructor.
+func (m *Map) validate() error {
+	names := make(map[string]bool)
+	byPartition := make(map[int32]*Shard)
+	for i := range m.Shards {
+		s := &m.Shards[i]
+		if names[s.Name] {
+			return fmt.Errorf("shard: duplicate shard name %q", s.Name)
+		}
+		names[s.Name] = true
+
+		for _, p := range s.Partitions {
+			if p < 0 {
+				return fmt.Errorf("shard: %s has negative partition %d", s.Name, p)
+			}
+			if owner, ok := byPartition[p]; ok {
+				return fmt.Errorf("shard: partition %d is owned by %s and %s", p, owner.Name, s.Name)
+			}
+			byPartition[p] = s
+		}
+	}
+	m.byPartition = byPartition
+
+	n := m.Partitions()
+	if n == 0 {
+		return fmt.Errorf("shard: no partitions")
+	}
+	for p := int32(0); p < n; p++ {
+		if _, ok := m.byPartition[p]; !ok {
+			return fmt.Errorf("shard: partition %d is not owned by any shard", p)
+		}
+	}
+	return nil
+}
+
+// Shard returns the shard which owns the partition or ErrShardNotFound.
+// A map which wasn't read by ReadMap is validated on the first call, and ErrShardNotFound is returned if it is invalid.
+func (m *Map) Shard(partition int32) (*Shard, error) {
+	if m.byPartition == nil && m.validate() != nil {
+		return nil, ErrShardNotFound
+	}
+	s, ok := m.byPartition[partition]
+	if !ok {
+		return nil, ErrShardNotFound
+	}
+	return s, nil
+}
@@ -0,0 +1,114 @@
+package shard_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/marselester/distributed-signup"
+	"github.com/marselester/distributed-signup/memory"
+	"github.com/marselester/distributed-signup/shard"
+)
+
+func TestReadMap(t *testing.T) {
+	m, err := shard.ReadMap(strings.NewReader(`{"shards": [
+		{"name": "account-0", "host": "localhost", "port": 5433, "database": "account", "partitions": [0]},
+		{"name": "account-1", "host": "localhost", "port": 5434, "database": "account", "partitions": [1, 2]}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[int32]string{0: "account-0", 1: "account-1", 2: "account-1"}
+	for p, want := range tests {
+		s, err := m.Shard(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Name != want {
+			t.Errorf("Shard(%d) = %s, want %s", p, s.Name, want)
+		}
+	}
+
+	if _, err = m.Shard(3); err != shard.ErrShardNotFound {
+		t.Errorf("Shard(3) = %v, must be ErrShardNotFound", err)
+	}
+}
+
+func TestMapLiteral(t *testing.T) {
+	m := shard.Map{Shards: []shard.Shard{
+		{Name: "account-0", Partitions: []int32{0}},
+		{Name: "account-1", Partitions: []int32{1, 2}},
+	}}
+	if got := m.Partitions(); got != 3 {
+		t.Errorf("Partitions() = %d, want 3", got)
+	}
+	s, err := m.Shard(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Name != "account-1" {
+		t.Errorf("Shard(2) = %s, want account-1", s.Name)
+	}
+
+	users := map[string]account.UserService{
+		"account-0": memory.NewUserService(),
+		"account-1": memory.NewUserService(),
+	}
+	if _, err = shard.NewUserService(&shard.Map{Shards: m.Shards}, account.FNV1aPartitioner{}, users); err != nil {
+		t.Errorf("NewUserService() = %v, want a map literal to be valid", err)
+	}
+}
+
+func TestReadMapInvalid(t *testing.T) {
+	tests := map[string]string{
+		"duplicate partition": `{"shards": [{"name": "account-0", "partitions": [0]}, {"name": "account-1", "partitions": [0]}]}`,
+		"duplicate name":      `{"shards": [{"name": "account-0", "partitions": [0]}, {"name": "account-0", "partitions": [1]}]}`,
+		"negative partition":  `{"shards": [{"name": "account-0", "partitions": [-1]}]}`,
+		"partition gap":       `{"shards": [{"name": "account-0", "partitions": [0]}, {"name": "account-1", "partitions": [2]}]}`,
+		"no partitions":       `{"shards": [{"name": "account-0", "partitions": []}]}`,
+		"malformed":           `{"shards": [`,
+	}
+	for name, mapping := range tests {
+		if _, err := shard.ReadMap(strings.NewReader(mapping)); err == nil {
+			t.Errorf("ReadMap(%s) must fail", name)
+		}
+	}
+}
+
+func TestSaveMap(t *testing.T) {
+	want, err := shard.ReadMap(strings.NewReader(`{"epoch": 3, "paused": true, "shards": [
+		{"name": "account-0", "host": "localhost", "port": 5433, "database": "account", "partitions": [0]},
+		{"name": "account-1", "host": "localhost", "port": 5434, "database": "account", "partitions": [1, 2]}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "shard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "shards.json")
+	if err = shard.SaveMap(path, want); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := shard.OpenMap(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Epoch != 3 || !got.Paused || got.Partitions() != 3 {
+		t.Errorf("SaveMap() = epoch %d paused %t partitions %d, want epoch 3 paused true partitions 3", got.Epoch, got.Paused, got.Partitions())
+	}
+	s, err := got.Shard(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Name != "account-1" || s.Port != 5434 {
+		t.Errorf("SaveMap() shard of partition 2 = %+v", s)
+	}
+}
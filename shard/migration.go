@@ -0,0 +1,227 @@
+package shard
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/marselester/distributed-signup"
+)
+
+// ErrMigrationConflict error indicates that a moved username is already claimed by another user in the target shard,
+// e.g., the same username was signed up in two shards while producers and servers disagreed on partitions.
+const ErrMigrationConflict = account.Error("username is claimed in both shards")
+
+// Store is a user service of a shard which can list and delete user accounts,
+// so they can be moved to another shard, e.g., pg.UserService.
+type Store interface {
+	account.UserService
+	// Users passes all the users to f until f returns an error.
+	Users(ctx context.Context, f func(*account.User) error) error
+	// DeleteUser deletes the user or returns account.ErrUserNotFound.
+	DeleteUser(ctx context.Context, u *account.User) error
+}
+
+// ResponseStore is an optional interface implemented by a Store which records responses to signup requests
+// to deduplicate them, e.g., pg.UserService. The responses are moved along with the accounts,
+// so the requests sent again with the same IDs get the same responses from the new shards.
+type ResponseStore interface {
+	// Responses passes all the recorded responses and their requests to f until f returns an error.
+	Responses(ctx context.Context, f func(req *account.SignupRequest, resp *account.SignupResponse) error) error
+	// Response returns a response recorded for the signup request ID or account.ErrResponseNotFound.
+	Response(ctx context.Context, requestID string) (*account.SignupResponse, error)
+	// CopyResponse records the response moved from another shard. Unlike account.DedupStore.SaveResponse,
+	// it doesn't change the latest processed offsets of the partitions.
+	// It returns account.ErrDuplicateRequest if a response to the request ID has been already recorded.
+	CopyResponse(ctx context.Context, req *account.SignupRequest, resp *account.SignupResponse) error
+	// DeleteResponse deletes the response to the signup request ID or returns account.ErrResponseNotFound.
+	DeleteResponse(ctx context.Context, requestID string) error
+}
+
+// Move is a user account which is moved from one shard to another.
+type Move struct {
+	User account.User
+	From string
+	To   string
+}
+
+// ResponseMove is a response to a signup request which is moved from one shard to another.
+type ResponseMove struct {
+	Request  account.SignupRequest
+	Response account.SignupResponse
+	From     string
+	To       string
+}
+
+// Report describes a migration: how many user accounts are stored in the shards and which of them move.
+type Report struct {
+	Accounts int
+	Moves    []Move
+	// Conflicts are the moves which failed because the username is claimed by another user in the target shard.
+	Conflicts []Move
+	// Responses are the recorded responses to signup requests which move, see ResponseStore.
+	Responses []ResponseMove
+}
+
+// Migration moves user accounts between shards when the number of partitions of account.signup_request topic changes,
+// so every username is stored in the shard which owns its partition according to the new map.
+// Signup requests must not be processed during the migration, see Map.Paused.
+//
+// A migration is done in steps: Plan finds accounts to move, Copy claims their usernames in the new shards,
+// Verify checks that the new shards have the accounts, and Cleanup deletes them from the old shards
+// once the servers are switched to the new map. Every step can be repeated if it failed.
+// The recorded responses to signup requests take the same steps if the stores implement ResponseStore.
+type Migration struct {
+	from        *Map
+	to          *Map
+	partitioner account.Partitioner
+	stores      map[string]Store
+}
+
+// NewMigration returns a Migration of user accounts from shards of the map from to the shards of the map to.
+// Usernames are assigned partitions by the partitioner p, see kafka.WithPartitioner.
+// The stores are keyed by shard name and must include the shards of both maps.
+// Either all or none of the stores must implement ResponseStore.
+// The new map's epoch must follow the old one, so the servers can tell the maps apart.
+func NewMigration(from, to *Map, p account.Partitioner, stores map[string]Store) (*Migration, error) {
+	if err := from.validate(); err != nil {
+		return nil, err
+	}
+	if err := to.validate(); err != nil {
+		return nil, err
+	}
+	if to.Epoch != from.Epoch+1 {
+		return nil, fmt.Errorf("shard: new map epoch %d must be %d", to.Epoch, from.Epoch+1)
+	}
+	for _, m := range []*Map{from, to} {
+		for _, s := range m.Shards {
+			if stores[s.Name] == nil {
+				return nil, fmt.Errorf("shard: no store for %s", s.Name)
+			}
+		}
+	}
+	// Responses can't be moved partially, otherwise a request sent again would get a different response.
+	responses := 0
+	for _, st := range stores {
+		if _, ok := st.(ResponseStore); ok {
+			responses++
+		}
+	}
+	if responses != 0 && responses != len(stores) {
+		return nil, fmt.Errorf("shard: %d of %d stores record signup responses, responses can't be moved", responses, len(stores))
+	}
+
+	mg := Migration{
+		from:        from,
+		to:          to,
+		partitioner: p,
+		stores:      stores,
+	}
+	return &mg, nil
+}
+
+// Plan reads user accounts of the current shards and reports which of them belong to other shards in the new map.
+// An account belongs to the shard of its normalized username's partition, see account.NormalizeUsername.
+// The recorded responses are planned the same way by their requested usernames,
+// because the servers deduplicate a request in the shard of its username's partition.
+// It doesn't change the shards, so it can be used for a dry run.
+func (mg *Migration) Plan(ctx context.Context) (*Report, error) {
+	var r Report
+	for _, s := range mg.from.Shards {
+		from := s.Name
+		err := mg.stores[from].Users(ctx, func(u *account.User) error {
+			r.Accounts++
+			if to := mg.owner(u.Username); to != from {
+				r.Moves = append(r.Moves, Move{User: *u, From: from, To: to})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("shard %s: %v", from, err)
+		}
+
+		rs, ok := mg.stores[from].(ResponseStore)
+		if !ok {
+			continue
+		}
+		err = rs.Responses(ctx, func(req *account.SignupRequest, resp *account.SignupResponse) error {
+			if to := mg.owner(resp.Username); to != from {
+				r.Responses = append(r.Responses, ResponseMove{Request: *req, Response: *resp, From: from, To: to})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("shard %s: %v", from, err)
+		}
+	}
+	return &r, nil
+}
+
+// owner returns the name of the shard which owns the username in the new map.
+func (mg *Migration) owner(username string) string {
+	return mg.to.byPartition[mg.partitioner.Partition(account.NormalizeUsername(username), mg.to.Partitions())].Name
+}
+
+// Copy claims the usernames of the moved accounts in the new shards keeping the user IDs,
+// and copies the moved responses there.
+// Usernames which are already copied are skipped. If a username is claimed by another user,
+// the move is recorded as a conflict and ErrMigrationConflict is returned once all the accounts are copied.
+func (mg *Migration) Copy(ctx context.Context, r *Report) error {
+	r.Conflicts = nil
+	for _, mv := range r.Moves {
+		u := mv.User
+		created, existing, err := mg.stores[mv.To].ClaimUsername(ctx, &u)
+		if err != nil {
+			return fmt.Errorf("shard %s: %s: %v", mv.To, u.Username, err)
+		}
+		if !created && existing.ID != u.ID {
+			r.Conflicts = append(r.Conflicts, mv)
+		}
+	}
+	for _, mv := range r.Responses {
+		err := mg.stores[mv.To].(ResponseStore).CopyResponse(ctx, &mv.Request, &mv.Response)
+		if err != nil && err != account.ErrDuplicateRequest {
+			return fmt.Errorf("shard %s: response to %s: %v", mv.To, mv.Request.ID, err)
+		}
+	}
+	if len(r.Conflicts) > 0 {
+		return ErrMigrationConflict
+	}
+	return nil
+}
+
+// Verify checks that all the moved accounts and responses are stored in the new shards.
+func (mg *Migration) Verify(ctx context.Context, r *Report) error {
+	for _, mv := range r.Moves {
+		u, err := mg.stores[mv.To].ByUsername(ctx, mv.User.Username)
+		if err != nil {
+			return fmt.Errorf("shard %s: %s: %v", mv.To, mv.User.Username, err)
+		}
+		if *u != mv.User {
+			return fmt.Errorf("shard %s: %s has ID %s, want %s", mv.To, u.Username, u.ID, mv.User.ID)
+		}
+	}
+	for _, mv := range r.Responses {
+		if _, err := mg.stores[mv.To].(ResponseStore).Response(ctx, mv.Request.ID); err != nil {
+			return fmt.Errorf("shard %s: response to %s: %v", mv.To, mv.Request.ID, err)
+		}
+	}
+	return nil
+}
+
+// Cleanup deletes the moved accounts and responses from the old shards.
+// It must be called after the servers are switched to the new map.
+func (mg *Migration) Cleanup(ctx context.Context, r *Report) error {
+	for _, mv := range r.Moves {
+		err := mg.stores[mv.From].DeleteUser(ctx, &mv.User)
+		if err != nil && err != account.ErrUserNotFound {
+			return fmt.Errorf("shard %s: %s: %v", mv.From, mv.User.Username, err)
+		}
+	}
+	for _, mv := range r.Responses {
+		err := mg.stores[mv.From].(ResponseStore).DeleteResponse(ctx, mv.Request.ID)
+		if err != nil && err != account.ErrResponseNotFound {
+			return fmt.Errorf("shard %s: response to %s: %v", mv.From, mv.Request.ID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,300 @@
+package shard_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/marselester/distributed-signup"
+	"github.com/marselester/distributed-signup/memory"
+	"github.com/marselester/distributed-signup/shard"
+)
+
+// Ensure memory.UserService can be moved between shards.
+var _ shard.Store = &memory.UserService{}
+
+// Ensure memory.DedupStore responses can be moved between shards.
+var _ shard.ResponseStore = &memory.DedupStore{}
+
+// dedupStore is a shard which records responses to signup requests like pg.UserService.
+type dedupStore struct {
+	*memory.UserService
+	*memory.DedupStore
+}
+
+// mustMigrate returns a migration from three to four partitions where every shard owns one partition,
+// and the stores of the shards. The current shards have users created by the router of the current map.
+func mustMigrate(t *testing.T, usernames ...string) (*shard.Migration, *shard.Map, map[string]shard.Store) {
+	stores := make(map[string]shard.Store)
+	for _, name := range []string{"account-0", "account-1", "account-2", "account-3"} {
+		stores[name] = memory.NewUserService()
+	}
+	mg, to, _ := mustMigrateStores(t, stores, usernames...)
+	return mg, to, stores
+}
+
+// mustMigrateStores is like mustMigrate, but the shards are backed by the given stores.
+// It also returns the router of the current map.
+func mustMigrateStores(t *testing.T, stores map[string]shard.Store, usernames ...string) (*shard.Migration, *shard.Map, *shard.UserService) {
+	from, err := shard.ReadMap(strings.NewReader(`{"epoch": 1, "shards": [
+		{"name": "account-0", "partitions": [0]},
+		{"name": "account-1", "partitions": [1]},
+		{"name": "account-2", "partitions": [2]}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := shard.ReadMap(strings.NewReader(`{"epoch": 2, "shards": [
+		{"name": "account-0", "partitions": [0]},
+		{"name": "account-1", "partitions": [1]},
+		{"name": "account-2", "partitions": [2]},
+		{"name": "account-3", "partitions": [3]}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	users := make(map[string]account.UserService)
+	for name, s := range stores {
+		users[name] = s
+	}
+	router, err := shard.NewUserService(from, account.FNV1aPartitioner{}, users)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, username := range usernames {
+		u := account.User{ID: "0ujzPyRiIAffKhBux4PvQdDqM" + username[:2], Username: username}
+		if err = router.CreateUser(ctx, &u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mg, err := shard.NewMigration(from, to, account.FNV1aPartitioner{}, stores)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mg, to, router
+}
+
+func TestMigrationPlan(t *testing.T) {
+	mg, _, _ := mustMigrate(t, "bob", "alice", "lloyd", "aaron", "peter", "jane", "kate")
+
+	r, err := mg.Plan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Accounts != 7 {
+		t.Errorf("Plan() accounts = %d, want 7", r.Accounts)
+	}
+
+	want := map[string]shard.Move{
+		"aaron": {From: "account-0", To: "account-2"},
+		"lloyd": {From: "account-0", To: "account-1"},
+		"peter": {From: "account-1", To: "account-3"},
+		"alice": {From: "account-2", To: "account-1"},
+		"bob":   {From: "account-2", To: "account-0"},
+	}
+	if len(r.Moves) != len(want) {
+		t.Fatalf("Plan() moves = %+v, want %+v", r.Moves, want)
+	}
+	for _, mv := range r.Moves {
+		w := want[mv.User.Username]
+		if mv.From != w.From || mv.To != w.To {
+			t.Errorf("Plan() moves %s from %s to %s, want from %s to %s", mv.User.Username, mv.From, mv.To, w.From, w.To)
+		}
+	}
+}
+
+func TestMigrationPlanNormalized(t *testing.T) {
+	// The accounts are partitioned by the normalized usernames bob and peter.
+	mg, _, _ := mustMigrate(t, " Bob", "PETER")
+
+	r, err := mg.Plan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]shard.Move{
+		" Bob":  {From: "account-2", To: "account-0"},
+		"PETER": {From: "account-1", To: "account-3"},
+	}
+	if len(r.Moves) != len(want) {
+		t.Fatalf("Plan() moves = %+v, want %+v", r.Moves, want)
+	}
+	for _, mv := range r.Moves {
+		w := want[mv.User.Username]
+		if mv.From != w.From || mv.To != w.To {
+			t.Errorf("Plan() moves %q from %s to %s, want from %s to %s", mv.User.Username, mv.From, mv.To, w.From, w.To)
+		}
+	}
+}
+
+func TestMigration(t *testing.T) {
+	usernames := []string{"bob", "alice", "lloyd", "aaron", "peter", "jane", "kate"}
+	mg, to, stores := mustMigrate(t, usernames...)
+
+	ctx := context.Background()
+	r, err := mg.Plan(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every step can be repeated, e.g., when the previous attempt failed half way.
+	for i := 0; i < 2; i++ {
+		if err = mg.Copy(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+		if err = mg.Verify(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+		if err = mg.Cleanup(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	users := make(map[string]account.UserService)
+	for name, s := range stores {
+		users[name] = s
+	}
+	router, err := shard.NewUserService(to, account.FNV1aPartitioner{}, users)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, username := range usernames {
+		u, err := router.ByUsername(ctx, username)
+		if err != nil {
+			t.Errorf("ByUsername(%s) = %v after migration", username, err)
+			continue
+		}
+		if want := "0ujzPyRiIAffKhBux4PvQdDqM" + username[:2]; u.ID != want {
+			t.Errorf("ByUsername(%s) ID = %s, want %s", username, u.ID, want)
+		}
+	}
+
+	var accounts int
+	for _, s := range stores {
+		s.Users(ctx, func(*account.User) error {
+			accounts++
+			return nil
+		})
+	}
+	if accounts != len(usernames) {
+		t.Errorf("Cleanup() left %d accounts, want %d", accounts, len(usernames))
+	}
+}
+
+func TestMigrationResponses(t *testing.T) {
+	stores := make(map[string]shard.Store)
+	for _, name := range []string{"account-0", "account-1", "account-2", "account-3"} {
+		stores[name] = &dedupStore{memory.NewUserService(), memory.NewDedupStore(0, 0)}
+	}
+	usernames := []string{"bob", "alice", "lloyd", "aaron", "peter", "jane", "kate"}
+	mg, to, router := mustMigrateStores(t, stores, usernames...)
+
+	// The responses are recorded in the shards which processed the requests,
+	// including the requests of differently spelled and rejected usernames.
+	ctx := context.Background()
+	requested := append([]string{"Bob", " JANE", "kate!"}, usernames...)
+	for i, username := range requested {
+		req := account.SignupRequest{ID: fmt.Sprintf("0ujzPyRiIAffKhBux4PvQdDq%03d", i), Username: username}
+		resp := account.SignupResponse{RequestID: req.ID, Username: username, Success: true}
+		if err := stores[router.Shard(username).Name].(*dedupStore).SaveResponse(ctx, &req, &resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := mg.Plan(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Responses) == 0 {
+		t.Fatal("Plan() found no responses to move")
+	}
+	for i := 0; i < 2; i++ {
+		if err = mg.Copy(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+		if err = mg.Verify(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+		if err = mg.Cleanup(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Every response is stored only in the shard which owns its username in the new map.
+	users := make(map[string]account.UserService)
+	for name, s := range stores {
+		users[name] = s
+	}
+	newRouter, err := shard.NewUserService(to, account.FNV1aPartitioner{}, users)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, username := range requested {
+		id := fmt.Sprintf("0ujzPyRiIAffKhBux4PvQdDq%03d", i)
+		want := newRouter.Shard(username).Name
+		for name, s := range stores {
+			_, err := s.(*dedupStore).Response(ctx, id)
+			if name == want && err != nil {
+				t.Errorf("Response(%s) of %q = %v in %s, want it there", id, username, err, name)
+			}
+			if name != want && err != account.ErrResponseNotFound {
+				t.Errorf("Response(%s) of %q = %v in %s, want it in %s", id, username, err, name, want)
+			}
+		}
+	}
+}
+
+func TestMigrationConflict(t *testing.T) {
+	mg, _, stores := mustMigrate(t, "bob", "jane")
+
+	// Bob was signed up in two shards, so the migration can't decide who owns the username.
+	ctx := context.Background()
+	other := account.User{ID: "0ujtsYcgvSTl8PAuAdqWYSMnLOv", Username: "bob"}
+	if err := stores["account-0"].CreateUser(ctx, &other); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := mg.Plan(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = mg.Copy(ctx, r); err != shard.ErrMigrationConflict {
+		t.Fatalf("Copy() = %v, must be ErrMigrationConflict", err)
+	}
+	if len(r.Conflicts) != 1 || r.Conflicts[0].User.Username != "bob" {
+		t.Errorf("Copy() conflicts = %+v, want bob", r.Conflicts)
+	}
+	if err = mg.Verify(ctx, r); err == nil {
+		t.Errorf("Verify() must fail because bob has another ID in account-0")
+	}
+}
+
+func TestNewMigrationInvalid(t *testing.T) {
+	m, err := shard.ReadMap(strings.NewReader(`{"epoch": 1, "shards": [{"name": "account-0", "partitions": [0]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stores := map[string]shard.Store{"account-0": memory.NewUserService()}
+	if _, err = shard.NewMigration(m, m, account.FNV1aPartitioner{}, stores); err == nil {
+		t.Errorf("NewMigration() must fail because the epoch is not changed")
+	}
+
+	to, err := shard.ReadMap(strings.NewReader(`{"epoch": 2, "shards": [{"name": "account-1", "partitions": [0]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stores["account-1"] = &dedupStore{memory.NewUserService(), memory.NewDedupStore(0, 0)}
+	if _, err = shard.NewMigration(m, to, account.FNV1aPartitioner{}, stores); err == nil {
+		t.Errorf("NewMigration() must fail because account-0 doesn't record responses")
+	}
+
+	skipped, err := shard.ReadMap(strings.NewReader(`{"epoch": 3, "shards": [{"name": "account-0", "partitions": [0]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = shard.NewMigration(m, skipped, account.FNV1aPartitioner{}, stores); err == nil {
+		t.Errorf("NewMigration() must fail because epoch 2 is skipped")
+	}
+}
@@ -0,0 +1,84 @@
+package shard
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/marselester/distributed-signup"
+)
+
+// liveServers returns the servers seen in any of the registries since the given time.
+// A server registered in several shards is returned once with its latest state.
+func liveServers(ctx context.Context, registries []account.ServerRegistry, since time.Time) ([]account.Server, error) {
+	byID := make(map[string]account.Server)
+	for _, r := range registries {
+		servers, err := r.Servers(ctx, since)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range servers {
+			if seen, ok := byID[s.ID]; !ok || s.SeenAt.After(seen.SeenAt) {
+				byID[s.ID] = s
+			}
+		}
+	}
+
+	servers := make([]account.Server, 0, len(byID))
+	for _, s := range byID {
+		servers = append(servers, s)
+	}
+	sort.Slice(servers, func(i, j int) bool { return servers[i].ID < servers[j].ID })
+	return servers, nil
+}
+
+// CheckServers returns an error if any server seen in the registries since the given time is not in consumer-group mode,
+// because such a server would keep processing requests while user accounts are moved.
+func CheckServers(ctx context.Context, registries []account.ServerRegistry, since time.Time) error {
+	servers, err := liveServers(ctx, registries, since)
+	if err != nil {
+		return err
+	}
+
+	var ids []string
+	for _, s := range servers {
+		if !s.Group {
+			ids = append(ids, s.ID)
+		}
+	}
+	if len(ids) > 0 {
+		return fmt.Errorf("shard: servers %s are not in consumer-group mode", strings.Join(ids, ", "))
+	}
+	return nil
+}
+
+// WaitPaused checks the registries every interval until every server seen in the last ttl acknowledges
+// the paused map of the given epoch. It returns an error listing the servers which haven't paused when ctx is done.
+func WaitPaused(ctx context.Context, registries []account.ServerRegistry, epoch int, ttl, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		servers, err := liveServers(ctx, registries, time.Now().Add(-ttl))
+		if err != nil {
+			return err
+		}
+		var ids []string
+		for _, s := range servers {
+			if s.PausedEpoch != epoch {
+				ids = append(ids, s.ID)
+			}
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("shard: servers %s haven't paused epoch %d: %v", strings.Join(ids, ", "), epoch, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
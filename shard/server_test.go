@@ -0,0 +1,92 @@
+package shard_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/marselester/distributed-signup"
+	"github.com/marselester/distributed-signup/shard"
+)
+
+// registry is an in-memory account.ServerRegistry.
+type registry struct {
+	mu      sync.Mutex
+	servers map[string]account.Server
+}
+
+func (r *registry) RegisterServer(ctx context.Context, s *account.Server) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.servers == nil {
+		r.servers = make(map[string]account.Server)
+	}
+	srv := *s
+	srv.SeenAt = time.Now()
+	r.servers[s.ID] = srv
+	return nil
+}
+
+func (r *registry) Servers(ctx context.Context, since time.Time) ([]account.Server, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var servers []account.Server
+	for _, s := range r.servers {
+		if !s.SeenAt.Before(since) {
+			servers = append(servers, s)
+		}
+	}
+	return servers, nil
+}
+
+func TestCheckServers(t *testing.T) {
+	ctx := context.Background()
+	r0, r1 := &registry{}, &registry{}
+	r0.RegisterServer(ctx, &account.Server{ID: "a", Group: true, PausedEpoch: account.NotPaused})
+	since := time.Now().Add(-time.Minute)
+	if err := shard.CheckServers(ctx, []account.ServerRegistry{r0, r1}, since); err != nil {
+		t.Fatalf("CheckServers() = %v, want nil", err)
+	}
+
+	// The server which reads a single partition uses the second shard.
+	r1.RegisterServer(ctx, &account.Server{ID: "b", PausedEpoch: account.NotPaused})
+	if err := shard.CheckServers(ctx, []account.ServerRegistry{r0, r1}, since); err == nil {
+		t.Errorf("CheckServers() must fail because b is not in consumer-group mode")
+	}
+	// The server is gone if it hasn't been seen lately.
+	if err := shard.CheckServers(ctx, []account.ServerRegistry{r0, r1}, time.Now().Add(time.Minute)); err != nil {
+		t.Errorf("CheckServers(in a minute) = %v, want nil", err)
+	}
+}
+
+func TestWaitPaused(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	r0, r1 := &registry{}, &registry{}
+	// The server a uses both shards, and it registers in the second shard later.
+	r0.RegisterServer(ctx, &account.Server{ID: "a", Group: true, PausedEpoch: 2})
+	r1.RegisterServer(ctx, &account.Server{ID: "a", Group: true, PausedEpoch: account.NotPaused})
+	r1.RegisterServer(ctx, &account.Server{ID: "b", Group: true, PausedEpoch: account.NotPaused})
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		r1.RegisterServer(ctx, &account.Server{ID: "a", Group: true, PausedEpoch: 2})
+		r1.RegisterServer(ctx, &account.Server{ID: "b", Group: true, PausedEpoch: 2})
+	}()
+	if err := shard.WaitPaused(ctx, []account.ServerRegistry{r0, r1}, 2, time.Minute, time.Millisecond); err != nil {
+		t.Errorf("WaitPaused() = %v, want nil", err)
+	}
+}
+
+func TestWaitPausedTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	r := &registry{}
+	// The server paused the previous epoch.
+	r.RegisterServer(ctx, &account.Server{ID: "a", Group: true, PausedEpoch: 1})
+
+	if err := shard.WaitPaused(ctx, []account.ServerRegistry{r}, 2, time.Minute, time.Millisecond); err == nil {
+		t.Errorf("WaitPaused() must fail because a hasn't paused epoch 2")
+	}
+}
@@ -0,0 +1,62 @@
+package shard
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/marselester/distributed-signup"
+)
+
+// UserService routes requests to the user services of the shards which own usernames' partitions,
+// e.g., it answers whether bob exists by looking him up in the shard of his partition.
+// A username is assigned a partition by the same partitioner which assigns a partition
+// to a message of account.signup_request topic keyed by the normalized username, see kafka.WithPartitioner.
+type UserService struct {
+	shards      *Map
+	partitioner account.Partitioner
+	partitions  int32
+	users       map[string]account.UserService
+}
+
+// NewUserService returns a UserService which routes requests to users services by shard name.
+// The shards must own all the partitions of the topic, i.e., from 0 to the number of partitions.
+func NewUserService(m *Map, p account.Partitioner, users map[string]account.UserService) (*UserService, error) {
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+	s := UserService{
+		shards:      m,
+		partitioner: p,
+		partitions:  m.Partitions(),
+		users:       users,
+	}
+	for _, sh := range m.Shards {
+		if users[sh.Name] == nil {
+			return nil, fmt.Errorf("shard: no user service for %s", sh.Name)
+		}
+	}
+	return &s, nil
+}
+
+// Shard returns the shard which owns the username, i.e., the shard of the normalized username's partition.
+func (s *UserService) Shard(username string) *Shard {
+	return s.shards.byPartition[s.partitioner.Partition(account.NormalizeUsername(username), s.partitions)]
+}
+
+// CreateUser creates a user in the shard which owns the username.
+func (s *UserService) CreateUser(ctx context.Context, u *account.User) error {
+	return s.users[s.Shard(u.Username).Name].CreateUser(ctx, u)
+}
+
+// ByUsername looks up a user in the shard which owns the username.
+// Accounts are stored by normalized usernames, so the username is normalized for the lookup as well.
+// It returns account.ErrUserNotFound if the user is not found there.
+func (s *UserService) ByUsername(ctx context.Context, username string) (*account.User, error) {
+	name := account.NormalizeUsername(username)
+	return s.users[s.Shard(name).Name].ByUsername(ctx, name)
+}
+
+// ClaimUsername claims the username in the shard which owns it.
+func (s *UserService) ClaimUsername(ctx context.Context, u *account.User) (bool, *account.User, error) {
+	return s.users[s.Shard(u.Username).Name].ClaimUsername(ctx, u)
+}
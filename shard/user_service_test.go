@@ -0,0 +1,161 @@
+package shard_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/marselester/distributed-signup"
+	"github.com/marselester/distributed-signup/accounttest"
+	"github.com/marselester/distributed-signup/memory"
+	"github.com/marselester/distributed-signup/shard"
+)
+
+// Ensure shard.UserService implements account.UserService.
+var _ account.UserService = &shard.UserService{}
+
+// mustRoute returns a UserService which routes requests to memory user services of three shards.
+func mustRoute(t *testing.T) (*shard.UserService, map[string]account.UserService) {
+	m, err := shard.ReadMap(strings.NewReader(`{"shards": [
+		{"name": "account-0", "partitions": [0]},
+		{"name": "account-1", "partitions": [1]},
+		{"name": "account-2", "partitions": [2]}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := map[string]account.UserService{
+		"account-0": memory.NewUserService(),
+		"account-1": memory.NewUserService(),
+		"account-2": memory.NewUserService(),
+	}
+	s, err := shard.NewUserService(m, account.FNV1aPartitioner{}, users)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, users
+}
+
+func TestUserService(t *testing.T) {
+	s, users := mustRoute(t)
+
+	ctx := context.Background()
+	tests := map[string]string{"bob": "account-2", "lloyd": "account-0", "peter": "account-1"}
+	for username, want := range tests {
+		u := account.User{ID: "0ujzPyRiIAffKhBux4PvQdDqMH" + username[:1], Username: username}
+		if err := s.CreateUser(ctx, &u); err != nil {
+			t.Fatal(err)
+		}
+
+		if sh := s.Shard(username); sh.Name != want {
+			t.Errorf("Shard(%s) = %s, want %s", username, sh.Name, want)
+		}
+		if _, err := users[want].ByUsername(ctx, username); err != nil {
+			t.Errorf("CreateUser(%+v) must store the user in %s: %v", u, want, err)
+		}
+		got, err := s.ByUsername(ctx, username)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *got != u {
+			t.Errorf("ByUsername(%s) = %+v, want %+v", username, got, u)
+		}
+	}
+
+	if _, err := s.ByUsername(ctx, "sam"); err != account.ErrUserNotFound {
+		t.Errorf("ByUsername(sam) = %v, must be ErrUserNotFound", err)
+	}
+}
+
+func TestUserServiceNormalized(t *testing.T) {
+	s, users := mustRoute(t)
+
+	// All spellings of bob are routed to the shard of bob's partition 2 like the producers key them.
+	for _, username := range []string{"bob", "Bob", "BOB", " bob ", "  Bob"} {
+		if sh := s.Shard(username); sh.Name != "account-2" {
+			t.Errorf("Shard(%q) = %s, want account-2", username, sh.Name)
+		}
+	}
+
+	ctx := context.Background()
+	u := account.User{ID: "0ujzPyRiIAffKhBux4PvQdDqMHY", Username: "  Peter"}
+	if err := s.CreateUser(ctx, &u); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users["account-1"].ByUsername(ctx, u.Username); err != nil {
+		t.Errorf("CreateUser(%+v) must store the user in account-1: %v", u, err)
+	}
+}
+
+func TestByUsernameNormalized(t *testing.T) {
+	s, _ := mustRoute(t)
+
+	// Accounts are stored by normalized usernames, see account.UsernamePolicy.Apply.
+	ctx := context.Background()
+	u := account.User{ID: "0ujzPyRiIAffKhBux4PvQdDqMHY", Username: "bob"}
+	if err := s.CreateUser(ctx, &u); err != nil {
+		t.Fatal(err)
+	}
+	for _, username := range []string{"bob", "Bob", "BOB", " bob "} {
+		got, err := s.ByUsername(ctx, username)
+		if err != nil {
+			t.Fatalf("ByUsername(%q) = %v, want %+v", username, err, u)
+		}
+		if got.ID != u.ID {
+			t.Errorf("ByUsername(%q) = %+v, want %+v", username, got, u)
+		}
+	}
+}
+
+func TestUserServicePartitioner(t *testing.T) {
+	m, err := shard.ReadMap(strings.NewReader(`{"shards": [
+		{"name": "account-0", "partitions": [0]},
+		{"name": "account-1", "partitions": [1]},
+		{"name": "account-2", "partitions": [2]}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := map[string]account.UserService{
+		"account-0": memory.NewUserService(),
+		"account-1": memory.NewUserService(),
+		"account-2": memory.NewUserService(),
+	}
+
+	var p account.Murmur2Partitioner
+	s, err := shard.NewUserService(m, p, users)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, username := range []string{"bob", "alice", "john", "lloyd", "aaron", "peter", "sam"} {
+		want := fmt.Sprintf("account-%d", p.Partition(username, 3))
+		if sh := s.Shard(username); sh.Name != want {
+			t.Errorf("Shard(%s) = %s, want %s", username, sh.Name, want)
+		}
+	}
+}
+
+func TestUserServiceConformance(t *testing.T) {
+	accounttest.TestUserService(t, func(t *testing.T) (account.UserService, func()) {
+		s, _ := mustRoute(t)
+		return s, func() {}
+	})
+}
+
+func TestNewUserServiceInvalid(t *testing.T) {
+	users := map[string]account.UserService{
+		"account-0": memory.NewUserService(),
+	}
+	if _, err := shard.NewUserService(&shard.Map{}, account.FNV1aPartitioner{}, users); err == nil {
+		t.Errorf("NewUserService() must fail because no shard owns partitions")
+	}
+
+	m, err := shard.ReadMap(strings.NewReader(`{"shards": [{"name": "account-0", "partitions": [0]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = shard.NewUserService(m, account.FNV1aPartitioner{}, nil); err == nil {
+		t.Errorf("NewUserService() must fail because account-0 has no user service")
+	}
+}
@@ -0,0 +1,170 @@
+package account
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	// ErrUsernameTooShort error indicates that a username has fewer characters than a UsernamePolicy allows.
+	ErrUsernameTooShort = Error("username is too short")
+	// ErrUsernameTooLong error indicates that a username has more characters than a UsernamePolicy allows.
+	ErrUsernameTooLong = Error("username is too long")
+	// ErrUsernameCharset error indicates that a username has characters other than letters, digits, and "._-",
+	// or it doesn't start with a letter or a digit.
+	ErrUsernameCharset = Error("username has disallowed characters")
+	// ErrUsernameMixedScripts error indicates that a username has letters of different scripts, e.g., Latin and Cyrillic.
+	ErrUsernameMixedScripts = Error("username mixes scripts")
+	// ErrUsernameConfusable error indicates that a username looks like a Latin username, e.g., Cyrillic "рор".
+	ErrUsernameConfusable = Error("username is confusable with latin")
+)
+
+const (
+	// Default min number of characters in a username.
+	defaultMinUsernameLength = 3
+	// Default max number of characters in a username, see pg.UserSchema.
+	defaultMaxUsernameLength = 40
+)
+
+// scripts are the writing systems a username can be written in.
+// Letters of a username must belong to one of them, e.g., Japanese usernames can mix Han and Kana.
+var scripts = []struct {
+	name   string
+	tables []*unicode.RangeTable
+}{
+	{"latin", []*unicode.RangeTable{unicode.Latin}},
+	{"cyrillic", []*unicode.RangeTable{unicode.Cyrillic}},
+	{"greek", []*unicode.RangeTable{unicode.Greek}},
+	{"armenian", []*unicode.RangeTable{unicode.Armenian}},
+	{"georgian", []*unicode.RangeTable{unicode.Georgian}},
+	{"hebrew", []*unicode.RangeTable{unicode.Hebrew}},
+	{"arabic", []*unicode.RangeTable{unicode.Arabic}},
+	{"devanagari", []*unicode.RangeTable{unicode.Devanagari}},
+	{"thai", []*unicode.RangeTable{unicode.Thai}},
+	{"hangul", []*unicode.RangeTable{unicode.Hangul, unicode.Han}},
+	{"japanese", []*unicode.RangeTable{unicode.Han, unicode.Hiragana, unicode.Katakana}},
+	{"chinese", []*unicode.RangeTable{unicode.Han, unicode.Bopomofo}},
+}
+
+// latinSkeleton maps case-folded Cyrillic and Greek letters to Latin letters they look like,
+// see confusables of Unicode Technical Standard #39 https://www.unicode.org/reports/tr39/.
+var latinSkeleton = map[rune]rune{
+	'а': 'a', 'ь': 'b', 'с': 'c', 'ԁ': 'd', 'е': 'e', 'һ': 'h', 'і': 'i', 'ј': 'j', 'к': 'k',
+	'о': 'o', 'р': 'p', 'ԛ': 'q', 'ѕ': 's', 'у': 'y', 'х': 'x', 'ԝ': 'w',
+	'α': 'a', 'ϲ': 'c', 'ι': 'i', 'κ': 'k', 'ν': 'v', 'ο': 'o', 'ρ': 'p', 'υ': 'u', 'χ': 'x',
+}
+
+// UsernamePolicy decides whether a username can be claimed.
+// Usernames are compared after normalization, so "Bob", "bob", and "ｂｏｂ" are the same username.
+type UsernamePolicy struct {
+	minLength int
+	maxLength int
+}
+
+// UsernamePolicyOption configures how we set up the UsernamePolicy.
+type UsernamePolicyOption func(*UsernamePolicy)
+
+// WithUsernameLength sets min and max number of characters in a normalized username.
+func WithUsernameLength(min, max int) UsernamePolicyOption {
+	return func(p *UsernamePolicy) {
+		p.minLength = min
+		p.maxLength = max
+	}
+}
+
+// NewUsernamePolicy returns a UsernamePolicy which can be configured with options.
+// By default a username has from 3 to 40 characters.
+func NewUsernamePolicy(options ...UsernamePolicyOption) *UsernamePolicy {
+	p := UsernamePolicy{
+		minLength: defaultMinUsernameLength,
+		maxLength: defaultMaxUsernameLength,
+	}
+
+	for _, opt := range options {
+		opt(&p)
+	}
+	return &p
+}
+
+// NormalizeUsername returns the canonical form of the username: surrounding spaces are trimmed,
+// compatibility characters such as full-width letters are replaced (NFKC), and the case is folded.
+// Producers use it as a partition key, so all spellings of a username are stored in the same partition.
+func NormalizeUsername(username string) string {
+	s := norm.NFKC.String(strings.TrimSpace(username))
+	return norm.NFKC.String(cases.Fold().String(s))
+}
+
+// Apply normalizes the username and validates it, see Validate.
+// It returns the normalized username which should be claimed instead of the requested one.
+func (p *UsernamePolicy) Apply(username string) (string, error) {
+	name := NormalizeUsername(username)
+	return name, p.Validate(name)
+}
+
+// Validate checks that the normalized username has allowed length and characters,
+// its letters belong to one script, and it is not confusable with a Latin username.
+func (p *UsernamePolicy) Validate(name string) error {
+	switch n := utf8.RuneCountInString(name); {
+	case n < p.minLength:
+		return ErrUsernameTooShort
+	case n > p.maxLength:
+		return ErrUsernameTooLong
+	}
+
+	for i, r := range name {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+		case i > 0 && (r == '_' || r == '.' || r == '-'):
+		default:
+			return ErrUsernameCharset
+		}
+	}
+
+	script := usernameScript(name)
+	if script == "" {
+		return ErrUsernameMixedScripts
+	}
+	if script != "latin" && isLatinConfusable(name) {
+		return ErrUsernameConfusable
+	}
+	return nil
+}
+
+// usernameScript returns the script which all the letters of the name belong to,
+// or a blank string if there is no such script.
+func usernameScript(name string) string {
+	for _, s := range scripts {
+		if inScript(name, s.tables) {
+			return s.name
+		}
+	}
+	return ""
+}
+
+// inScript reports whether all the letters of the name belong to the given tables.
+func inScript(name string, tables []*unicode.RangeTable) bool {
+	for _, r := range name {
+		if unicode.IsLetter(r) && !unicode.In(r, tables...) {
+			return false
+		}
+	}
+	return true
+}
+
+// isLatinConfusable reports whether every letter of the name looks like a Latin letter,
+// i.e., the name's skeleton is a Latin username.
+func isLatinConfusable(name string) bool {
+	for _, r := range name {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		if _, ok := latinSkeleton[r]; !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,63 @@
+package account_test
+
+import (
+	"testing"
+
+	"github.com/marselester/distributed-signup"
+)
+
+func TestNormalizeUsername(t *testing.T) {
+	tests := map[string]string{
+		"bob":    "bob",
+		"Bob":    "bob",
+		"bob ":   "bob",
+		" BOB":   "bob",
+		"ｂｏｂ":    "bob",
+		"Straße": "strasse",
+		"Ьоb":    "ьоb",
+	}
+	for username, want := range tests {
+		if got := account.NormalizeUsername(username); got != want {
+			t.Errorf("NormalizeUsername(%q) = %q, want %q", username, got, want)
+		}
+	}
+}
+
+func TestUsernamePolicy(t *testing.T) {
+	p := account.NewUsernamePolicy()
+	tests := map[string]struct {
+		want string
+		err  error
+	}{
+		"Bob":          {want: "bob"},
+		"bob.smith-42": {want: "bob.smith-42"},
+		"иван":         {want: "иван"},
+		"ΑΘΗΝΑ":        {want: "αθηνα"},
+		"山田たろう":        {want: "山田たろう"},
+		"bo":           {want: "bo", err: account.ErrUsernameTooShort},
+		"bobbobbobbobbobbobbobbobbobbobbobbobbobbo": {want: "bobbobbobbobbobbobbobbobbobbobbobbobbobbo", err: account.ErrUsernameTooLong},
+		"bob smith": {want: "bob smith", err: account.ErrUsernameCharset},
+		"_bob":      {want: "_bob", err: account.ErrUsernameCharset},
+		"bob!":      {want: "bob!", err: account.ErrUsernameCharset},
+		// Cyrillic soft sign and o followed by Latin b.
+		"Ьоb": {want: "ьоb", err: account.ErrUsernameMixedScripts},
+		// Cyrillic word which looks like Latin pop.
+		"рор": {want: "рор", err: account.ErrUsernameConfusable},
+	}
+	for username, tc := range tests {
+		got, err := p.Apply(username)
+		if got != tc.want || err != tc.err {
+			t.Errorf("Apply(%q) = %q, %v, want %q, %v", username, got, err, tc.want, tc.err)
+		}
+	}
+}
+
+func TestUsernamePolicyLength(t *testing.T) {
+	p := account.NewUsernamePolicy(account.WithUsernameLength(1, 5))
+	if _, err := p.Apply("b"); err != nil {
+		t.Errorf("Apply(b) = %v, want nil", err)
+	}
+	if _, err := p.Apply("bobbob"); err != account.ErrUsernameTooLong {
+		t.Errorf("Apply(bobbob) = %v, must be ErrUsernameTooLong", err)
+	}
+}